@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"tiago-udemy/internal/mailer"
+	"tiago-udemy/internal/store"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// ListUsers godoc
+//
+//	@Summary		Lists users
+//	@Description	Lists and searches users by username or email
+//	@Tags			admin
+//	@Produce		json
+//	@Param			search	query		string	false	"Username or email contains"
+//	@Param			role	query		string	false	"Role name"
+//	@Param			limit	query		int		false	"Page size"
+//	@Param			offset	query		int		false	"Page offset"
+//	@Param			sort	query		string	false	"Sort by creation date (asc, desc)"
+//	@Success		200		{array}		store.User
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		403		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/admin/users [get]
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+
+	uq := store.PaginatedUserQuery{
+		Limit:  20,
+		Offset: 0,
+		Sort:   "desc",
+	}
+	uq, err := uq.Parse(w, r)
+	if err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(uq); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	users, err := app.store.Users.GetUsers(ctx, uq)
+	if err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, users); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+type UpdateUserRolePayload struct {
+	Role string `json:"role" validate:"required,max=255"`
+}
+
+// UpdateUserRole godoc
+//
+//	@Summary		Changes a user's role
+//	@Description	Changes a user's role by name
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			userID	path		int						true	"User ID"
+//	@Param			payload	body		UpdateUserRolePayload	true	"Role payload"
+//	@Success		200		{object}	store.Role
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		403		{object}	Problem
+//	@Failure		404		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/admin/users/{userID}/role [patch]
+func (app *application) updateUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	targetUser := getTargetUserCtx(r)
+	admin := getUserCtx(r)
+
+	if targetUser.ID == admin.ID {
+		app.StatusBadRequest(w, r, clientErrorf("admins cannot change their own role"))
+		return
+	}
+
+	var payload UpdateUserRolePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	role, err := app.store.Users.UpdateRole(ctx, targetUser.ID, payload.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrRecordNotFound):
+			app.RecordNotFound(w, r, clientErrorf("role %q not found", payload.Role))
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	// the role may have been created on another replica since the last refresh
+	app.refreshPermissions(ctx)
+
+	if err := app.jsonResponse(w, http.StatusOK, role); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+// DeactivateUser godoc
+//
+//	@Summary		Deactivates a user
+//	@Description	Disables a user account so it can no longer log in or use its tokens and API keys. Email confirmation is not affected
+//	@Tags			admin
+//	@Produce		json
+//	@Param			userID	path		int		true	"User ID"
+//	@Success		204		{string}	string	"User deactivated"
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		403		{object}	Problem
+//	@Failure		404		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/admin/users/{userID}/deactivate [put]
+func (app *application) deactivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	app.setUserDisabled(w, r, true)
+}
+
+// ReactivateUser godoc
+//
+//	@Summary		Reactivates a user
+//	@Description	Re-enables a deactivated user account
+//	@Tags			admin
+//	@Produce		json
+//	@Param			userID	path		int		true	"User ID"
+//	@Success		204		{string}	string	"User reactivated"
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		403		{object}	Problem
+//	@Failure		404		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/admin/users/{userID}/reactivate [put]
+func (app *application) reactivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	app.setUserDisabled(w, r, false)
+}
+
+func (app *application) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	targetUser := getTargetUserCtx(r)
+	admin := getUserCtx(r)
+
+	if targetUser.ID == admin.ID {
+		app.StatusBadRequest(w, r, clientErrorf("admins cannot change their own account status"))
+		return
+	}
+
+	ctx := r.Context()
+	if err := app.store.Users.SetDisabled(ctx, targetUser.ID, disabled); err != nil {
+		switch {
+		case errors.Is(err, store.ErrRecordNotFound):
+			app.RecordNotFound(w, r, err)
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+// ForcePasswordReset godoc
+//
+//	@Summary		Forces a password reset
+//	@Description	Invalidates a user's password, ends their sessions, revokes their API keys and emails them a reset link
+//	@Tags			admin
+//	@Produce		json
+//	@Param			userID	path		int		true	"User ID"
+//	@Success		202		{string}	string	"Password reset sent"
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		403		{object}	Problem
+//	@Failure		404		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/admin/users/{userID}/password-reset [post]
+func (app *application) forcePasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	targetUser := getTargetUserCtx(r)
+
+	plainToken := uuid.New().String()
+
+	hash := sha256.Sum256([]byte(plainToken))
+	hashToken := hex.EncodeToString(hash[:])
+
+	ctx := r.Context()
+	if _, err := app.store.Users.ForcePasswordReset(ctx, targetUser.ID, hashToken, app.config.mail.exp); err != nil {
+		switch {
+		case errors.Is(err, store.ErrRecordNotFound):
+			app.RecordNotFound(w, r, err)
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	resetURL := fmt.Sprintf("%s/reset-password/%s", app.config.frontendURL, plainToken)
+	data := struct {
+		Username string
+		ResetURL string
+	}{
+		Username: targetUser.Username,
+		ResetURL: resetURL,
+	}
+
+	status, err := app.mailer.Send(ctx, mailer.PasswordResetTemplate, targetUser.Email, data)
+	if err != nil {
+		// the password is already invalidated, the admin can trigger the reset again
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	app.logger.Infow("Email sent", "status code", status)
+
+	if err := app.jsonResponse(w, http.StatusAccepted, nil); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+// ListRoles godoc
+//
+//	@Summary		Lists roles
+//	@Description	Lists roles ordered by level
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{array}		store.Role
+//	@Failure		401	{object}	Problem
+//	@Failure		403	{object}	Problem
+//	@Failure		500	{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/admin/roles [get]
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.store.Role.GetAll(r.Context())
+	if err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, roles); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+type CreateRolePayload struct {
+	Name        string   `json:"name" validate:"required,max=255"`
+	Level       int      `json:"level" validate:"gte=0"`
+	Description string   `json:"description" validate:"max=1000"`
+	Permissions []string `json:"permissions" validate:"dive,max=255"`
+}
+
+// CreateRole godoc
+//
+//	@Summary		Creates a role
+//	@Description	Creates a role with a display level and a set of permissions
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreateRolePayload	true	"Role payload"
+//	@Success		201		{object}	store.Role
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		403		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/admin/roles [post]
+func (app *application) createRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateRolePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	role := &store.Role{
+		Name:        payload.Name,
+		Level:       payload.Level,
+		Description: payload.Description,
+		Permissions: payload.Permissions,
+	}
+
+	ctx := r.Context()
+	if err := app.store.Role.Create(ctx, role); err != nil {
+		switch {
+		case errors.Is(err, store.ErrDuplicateRole), errors.Is(err, store.ErrUnknownPermission):
+			app.StatusBadRequest(w, r, err)
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	app.refreshPermissions(ctx)
+
+	if err := app.jsonResponse(w, http.StatusCreated, role); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+type UpdateRolePermissionsPayload struct {
+	Permissions []string `json:"permissions" validate:"required,dive,max=255"`
+}
+
+// UpdateRolePermissions godoc
+//
+//	@Summary		Replaces a role's permissions
+//	@Description	Replaces the permissions granted to a role
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			roleID	path		int								true	"Role ID"
+//	@Param			payload	body		UpdateRolePermissionsPayload	true	"Permissions payload"
+//	@Success		204		{string}	string							"Permissions updated"
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		403		{object}	Problem
+//	@Failure		404		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/admin/roles/{roleID}/permissions [put]
+func (app *application) updateRolePermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	roleID, err := strconv.ParseInt(chi.URLParam(r, "roleID"), 10, 64)
+	if err != nil || roleID <= 0 {
+		app.StatusBadRequest(w, r, clientErrorf("invalid roleID"))
+		return
+	}
+
+	var payload UpdateRolePermissionsPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	if err := app.store.Role.SetPermissions(ctx, roleID, payload.Permissions); err != nil {
+		switch {
+		case errors.Is(err, store.ErrRecordNotFound):
+			app.RecordNotFound(w, r, err)
+			return
+		case errors.Is(err, store.ErrUnknownPermission):
+			app.StatusBadRequest(w, r, err)
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	app.refreshPermissions(ctx)
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+// ListPermissions godoc
+//
+//	@Summary		Lists permissions
+//	@Description	Lists every permission that can be granted to a role
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{array}		store.Permission
+//	@Failure		401	{object}	Problem
+//	@Failure		403	{object}	Problem
+//	@Failure		500	{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/admin/permissions [get]
+func (app *application) listPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	permissions, err := app.store.Role.GetPermissions(r.Context())
+	if err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, permissions); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+// refreshPermissions reloads the permission snapshot after a role or a user's
+// role changed. Other replicas pick the change up on their next periodic refresh.
+func (app *application) refreshPermissions(ctx context.Context) {
+	if err := app.permissions.Refresh(ctx); err != nil {
+		app.logger.Errorw("permission refresh failed", "error", err)
+	}
+}
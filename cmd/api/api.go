@@ -3,14 +3,18 @@ package main
 import (
 	"context"
 	"errors"
+	"expvar"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"tiago-udemy/internal/auth"
+	"tiago-udemy/internal/blob"
+	"tiago-udemy/internal/flags"
 	"tiago-udemy/internal/mailer"
 	"tiago-udemy/internal/ratelimiter"
 	"tiago-udemy/internal/store"
@@ -30,12 +34,23 @@ type application struct {
 	logger        *zap.SugaredLogger
 	mailer        mailer.MailClient // this is the mailer interface
 	authenticator auth.Authenticator
-	cache         cache.CacheStorage
-	limiter       ratelimiter.Limiter
+	limiters      map[string]ratelimiter.Limiter // keyed by rate limit policy
+	permissions   *auth.PermissionCache
+	blobs         blob.BlobStore
+	oidcProviders map[string]*auth.OIDCProvider
+	flags         *flags.Set
+	readiness     []dependencyCheck
+	draining      atomic.Bool // set on shutdown so /readyz fails while requests drain
+
+	// settings applied on config reload, see reload.go
+	logLevel   zap.AtomicLevel
+	caches     cache.CacheStorage
+	localCache *cache.TieredBackend // nil unless the cache strategy is tiered
 }
 
 type config struct {
 	addr          string
+	adminAddr     string
 	dbConfig      dbConfig
 	env           string
 	apiURL        string
@@ -44,6 +59,13 @@ type config struct {
 	authConfig    authConfig
 	cacheConfig   cacheConfig
 	limiterConfig limiterConfig
+	mediaConfig   mediaConfig
+	oidcConfig    oidcConfig
+	dataJobs      dataJobsConfig
+	tracing       tracingConfig
+	accessLog     accessLogConfig
+	health        healthConfig
+	flags         flagsConfig
 }
 
 type mailConfig struct {
@@ -61,11 +83,21 @@ type dbConfig struct {
 	maxOpenConns int
 	maxIdleConns int
 	maxIdleTime  string
+	checkSchema  bool
 }
 
 type cacheConfig struct {
-	redis   redisConfig
-	enabled bool
+	redis    redisConfig
+	enabled  bool
+	ttl      time.Duration
+	strategy string
+	local    localCacheConfig
+}
+
+// localCacheConfig sizes the in-process tier of the tiered strategy
+type localCacheConfig struct {
+	size int
+	ttl  time.Duration
 }
 
 type redisConfig struct {
@@ -73,8 +105,12 @@ type redisConfig struct {
 }
 
 type authConfig struct {
-	basicAuth basicAuth
-	jwtAuth   jwtAuth
+	basicAuth         basicAuth
+	jwtAuth           jwtAuth
+	permissionRefresh time.Duration
+	reauthWindow      time.Duration // how recent a login must be for users without a password
+	lockout           lockoutConfig
+	twoFactor         twoFactorConfig
 }
 
 type basicAuth struct {
@@ -88,7 +124,30 @@ type jwtAuth struct {
 	exp    time.Duration
 }
 
+type mediaConfig struct {
+	dir            string
+	maxUploadBytes int64
+	thumbnailSize  int
+	orphanTTL      time.Duration
+	gcInterval     time.Duration
+}
+
+// Rate limit policies, declared per route group in mount
+const (
+	rateLimitDefault  = "default"
+	rateLimitLogin    = "login"
+	rateLimitRegister = "register"
+	// rateLimitAuthFailure is charged by UserAuthMiddleware, not by
+	// RateLimitingMiddleware
+	rateLimitAuthFailure = "auth_failure"
+)
+
 type limiterConfig struct {
+	strategy string
+	policies map[string]rateLimitPolicy
+}
+
+type rateLimitPolicy struct {
 	window     time.Duration
 	maxRequest int
 }
@@ -98,56 +157,175 @@ func (app *application) mount() http.Handler {
 
 	// A good base middleware stack
 	r.Use(middleware.RequestID)
+	r.Use(RequestIDHeaderMiddleware)
 	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
-	r.Use(app.RateLimitingMiddleware)
+	r.Use(app.TracingMiddleware)
+	r.Use(app.AccessLogMiddleware(app.config.accessLog))
+	r.Use(app.MetricsMiddleware)
+	r.Use(app.RecoverPanic)
 
 	// Set a timeout value on the request context (ctx), that will signal
 	// through ctx.Done() that the request has timed out and further
 	// processing should be stopped.
 	r.Use(middleware.Timeout(60 * time.Second))
+	r.Use(app.FlagsMiddleware)
+
+	// unmatched requests get a problem like every other error
+	r.NotFound(app.notFoundResponse)
+	r.MethodNotAllowed(func(w http.ResponseWriter, req *http.Request) {
+		app.methodNotAllowedResponse(w, req, allowedMethods(r, req.URL.Path))
+	})
+
+	// probes for the orchestrator, not rate limited
+	r.Get("/livez", app.livezHandler)
+	r.Get("/readyz", app.readyzHandler)
 
 	r.Route("/v1", func(r chi.Router) {
-		docsURL := fmt.Sprintf("%s/v1/swagger/doc.json", app.config.addr)
-		r.Get("/swagger/*", httpSwagger.Handler(httpSwagger.URL(docsURL)))
-		r.Get("/health", app.healthCheckHandler)
+		r.With(app.RateLimitingMiddleware(rateLimitDefault)).Get("/health", app.healthCheckHandler)
+
+		// operator only routes
+		r.Group(func(r chi.Router) {
+			r.Use(app.RateLimitingMiddleware(rateLimitDefault))
+			r.Use(app.BasicAuthMiddleware())
+			docsURL := fmt.Sprintf("%s/v1/swagger/doc.json", app.config.addr)
+			r.Get("/swagger/*", httpSwagger.Handler(httpSwagger.URL(docsURL)))
+			r.Get("/debug/vars", expvar.Handler().ServeHTTP)
+		})
 
-		r.Route("/posts", func(r chi.Router) {
+		// authenticated routes are rate limited per user, rejected credentials
+		// are charged to the client's IP by UserAuthMiddleware. API keys only
+		// reach routes that declare a scope.
+		r.Group(func(r chi.Router) {
 			r.Use(app.UserAuthMiddleware)
-			r.Post("/", app.createPostHandler)
-			r.Route("/{postID}", func(r chi.Router) {
-				r.Use(app.postContextMiddleWare)
-				r.With(app.UserPostAuthorizationMiddleware("moderator")).Get("/", app.getPostHandler)
-				r.With(app.UserPostAuthorizationMiddleware("admin")).Delete("/", app.deletePostHandler)
-				r.With(app.UserPostAuthorizationMiddleware("moderator")).Patch("/", app.updatePostHandler)
+			r.Use(app.RateLimitingMiddleware(rateLimitDefault))
+
+			r.Route("/posts", func(r chi.Router) {
+				r.With(app.RequireScope(auth.ScopePostsWrite)).Post("/", app.createPostHandler)
+				r.Route("/{postID}", func(r chi.Router) {
+					r.Use(app.postContextMiddleWare)
+					r.With(app.RequireScope(auth.ScopePostsRead), app.UserPostAuthorizationMiddleware(auth.PermissionPostsReadAny)).Get("/", app.getPostHandler)
+					r.With(app.RequireScope(auth.ScopePostsWrite), app.UserPostAuthorizationMiddleware(auth.PermissionPostsDeleteAny), app.postIfMatchMiddleware).Delete("/", app.deletePostHandler)
+					r.With(app.RequireScope(auth.ScopePostsWrite), app.UserPostAuthorizationMiddleware(auth.PermissionPostsUpdateAny), app.postIfMatchMiddleware).Patch("/", app.updatePostHandler)
+					r.With(app.RequireScope(auth.ScopePostsWrite)).Post("/attachments", app.attachToPostHandler)
+				})
+			})
+			r.Route("/comments", func(r chi.Router) {
+				r.Use(app.RequireScope(auth.ScopeCommentsWrite))
+				r.Post("/", app.createCommentHandler)
+				r.Delete("/{commentID}", app.deleteCommentHandler)
 			})
-		})
-		r.Route("/comments", func(r chi.Router) {
-			r.Use(app.UserAuthMiddleware)
-			r.Post("/", app.createCommentHandler)
-		})
 
-		r.Route("/users", func(r chi.Router) {
-			r.Use(app.UserAuthMiddleware)
-			r.Route("/{userID}", func(r chi.Router) {
-				r.Use(app.GetTargetUserMiddlewareContext)
-				r.Get("/", app.getUserHandler)
-				r.Put("/follow", app.followUserHandler)
-				r.Put("/unfollow", app.unfollowUserHandler)
+			r.With(app.RequireScope(auth.ScopePostsWrite)).Post("/uploads", app.uploadAttachmentHandler)
 
+			r.Route("/attachments/{attachmentID}", func(r chi.Router) {
+				r.Use(app.RequireScope(auth.ScopePostsRead))
+				r.Get("/", app.getAttachmentHandler)
+				r.Get("/thumbnail", app.getAttachmentThumbnailHandler)
 			})
 
+			r.Route("/users", func(r chi.Router) {
+				r.Route("/{userID}", func(r chi.Router) {
+					r.Use(app.GetTargetUserMiddlewareContext)
+					r.With(app.RequireScope(auth.ScopeUsersRead)).Get("/", app.getUserHandler)
+					r.With(app.RequireScope(auth.ScopeUsersWrite)).Put("/follow", app.followUserHandler)
+					r.With(app.RequireScope(auth.ScopeUsersWrite)).Put("/unfollow", app.unfollowUserHandler)
+
+				})
+
+				r.Group(func(r chi.Router) {
+					r.With(app.RequireScope(auth.ScopePostsRead)).Get("/feed", app.userFeedHandler)
+				})
+			})
+
+			// account and staff routes need an interactive login
 			r.Group(func(r chi.Router) {
-				r.Get("/feed", app.userFeedHandler)
+				r.Use(app.DenyAPIKeyMiddleware)
+
+				r.Post("/reports", app.createReportHandler)
+
+				r.Route("/me", func(r chi.Router) {
+					r.Get("/", app.getMeHandler)
+					r.Patch("/", app.updateMeHandler)
+					r.Delete("/", app.deleteMeHandler)
+					r.Put("/email", app.changeEmailHandler)
+					r.Put("/password", app.changePasswordHandler)
+
+					r.Route("/data", func(r chi.Router) {
+						r.Post("/export", app.requestDataExportHandler)
+						r.Post("/erasure", app.requestDataErasureHandler)
+						r.Get("/jobs", app.listDataJobsHandler)
+						r.Get("/jobs/{jobID}", app.getDataJobHandler)
+					})
+
+					r.Route("/2fa", func(r chi.Router) {
+						r.Post("/enroll", app.enrollTwoFactorHandler)
+						r.Post("/confirm", app.confirmTwoFactorHandler)
+						r.Delete("/", app.disableTwoFactorHandler)
+					})
+
+					r.Route("/sessions", func(r chi.Router) {
+						r.Get("/", app.listSessionsHandler)
+						r.Post("/revoke-others", app.revokeOtherSessionsHandler)
+						r.Delete("/{sessionID}", app.revokeSessionHandler)
+					})
+
+					r.Route("/api-keys", func(r chi.Router) {
+						r.Get("/", app.listAPIKeysHandler)
+						r.Post("/", app.createAPIKeyHandler)
+						r.Delete("/{keyID}", app.revokeAPIKeyHandler)
+					})
+				})
+
+				r.Route("/moderation", func(r chi.Router) {
+					r.Use(app.RequirePermission(auth.PermissionReportsReview))
+					r.Get("/reports", app.getReportQueueHandler)
+					r.Post("/reports/{reportID}/actions", app.moderateReportHandler)
+				})
+
+				r.Route("/admin", func(r chi.Router) {
+					r.Route("/users", func(r chi.Router) {
+						r.Use(app.RequirePermission(auth.PermissionUsersManage))
+						r.Get("/", app.listUsersHandler)
+						r.Route("/{userID}", func(r chi.Router) {
+							r.Use(app.GetTargetUserMiddlewareContext)
+							r.Patch("/role", app.updateUserRoleHandler)
+							r.Put("/deactivate", app.deactivateUserHandler)
+							r.Put("/reactivate", app.reactivateUserHandler)
+							r.Post("/password-reset", app.forcePasswordResetHandler)
+						})
+					})
+
+					r.Group(func(r chi.Router) {
+						r.Use(app.RequirePermission(auth.PermissionRolesManage))
+						r.Get("/roles", app.listRolesHandler)
+						r.Post("/roles", app.createRoleHandler)
+						r.Put("/roles/{roleID}/permissions", app.updateRolePermissionsHandler)
+						r.Get("/permissions", app.listPermissionsHandler)
+					})
+
+					r.Route("/flags", func(r chi.Router) {
+						r.Use(app.RequirePermission(auth.PermissionFlagsManage))
+						r.Get("/", app.listFeatureFlagsHandler)
+						r.Post("/", app.createFeatureFlagHandler)
+						r.Patch("/{key}", app.updateFeatureFlagHandler)
+					})
+				})
 			})
 		})
 
-		//public route
+		// the emailed token authorizes the download
+		r.With(app.RateLimitingMiddleware(rateLimitDefault)).Get("/data-exports/{token}", app.downloadDataExportHandler)
+
+		//public route, rate limited per IP
 		r.Route("/authentication", func(r chi.Router) {
-			r.Post("/user", app.registerUserHandler)
-			r.Put("/activate/{token}", app.activateUserHandler)
-			r.Post("/login", app.authUserHandler)
+			r.With(app.RateLimitingMiddleware(rateLimitRegister)).Post("/user", app.registerUserHandler)
+			r.With(app.RateLimitingMiddleware(rateLimitDefault)).Put("/activate/{token}", app.activateUserHandler)
+			r.With(app.RateLimitingMiddleware(rateLimitLogin)).Post("/login", app.authUserHandler)
+			r.With(app.RateLimitingMiddleware(rateLimitLogin)).Post("/2fa/verify", app.verifyTwoFactorHandler)
+			r.With(app.RateLimitingMiddleware(rateLimitDefault)).Put("/password/{token}", app.resetPasswordHandler)
+			r.With(app.RateLimitingMiddleware(rateLimitDefault)).Put("/email/{token}", app.confirmEmailChangeHandler)
+			r.With(app.RateLimitingMiddleware(rateLimitLogin)).Get("/oidc/{provider}/login", app.oidcLoginHandler)
+			r.With(app.RateLimitingMiddleware(rateLimitLogin)).Get("/oidc/{provider}/callback", app.oidcCallbackHandler)
 		})
 	})
 
@@ -168,6 +346,15 @@ func (app *application) run(mux http.Handler) error {
 		IdleTimeout:  time.Minute,
 	}
 
+	// the admin listener serves metrics, it is not exposed with the API
+	admin := &http.Server{
+		Addr:         app.config.adminAddr,
+		Handler:      app.mountAdmin(),
+		WriteTimeout: time.Second * 30,
+		ReadTimeout:  time.Second * 10,
+		IdleTimeout:  time.Minute,
+	}
+
 	shutdown := make(chan error)
 	go func() {
 		quit := make(chan os.Signal, 1)
@@ -175,12 +362,30 @@ func (app *application) run(mux http.Handler) error {
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 		s := <-quit
 
+		app.logger.Infow("signal caught", "signal", s.String())
+
+		// fail readiness first and give load balancers time to stop sending
+		// traffic before the listener closes
+		app.draining.Store(true)
+		time.Sleep(app.config.health.drainDelay)
+
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		app.logger.Infow("signal caught", "signal", s.String())
+		// metrics stay up while the API drains
+		err := srv.Shutdown(ctx)
+		if err := admin.Shutdown(ctx); err != nil {
+			app.logger.Errorw("admin server shutdown failed", "error", err)
+		}
+
+		shutdown <- err
+	}()
 
-		shutdown <- srv.Shutdown(ctx)
+	go func() {
+		app.logger.Infow("admin server has started", "addr", app.config.adminAddr)
+		if err := admin.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			app.logger.Errorw("admin server failed", "addr", app.config.adminAddr, "error", err)
+		}
 	}()
 
 	app.logger.Infow("server has started", "addr", app.config.addr, "env", app.config.env)
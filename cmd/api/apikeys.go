@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"tiago-udemy/internal/auth"
+	"tiago-udemy/internal/store"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type apiKeyKey string
+
+const apiKeyCtx apiKeyKey = "apiKey"
+
+type CreateAPIKeyPayload struct {
+	Name      string     `json:"name" validate:"required,max=100"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1,dive,max=50"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreatedAPIKey carries the secret, which is not retrievable afterwards
+type CreatedAPIKey struct {
+	store.APIKey
+	Key string `json:"key"`
+}
+
+// CreateAPIKey godoc
+//
+//	@Summary		Creates an API key
+//	@Description	Creates a personal API key. Send it as "ApiKey <key>" in the Authorization header. The key is only shown in this response
+//	@Tags			api-keys
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreateAPIKeyPayload	true	"API key payload"
+//	@Success		201		{object}	CreatedAPIKey
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		403		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/me/api-keys [post]
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateAPIKeyPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	for _, scope := range payload.Scopes {
+		if !slices.Contains(auth.Scopes, scope) {
+			app.StatusBadRequest(w, r, clientErrorf("unknown scope %q, valid scopes are %v", scope, auth.Scopes))
+			return
+		}
+	}
+
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now()) {
+		app.StatusBadRequest(w, r, clientErrorf("expires_at must be in the future"))
+		return
+	}
+
+	user := getUserCtx(r)
+	plainKey, prefix := auth.GenerateAPIKey()
+
+	slices.Sort(payload.Scopes)
+	key := &store.APIKey{
+		UserID:    user.ID,
+		Name:      payload.Name,
+		Prefix:    prefix,
+		Hash:      sha256Hex(plainKey),
+		Scopes:    slices.Compact(payload.Scopes),
+		ExpiresAt: payload.ExpiresAt,
+	}
+
+	if err := app.store.APIKeys.Create(r.Context(), key); err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, CreatedAPIKey{APIKey: *key, Key: plainKey}); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+// ListAPIKeys godoc
+//
+//	@Summary		Lists API keys
+//	@Description	Lists the current user's API keys without their secrets
+//	@Tags			api-keys
+//	@Produce		json
+//	@Success		200	{array}		store.APIKey
+//	@Failure		401	{object}	Problem
+//	@Failure		403	{object}	Problem
+//	@Failure		500	{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/me/api-keys [get]
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserCtx(r)
+
+	keys, err := app.store.APIKeys.GetByUser(r.Context(), user.ID)
+	if err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, keys); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+// RevokeAPIKey godoc
+//
+//	@Summary		Revokes an API key
+//	@Description	Deletes one of the current user's API keys, requests using it fail right away
+//	@Tags			api-keys
+//	@Produce		json
+//	@Param			keyID	path		int		true	"API key ID"
+//	@Success		204		{string}	string	"API key revoked"
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		403		{object}	Problem
+//	@Failure		404		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/me/api-keys/{keyID} [delete]
+func (app *application) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "keyID"), 10, 64)
+	if err != nil || id <= 0 {
+		app.StatusBadRequest(w, r, clientErrorf("invalid keyID"))
+		return
+	}
+
+	user := getUserCtx(r)
+	if err := app.store.APIKeys.Delete(r.Context(), id, user.ID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrRecordNotFound):
+			app.RecordNotFound(w, r, err)
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+// RequireScope lets API keys through only when they carry scope. Requests
+// authenticated with an access token are not limited by scopes.
+func (app *application) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := getAPIKeyCtx(r)
+			if ok && !slices.Contains(key.Scopes, scope) {
+				app.ForbiddenRequest(w, r, fmt.Errorf("api key %d lacks scope %q", key.ID, scope))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// DenyAPIKeyMiddleware keeps API keys away from account and staff routes,
+// which need an interactive login
+func (app *application) DenyAPIKeyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key, ok := getAPIKeyCtx(r); ok {
+			app.ForbiddenRequest(w, r, fmt.Errorf("api key %d used on a route that requires a login", key.ID))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// getAPIKeyCtx returns the key the request was authenticated with, if any
+func getAPIKeyCtx(r *http.Request) (*store.APIKey, bool) {
+	key, ok := r.Context().Value(apiKeyCtx).(*store.APIKey)
+	return key, ok
+}
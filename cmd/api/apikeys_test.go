@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"tiago-udemy/internal/auth"
+	"tiago-udemy/internal/store"
+)
+
+func TestAPIKeyScopes(t *testing.T) {
+	app := newTestApp()
+	key := &store.APIKey{ID: 1, Scopes: []string{auth.ScopePostsRead}}
+
+	tests := []struct {
+		name       string
+		middleware func(http.Handler) http.Handler
+		key        *store.APIKey
+		wantStatus int
+	}{
+		{name: "key with the scope", middleware: app.RequireScope(auth.ScopePostsRead), key: key, wantStatus: http.StatusOK},
+		{name: "key without the scope", middleware: app.RequireScope(auth.ScopePostsWrite), key: key, wantStatus: http.StatusForbidden},
+		{name: "access token ignores scopes", middleware: app.RequireScope(auth.ScopePostsWrite), wantStatus: http.StatusOK},
+		{name: "key on a login only route", middleware: app.DenyAPIKeyMiddleware, key: key, wantStatus: http.StatusForbidden},
+		{name: "access token on a login only route", middleware: app.DenyAPIKeyMiddleware, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/posts/1", nil)
+			if tt.key != nil {
+				req = req.WithContext(context.WithValue(req.Context(), apiKeyCtx, tt.key))
+			}
+
+			rr := httptest.NewRecorder()
+			tt.middleware(next).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("want %d, got %d", tt.wantStatus, rr.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"tiago-udemy/internal/auth"
+	"tiago-udemy/internal/blob"
+	"tiago-udemy/internal/media"
+	"tiago-udemy/internal/store"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// orphanBatchSize bounds how many orphaned uploads one GC pass removes
+const orphanBatchSize = 100
+
+// UploadAttachment godoc
+//
+//	@Summary		Uploads an image
+//	@Description	Uploads a jpeg or png image. Metadata is stripped and a thumbnail is created. Attach the upload to a post afterwards, unattached uploads are deleted
+//	@Tags			attachments
+//	@Accept			mpfd
+//	@Produce		json
+//	@Param			file	formData	file	true	"Image file"
+//	@Success		201		{object}	store.Attachment
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		413		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/uploads [post]
+func (app *application) uploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	maxBytes := app.config.mediaConfig.maxUploadBytes
+
+	// leave room for the multipart envelope around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+1_048_578)
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			app.RequestEntityTooLarge(w, r, err)
+			return
+		}
+		app.StatusBadRequest(w, r, &clientError{detail: "missing file", cause: err})
+		return
+	}
+	defer file.Close()
+
+	img, err := media.Process(file, maxBytes, app.config.mediaConfig.thumbnailSize)
+	if err != nil {
+		switch {
+		case errors.Is(err, media.ErrTooLarge):
+			app.RequestEntityTooLarge(w, r, err)
+			return
+		case errors.Is(err, media.ErrUnsupportedType):
+			app.StatusBadRequest(w, r, media.ErrUnsupportedType)
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	user := getUserCtx(r)
+
+	ext := ".jpg"
+	if img.MimeType == media.MimePNG {
+		ext = ".png"
+	}
+	name := uuid.New().String()
+
+	attachment := &store.Attachment{
+		UserID:       user.ID,
+		BlobKey:      fmt.Sprintf("attachments/%d/%s%s", user.ID, name, ext),
+		ThumbnailKey: fmt.Sprintf("attachments/%d/%s_thumb%s", user.ID, name, ext),
+		MimeType:     img.MimeType,
+		Size:         int64(len(img.Data)),
+		Width:        img.Width,
+		Height:       img.Height,
+	}
+
+	ctx := r.Context()
+	if err := app.blobs.Put(ctx, attachment.BlobKey, bytes.NewReader(img.Data)); err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	if err := app.blobs.Put(ctx, attachment.ThumbnailKey, bytes.NewReader(img.Thumbnail)); err != nil {
+		app.deleteAttachmentBlobs(ctx, attachment)
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Attachments.Create(ctx, attachment); err != nil {
+		app.deleteAttachmentBlobs(ctx, attachment)
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	setAttachmentURLs(attachment)
+
+	if err := app.jsonResponse(w, http.StatusCreated, attachment); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+type AttachPayload struct {
+	AttachmentID int64 `json:"attachment_id" validate:"required,gte=1"`
+}
+
+// AttachToPost godoc
+//
+//	@Summary		Attaches an upload to a post
+//	@Description	Attaches one of the caller's unattached uploads to their own post
+//	@Tags			attachments
+//	@Accept			json
+//	@Produce		json
+//	@Param			postID	path		int				true	"Post ID"
+//	@Param			payload	body		AttachPayload	true	"Attachment payload"
+//	@Success		204		{string}	string			"Attachment added"
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		403		{object}	Problem
+//	@Failure		404		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/posts/{postID}/attachments [post]
+func (app *application) attachToPostHandler(w http.ResponseWriter, r *http.Request) {
+	post := getPostCtx(r)
+	user := getUserCtx(r)
+
+	if post.UserID != user.ID {
+		app.ForbiddenRequest(w, r, fmt.Errorf("user %d does not own post %d", user.ID, post.ID))
+		return
+	}
+
+	var payload AttachPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	if err := app.store.Attachments.AttachToPost(ctx, payload.AttachmentID, post.ID, user.ID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrRecordNotFound):
+			app.RecordNotFound(w, r, clientErrorf("no unattached upload %d", payload.AttachmentID))
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+// GetAttachment godoc
+//
+//	@Summary		Downloads an image
+//	@Description	Downloads an uploaded image. Unattached uploads are only served to their uploader, attached ones to the post author or users with posts:read:any
+//	@Tags			attachments
+//	@Produce		image/jpeg,image/png
+//	@Param			attachmentID	path		int	true	"Attachment ID"
+//	@Success		200				{file}		file
+//	@Failure		400				{object}	Problem
+//	@Failure		401				{object}	Problem
+//	@Failure		404				{object}	Problem
+//	@Failure		500				{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/attachments/{attachmentID} [get]
+func (app *application) getAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	app.serveAttachment(w, r, false)
+}
+
+// GetAttachmentThumbnail godoc
+//
+//	@Summary		Downloads an image thumbnail
+//	@Description	Downloads the thumbnail of an uploaded image, with the same access rules as the image
+//	@Tags			attachments
+//	@Produce		image/jpeg,image/png
+//	@Param			attachmentID	path		int	true	"Attachment ID"
+//	@Success		200				{file}		file
+//	@Failure		400				{object}	Problem
+//	@Failure		401				{object}	Problem
+//	@Failure		404				{object}	Problem
+//	@Failure		500				{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/attachments/{attachmentID}/thumbnail [get]
+func (app *application) getAttachmentThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	app.serveAttachment(w, r, true)
+}
+
+func (app *application) serveAttachment(w http.ResponseWriter, r *http.Request, thumbnail bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "attachmentID"), 10, 64)
+	if err != nil || id <= 0 {
+		app.StatusBadRequest(w, r, clientErrorf("invalid attachmentID"))
+		return
+	}
+
+	ctx := r.Context()
+	attachment, err := app.store.Attachments.Get(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrRecordNotFound):
+			app.RecordNotFound(w, r, err)
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	if err := app.canViewAttachment(ctx, getUserCtx(r), attachment); err != nil {
+		switch {
+		case errors.Is(err, store.ErrRecordNotFound):
+			app.RecordNotFound(w, r, err)
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	key := attachment.BlobKey
+	if thumbnail {
+		key = attachment.ThumbnailKey
+	}
+
+	body, err := app.blobs.Get(ctx, key)
+	if err != nil {
+		switch {
+		case errors.Is(err, blob.ErrNotFound):
+			app.RecordNotFound(w, r, err)
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", attachment.MimeType)
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, body); err != nil {
+		app.logger.Errorw("attachment write failed", "attachment_id", id, "error", err)
+	}
+}
+
+// canViewAttachment returns ErrRecordNotFound unless the user may see the
+// attachment. An unattached upload is only visible to its uploader, an attached
+// one to whoever may read the post through GET /posts/{postID}, so hidden and
+// private posts do not leak their images.
+func (app *application) canViewAttachment(ctx context.Context, user *store.User, attachment *store.Attachment) error {
+	if attachment.PostID == nil {
+		if attachment.UserID != user.ID {
+			return fmt.Errorf("attachment %d: %w", attachment.ID, store.ErrRecordNotFound)
+		}
+		return nil
+	}
+
+	post, err := app.store.Posts.Get(ctx, *attachment.PostID)
+	if err != nil {
+		return err
+	}
+	if !app.canAccessPost(user, post, auth.PermissionPostsReadAny) {
+		return fmt.Errorf("attachment %d: %w", attachment.ID, store.ErrRecordNotFound)
+	}
+	return nil
+}
+
+// loadAttachments fills in the attachments of the given posts
+func (app *application) loadAttachments(ctx context.Context, posts ...*store.Post) error {
+	ids := make([]int64, 0, len(posts))
+	for _, post := range posts {
+		ids = append(ids, post.ID)
+	}
+
+	attachments, err := app.store.Attachments.GetByPostIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, post := range posts {
+		post.Attachments = attachments[post.ID]
+		if post.Attachments == nil {
+			post.Attachments = []store.Attachment{}
+		}
+		for i := range post.Attachments {
+			setAttachmentURLs(&post.Attachments[i])
+		}
+	}
+
+	return nil
+}
+
+func setAttachmentURLs(attachment *store.Attachment) {
+	attachment.URL = fmt.Sprintf("/v1/attachments/%d", attachment.ID)
+	attachment.ThumbnailURL = fmt.Sprintf("/v1/attachments/%d/thumbnail", attachment.ID)
+}
+
+func (app *application) deleteAttachmentBlobs(ctx context.Context, attachment *store.Attachment) {
+	for _, key := range []string{attachment.BlobKey, attachment.ThumbnailKey} {
+		if err := app.blobs.Delete(ctx, key); err != nil {
+			app.logger.Errorw("blob delete failed", "key", key, "error", err)
+		}
+	}
+}
+
+// gcLoop periodically deletes uploads that were never attached to a post, or
+// whose post was deleted, and expired sessions, until ctx is done
+func (app *application) gcLoop(ctx context.Context, interval, orphanTTL time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		app.deleteOrphanedAttachments(ctx, orphanTTL)
+		app.purgeExpiredSessions(ctx)
+	}
+}
+
+func (app *application) deleteOrphanedAttachments(ctx context.Context, orphanTTL time.Duration) {
+	orphans, err := app.store.Attachments.GetOrphans(ctx, orphanTTL, orphanBatchSize)
+	if err != nil {
+		app.logger.Errorw("orphaned attachments lookup failed", "error", err)
+		return
+	}
+
+	for i := range orphans {
+		app.deleteAttachmentBlobs(ctx, &orphans[i])
+		if err := app.store.Attachments.Delete(ctx, orphans[i].ID); err != nil {
+			app.logger.Errorw("orphaned attachment delete failed", "attachment_id", orphans[i].ID, "error", err)
+		}
+	}
+
+	if len(orphans) > 0 {
+		app.logger.Infow("orphaned attachments deleted", "count", len(orphans))
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"tiago-udemy/internal/auth"
+	"tiago-udemy/internal/blob"
+	"tiago-udemy/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type fakeAttachments struct {
+	store.AttachmentRepository
+	attachments map[int64]*store.Attachment
+}
+
+func (f *fakeAttachments) Get(ctx context.Context, id int64) (*store.Attachment, error) {
+	attachment, ok := f.attachments[id]
+	if !ok {
+		return nil, store.ErrRecordNotFound
+	}
+	return attachment, nil
+}
+
+// fakePosts only knows the posts that are visible, like PostsStore.Get, keyed
+// to their author
+type fakePosts struct {
+	store.PostRepository
+	visible map[int64]int64
+}
+
+func (f *fakePosts) Get(ctx context.Context, id int64) (*store.Post, error) {
+	userID, ok := f.visible[id]
+	if !ok {
+		return nil, store.ErrRecordNotFound
+	}
+	return &store.Post{ID: id, UserID: userID}, nil
+}
+
+func TestServeAttachment(t *testing.T) {
+	blobs, err := blob.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := blobs.Put(context.Background(), "image", strings.NewReader("image")); err != nil {
+		t.Fatal(err)
+	}
+
+	visiblePost, hiddenPost := int64(10), int64(11)
+	app := newTestApp()
+	app.blobs = blobs
+	app.permissions = testPermissions(t, auth.PermissionPostsReadAny)
+	app.store.Posts = &fakePosts{visible: map[int64]int64{visiblePost: 2}}
+	app.store.Attachments = &fakeAttachments{attachments: map[int64]*store.Attachment{
+		1: {ID: 1, UserID: 1, BlobKey: "image", ThumbnailKey: "image"},
+		2: {ID: 2, UserID: 2, PostID: &visiblePost, BlobKey: "image", ThumbnailKey: "image"},
+		3: {ID: 3, UserID: 2, PostID: &hiddenPost, BlobKey: "image", ThumbnailKey: "image"},
+	}}
+
+	router := chi.NewRouter()
+	router.Get("/v1/attachments/{attachmentID}", app.getAttachmentHandler)
+	router.Get("/v1/attachments/{attachmentID}/thumbnail", app.getAttachmentThumbnailHandler)
+
+	tests := []struct {
+		name       string
+		userID     int64
+		roleID     int64
+		path       string
+		wantStatus int
+	}{
+		{"uploader sees unattached upload", 1, testUserRole, "/v1/attachments/1", http.StatusOK},
+		{"other user cannot see unattached upload", 2, testUserRole, "/v1/attachments/1", http.StatusNotFound},
+		{"other user cannot see unattached thumbnail", 2, testUserRole, "/v1/attachments/1/thumbnail", http.StatusNotFound},
+		{"author sees image of own post", 2, testUserRole, "/v1/attachments/2", http.StatusOK},
+		{"non-owner cannot see image of post", 1, testUserRole, "/v1/attachments/2/thumbnail", http.StatusNotFound},
+		{"posts:read:any sees image of any post", 3, testModeratorRole, "/v1/attachments/2", http.StatusOK},
+		{"image of hidden post is not found", 3, testModeratorRole, "/v1/attachments/3/thumbnail", http.StatusNotFound},
+		{"author cannot see image of hidden post either", 2, testUserRole, "/v1/attachments/3", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req = withTestUser(req, tt.userID, tt.roleID)
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("want %d, got %d; body=%s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
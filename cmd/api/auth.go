@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"tiago-udemy/internal/mailer"
 	"tiago-udemy/internal/store"
 	"time"
@@ -30,8 +31,8 @@ type RegisterUserPayload struct {
 //	@Produce		json
 //	@Param			payload	body		RegisterUserPayload	true	"User registration payload"
 //	@Success		201		{object}	string
-//	@Failure		400		{object}	error
-//	@Failure		500		{object}	error
+//	@Failure		400		{object}	Problem
+//	@Failure		500		{object}	Problem
 //	@Router			/authentication/user [post]
 func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
 	var payload RegisterUserPayload
@@ -115,14 +116,14 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 //	@Produce		json
 //	@Param			token	path		string	true	"Invitation token"
 //	@Success		200		{string}	string	"User activated"
-//	@Failure		404		{object}	error
-//	@Failure		500		{object}	error
+//	@Failure		404		{object}	Problem
+//	@Failure		500		{object}	Problem
 //	@Router			/authentication/activate/{token} [put]
 func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Request) {
 	plainToken := chi.URLParam(r, "token")
 
 	if plainToken == "" {
-		app.StatusBadRequest(w, r, fmt.Errorf("missing tokens"))
+		app.StatusBadRequest(w, r, clientErrorf("missing tokens"))
 		return
 	}
 
@@ -130,7 +131,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	hashToken := hex.EncodeToString(hash[:])
 
 	ctx := r.Context()
-	if err := app.store.Users.Activate(ctx, hashToken); err != nil {
+	if _, err := app.store.Users.Activate(ctx, hashToken); err != nil {
 		if errors.Is(err, store.ErrInvalidToken) {
 			app.StatusBadRequest(w, r, err)
 			return
@@ -153,14 +154,16 @@ type UserLoginPayload struct {
 // authUserHandler godoc
 //
 //	@Summary		Authenticate the User
-//	@Description	Authenticate the user and return credentials token
+//	@Description	Authenticate the user and return credentials token. Users with two-factor authentication get a challenge token to exchange at /authentication/2fa/verify instead
 //	@Tags			authentication
 //	@Accept			json
 //	@Produce		json
 //	@Param			payload	body		UserLoginPayload	true	"User Login payload"
 //	@Success		200		{string}	string				"Token"
-//	@Failure		401		{object}	error
-//	@Failure		500		{object}	error
+//	@Success		202		{object}	TwoFactorChallenge	"Two-factor authentication required"
+//	@Failure		401		{object}	Problem
+//	@Failure		429		{object}	Problem
+//	@Failure		500		{object}	Problem
 //	@Router			/authentication/login [post]
 func (app *application) authUserHandler(w http.ResponseWriter, r *http.Request) {
 	var payload UserLoginPayload
@@ -173,15 +176,27 @@ func (app *application) authUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	ctx := r.Context()
-	user, err := app.store.Users.GetUserByEmail(ctx, payload.Email)
+	accountKey, ipKey := loginAttemptKeys(r, payload.Email)
 
+	wait, err := app.loginRetryAfter(ctx, accountKey, ipKey)
+	if err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+	if wait > 0 {
+		app.tooManyLoginAttempts(w, r, strconv.Itoa(ceilSeconds(wait)))
+		return
+	}
+
+	reason := "invalid credentials"
+	user, err := app.store.Users.GetUserByEmail(ctx, payload.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, store.ErrRecordNotFound):
-
-			// change error back to invalid credentials
-			app.InvalidUserAuthorization(w, r, fmt.Errorf("no user found"))
-			return
+			// compare against an empty password anyway, so unknown emails
+			// take as long as wrong passwords
+			reason = "no user found"
+			user = &store.User{}
 		default:
 			app.InternaServerError(w, r, err)
 			return
@@ -190,12 +205,63 @@ func (app *application) authUserHandler(w http.ResponseWriter, r *http.Request)
 
 	err = user.Password.Compare(payload.Password)
 	if err != nil {
-		app.InvalidUserAuthorization(w, r, fmt.Errorf("invalid credentials"))
+		app.recordLoginFailure(ctx, user, accountKey, ipKey)
+		app.InvalidUserAuthorization(w, r, errors.New(reason))
 		return
 	}
 
+	if err := app.store.LoginAttempts.Reset(ctx, accountKey); err != nil {
+		app.logger.Errorw("resetting failed logins failed", "key", accountKey, "error", err)
+	}
+
+	app.loginResponse(w, r, user)
+}
+
+// errAccountDisabled is returned once the credentials check out, so only the
+// owner learns the account was disabled
+var errAccountDisabled = errors.New("this account has been disabled")
+
+// loginResponse issues the access token for an authenticated user. With
+// two-factor on, the first factor only earns a challenge for the second step.
+func (app *application) loginResponse(w http.ResponseWriter, r *http.Request, user *store.User) {
+	if user.IsDisabled {
+		app.ForbiddenRequest(w, r, errAccountDisabled)
+		return
+	}
+
+	twoFactor, err := app.store.TwoFactor.Get(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, store.ErrRecordNotFound) {
+		app.InternaServerError(w, r, err)
+		return
+	}
+	if twoFactor != nil && twoFactor.Enabled {
+		challenge, err := app.generateChallengeToken(user)
+		if err != nil {
+			app.InternaServerError(w, r, err)
+			return
+		}
+
+		if err := app.jsonResponse(w, http.StatusAccepted, TwoFactorChallenge{ChallengeToken: challenge}); err != nil {
+			app.InternaServerError(w, r, err)
+		}
+		return
+	}
+
+	token, err := app.newSessionToken(r, user.ID)
+	if err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, token); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+func (app *application) generateAccessToken(userID int64, sessionID string) (string, error) {
 	claims := jwt.MapClaims{
-		"sub": user.ID,
+		"sub": userID,
+		"sid": sessionID,
 		"exp": time.Now().Add(app.config.authConfig.jwtAuth.exp).Unix(),
 		"iat": time.Now().Unix(),
 		"nbf": time.Now().Unix(),
@@ -203,13 +269,65 @@ func (app *application) authUserHandler(w http.ResponseWriter, r *http.Request)
 		"aud": app.config.authConfig.jwtAuth.iss,
 	}
 
-	token, err := app.authenticator.GenerateToken(claims)
-	if err != nil {
+	return app.authenticator.GenerateToken(claims)
+}
+
+type ResetPasswordPayload struct {
+	Password string `json:"password" validate:"required,min=8,max=50"`
+}
+
+// ResetPassword godoc
+//
+//	@Summary		Resets a user's password
+//	@Description	Sets a new password using a password reset token. Every session and API key of the user is revoked
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			token	path		string					true	"Password reset token"
+//	@Param			payload	body		ResetPasswordPayload	true	"New password payload"
+//	@Success		200		{string}	string					"Password reset"
+//	@Failure		400		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Router			/authentication/password/{token} [put]
+func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	plainToken := chi.URLParam(r, "token")
+
+	if plainToken == "" {
+		app.StatusBadRequest(w, r, clientErrorf("missing tokens"))
+		return
+	}
+
+	var payload ResetPasswordPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	user := &store.User{}
+	if err := user.Password.Set(payload.Password); err != nil {
 		app.InternaServerError(w, r, err)
 		return
 	}
 
-	if err := app.jsonResponse(w, http.StatusCreated, token); err != nil {
+	hash := sha256.Sum256([]byte(plainToken))
+	hashToken := hex.EncodeToString(hash[:])
+
+	ctx := r.Context()
+	if _, err := app.store.Users.ResetPassword(ctx, hashToken, user); err != nil {
+		if errors.Is(err, store.ErrInvalidToken) {
+			app.StatusBadRequest(w, r, err)
+			return
+		}
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, "Password Successfully Reset"); err != nil {
 		app.InternaServerError(w, r, err)
 	}
 }
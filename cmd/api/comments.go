@@ -1,8 +1,14 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"tiago-udemy/internal/auth"
 	"tiago-udemy/internal/store"
+
+	"github.com/go-chi/chi/v5"
 )
 
 type createCommentPayload struct {
@@ -42,3 +48,60 @@ func (app *application) createCommentHandler(w http.ResponseWriter, r *http.Requ
 	}
 
 }
+
+// DeleteComment godoc
+//
+//	@Summary		Deletes a comment
+//	@Description	Deletes a comment by ID. Only the author or users with comments:delete:any can delete it
+//	@Tags			comments
+//	@Produce		json
+//	@Param			commentID	path		int		true	"Comment ID"
+//	@Success		204			{string}	string	"Comment deleted"
+//	@Failure		400			{object}	Problem
+//	@Failure		401			{object}	Problem
+//	@Failure		403			{object}	Problem
+//	@Failure		404			{object}	Problem
+//	@Failure		500			{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/comments/{commentID} [delete]
+func (app *application) deleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "commentID"), 10, 64)
+	if err != nil || id <= 0 {
+		app.StatusBadRequest(w, r, clientErrorf("invalid commentID"))
+		return
+	}
+
+	ctx := r.Context()
+	comment, err := app.store.Comment.Get(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrRecordNotFound):
+			app.RecordNotFound(w, r, err)
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	user := getUserCtx(r)
+	if comment.UserID != user.ID && !app.permissions.Can(user.Role.ID, auth.PermissionCommentsDeleteAny) {
+		app.ForbiddenRequest(w, r, fmt.Errorf("user not allowed to perform this action"))
+		return
+	}
+
+	if err := app.store.Comment.Delete(ctx, id); err != nil {
+		switch {
+		case errors.Is(err, store.ErrRecordNotFound):
+			app.RecordNotFound(w, r, err)
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"tiago-udemy/internal/blob"
+	"tiago-udemy/internal/mailer"
+	"tiago-udemy/internal/store"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// Erasure policies for the content a user authored
+const (
+	erasurePolicyAnonymize = "anonymize"
+	erasurePolicyDelete    = "delete"
+)
+
+const staleArchiveBatchSize = 100
+
+type dataJobsConfig struct {
+	pollInterval time.Duration
+	// staleAfter is how long a job may run before another replica retries it
+	staleAfter    time.Duration
+	exportExp     time.Duration
+	erasurePolicy string
+}
+
+// RequestDataExport godoc
+//
+//	@Summary		Requests a data export
+//	@Description	Starts collecting the current user's data into an archive. A one-time download link is emailed once the job completes
+//	@Tags			me
+//	@Produce		json
+//	@Success		202	{object}	store.DataJob
+//	@Failure		401	{object}	Problem
+//	@Failure		403	{object}	Problem
+//	@Failure		409	{object}	Problem
+//	@Failure		500	{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/me/data/export [post]
+func (app *application) requestDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	app.createDataJob(w, r, store.DataJobExport)
+}
+
+// RequestDataErasure godoc
+//
+//	@Summary		Requests data erasure
+//	@Description	Starts removing the current user's personal data. Their authored content is anonymized or deleted depending on the server policy, their uploads, previous exports and report reasons are deleted, and all sessions end
+//	@Tags			me
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ReauthPayload	true	"Re-authentication payload"
+//	@Success		202		{object}	store.DataJob
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		403		{object}	Problem
+//	@Failure		409		{object}	Problem
+//	@Failure		429		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/me/data/erasure [post]
+func (app *application) requestDataErasureHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ReauthPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if !app.reauthenticate(w, r, payload) {
+		return
+	}
+
+	app.createDataJob(w, r, store.DataJobErasure)
+}
+
+func (app *application) createDataJob(w http.ResponseWriter, r *http.Request, kind string) {
+	job := &store.DataJob{
+		UserID: getUserCtx(r).ID,
+		Kind:   kind,
+	}
+
+	if err := app.store.DataJobs.Create(r.Context(), job); err != nil {
+		switch {
+		case errors.Is(err, store.ErrJobAlreadyOpen):
+			app.ConflictResponse(w, r, err)
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	if err := app.jsonResponse(w, http.StatusAccepted, job); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+// ListDataJobs godoc
+//
+//	@Summary		Lists data jobs
+//	@Description	Lists the current user's export and erasure jobs, newest first
+//	@Tags			me
+//	@Produce		json
+//	@Success		200	{array}		store.DataJob
+//	@Failure		401	{object}	Problem
+//	@Failure		403	{object}	Problem
+//	@Failure		500	{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/me/data/jobs [get]
+func (app *application) listDataJobsHandler(w http.ResponseWriter, r *http.Request) {
+	jobs, err := app.store.DataJobs.GetByUser(r.Context(), getUserCtx(r).ID)
+	if err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, jobs); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+// GetDataJob godoc
+//
+//	@Summary		Fetches a data job
+//	@Description	Fetches the status of one of the current user's export or erasure jobs
+//	@Tags			me
+//	@Produce		json
+//	@Param			jobID	path		int	true	"Job ID"
+//	@Success		200		{object}	store.DataJob
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		403		{object}	Problem
+//	@Failure		404		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/me/data/jobs/{jobID} [get]
+func (app *application) getDataJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "jobID"), 10, 64)
+	if err != nil || id <= 0 {
+		app.StatusBadRequest(w, r, clientErrorf("invalid jobID"))
+		return
+	}
+
+	job, err := app.store.DataJobs.Get(r.Context(), id, getUserCtx(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrRecordNotFound):
+			app.RecordNotFound(w, r, err)
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, job); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+// DownloadDataExport godoc
+//
+//	@Summary		Downloads a data export
+//	@Description	Downloads an export archive with the token from the emailed link. The link works once
+//	@Tags			me
+//	@Produce		json
+//	@Param			token	path		string	true	"Download token"
+//	@Success		200		{object}	store.PersonalData
+//	@Failure		400		{object}	Problem
+//	@Failure		404		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Router			/data-exports/{token} [get]
+func (app *application) downloadDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	plainToken := chi.URLParam(r, "token")
+
+	if plainToken == "" {
+		app.StatusBadRequest(w, r, clientErrorf("missing tokens"))
+		return
+	}
+
+	ctx := r.Context()
+	job, err := app.store.DataJobs.ConsumeDownload(ctx, sha256Hex(plainToken))
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrInvalidToken):
+			app.StatusBadRequest(w, r, err)
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	body, err := app.blobs.Get(ctx, job.ArchiveKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, blob.ErrNotFound):
+			app.RecordNotFound(w, r, err)
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="gophersocial-export-%d.json"`, job.ID))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, body); err != nil {
+		app.logger.Errorw("data export write failed", "job_id", job.ID, "error", err)
+	}
+
+	// the archive is removed by the next cleanup if this fails
+	app.deleteArchive(context.Background(), job)
+}
+
+// dataJobLoop runs pending export and erasure jobs, and removes archives that
+// were downloaded or expired, until ctx is done
+func (app *application) dataJobLoop(ctx context.Context, cfg dataJobsConfig) {
+	ticker := time.NewTicker(cfg.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			job, err := app.store.DataJobs.ClaimNext(ctx, cfg.staleAfter)
+			if err != nil {
+				if !errors.Is(err, store.ErrRecordNotFound) {
+					app.logger.Errorw("claiming data job failed", "error", err)
+				}
+				break
+			}
+
+			app.runDataJob(ctx, cfg, job)
+		}
+
+		archives, err := app.store.DataJobs.GetStaleArchives(ctx, staleArchiveBatchSize)
+		if err != nil {
+			app.logger.Errorw("stale data exports lookup failed", "error", err)
+			continue
+		}
+		for i := range archives {
+			app.deleteArchive(ctx, &archives[i])
+		}
+	}
+}
+
+func (app *application) runDataJob(ctx context.Context, cfg dataJobsConfig, job *store.DataJob) {
+	app.logger.Infow("data job started", "job_id", job.ID, "kind", job.Kind, "user_id", job.UserID)
+
+	var err error
+	switch job.Kind {
+	case store.DataJobExport:
+		err = app.exportPersonalData(ctx, cfg, job)
+	case store.DataJobErasure:
+		err = app.erasePersonalData(ctx, cfg, job)
+	default:
+		err = fmt.Errorf("unknown data job kind %q", job.Kind)
+	}
+
+	if err != nil {
+		app.logger.Errorw("data job failed", "job_id", job.ID, "kind", job.Kind, "error", err)
+		// the user polls this, keep internals out of it
+		if err := app.store.DataJobs.Fail(ctx, job.ID, "the job could not be completed, please request it again"); err != nil {
+			app.logger.Errorw("marking data job failed failed", "job_id", job.ID, "error", err)
+		}
+		return
+	}
+
+	app.logger.Infow("data job completed", "job_id", job.ID, "kind", job.Kind, "user_id", job.UserID)
+}
+
+func (app *application) exportPersonalData(ctx context.Context, cfg dataJobsConfig, job *store.DataJob) error {
+	data, err := app.store.DataJobs.CollectPersonalData(ctx, job.UserID)
+	if err != nil {
+		return err
+	}
+
+	archive, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("exports/%d/%s.json", job.UserID, uuid.New().String())
+	if err := app.blobs.Put(ctx, key, bytes.NewReader(archive)); err != nil {
+		return err
+	}
+
+	plainToken := uuid.New().String()
+	expiresAt := time.Now().Add(cfg.exportExp)
+	if err := app.store.DataJobs.CompleteExport(ctx, job.ID, key, sha256Hex(plainToken), expiresAt); err != nil {
+		app.deleteBlob(ctx, key)
+		return err
+	}
+
+	downloadURL := fmt.Sprintf("%s/data-export/%s", app.config.frontendURL, plainToken)
+	mail := struct {
+		Username    string
+		DownloadURL string
+		ExpiresAt   string
+	}{
+		Username:    data.Profile.Username,
+		DownloadURL: downloadURL,
+		ExpiresAt:   expiresAt.UTC().Format(time.RFC1123),
+	}
+
+	// failing the job stops the link from working, the archive is cleaned
+	// up once it expires
+	status, err := app.mailer.Send(ctx, mailer.DataExportTemplate, data.Profile.Email, mail)
+	if err != nil {
+		return err
+	}
+
+	app.logger.Infow("Email sent", "status code", status)
+
+	return nil
+}
+
+func (app *application) erasePersonalData(ctx context.Context, cfg dataJobsConfig, job *store.DataJob) error {
+	if err := app.eraseUser(ctx, job.UserID, cfg.erasurePolicy == erasurePolicyDelete); err != nil {
+		// a user that no longer exists has nothing left to erase
+		if errors.Is(err, store.ErrRecordNotFound) {
+			return app.store.DataJobs.Complete(ctx, job.ID)
+		}
+		return err
+	}
+
+	return app.store.DataJobs.Complete(ctx, job.ID)
+}
+
+// eraseUser removes the user's personal data and then the uploads and export
+// archives it referenced
+func (app *application) eraseUser(ctx context.Context, userID int64, deleteContent bool) error {
+	erasure, err := app.store.DataJobs.EraseUser(ctx, userID, deleteContent)
+	if err != nil {
+		return err
+	}
+
+	// the rows are gone, a blob that fails to delete here is only logged
+	for _, key := range erasure.BlobKeys {
+		app.deleteBlob(ctx, key)
+	}
+
+	return nil
+}
+
+func (app *application) deleteArchive(ctx context.Context, job *store.DataJob) {
+	app.deleteBlob(ctx, job.ArchiveKey)
+	if err := app.store.DataJobs.ClearArchive(ctx, job.ID); err != nil {
+		app.logger.Errorw("data export cleanup failed", "job_id", job.ID, "error", err)
+	}
+}
+
+func (app *application) deleteBlob(ctx context.Context, key string) {
+	if err := app.blobs.Delete(ctx, key); err != nil {
+		app.logger.Errorw("blob delete failed", "key", key, "error", err)
+	}
+}
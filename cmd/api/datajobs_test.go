@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"tiago-udemy/internal/blob"
+	"tiago-udemy/internal/store"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// fakeDataJobs keeps a single export job in memory
+type fakeDataJobs struct {
+	store.DataJobRepository
+	job       store.DataJob
+	tokenHash string
+}
+
+func (f *fakeDataJobs) CollectPersonalData(ctx context.Context, userID int64) (*store.PersonalData, error) {
+	return &store.PersonalData{
+		Profile: store.User{ID: userID, Username: "gopher", Email: "gopher@example.com"},
+		Posts:   []store.ExportedPost{{ID: 7, Title: "hello"}},
+	}, nil
+}
+
+func (f *fakeDataJobs) CompleteExport(ctx context.Context, id int64, archiveKey string, hashtoken string, expiresAt time.Time) error {
+	f.job.Status = store.DataJobCompleted
+	f.job.ArchiveKey = archiveKey
+	f.tokenHash = hashtoken
+	return nil
+}
+
+func (f *fakeDataJobs) ConsumeDownload(ctx context.Context, hashtoken string) (*store.DataJob, error) {
+	if hashtoken != f.tokenHash || f.job.DownloadedAt != nil {
+		return nil, store.ErrInvalidToken
+	}
+	now := time.Now()
+	f.job.DownloadedAt = &now
+	job := f.job
+	return &job, nil
+}
+
+func (f *fakeDataJobs) ClearArchive(ctx context.Context, id int64) error {
+	f.job.ArchiveKey = ""
+	return nil
+}
+
+func (f *fakeDataJobs) EraseUser(ctx context.Context, userID int64, deleteContent bool) (*store.Erasure, error) {
+	return &store.Erasure{BlobKeys: []string{f.job.ArchiveKey, "attachments/1/a.png", "attachments/1/a_thumb.png"}}, nil
+}
+
+func (f *fakeDataJobs) Complete(ctx context.Context, id int64) error {
+	f.job.Status = store.DataJobCompleted
+	return nil
+}
+
+type recordingMailer struct {
+	data any
+}
+
+func (m *recordingMailer) Send(ctx context.Context, templateFile, email string, data any) (int, error) {
+	m.data = data
+	return http.StatusOK, nil
+}
+
+func TestDataExport(t *testing.T) {
+	blobs, err := blob.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs := &fakeDataJobs{job: store.DataJob{ID: 3, UserID: 1, Kind: store.DataJobExport}}
+	mail := &recordingMailer{}
+
+	app := newTestApp()
+	app.config.frontendURL = "http://frontend"
+	app.store.DataJobs = jobs
+	app.blobs = blobs
+	app.mailer = mail
+
+	app.runDataJob(context.Background(), dataJobsConfig{exportExp: time.Hour}, &jobs.job)
+	if jobs.job.Status != store.DataJobCompleted {
+		t.Fatalf("want completed job, got %q", jobs.job.Status)
+	}
+
+	link := mail.data.(struct {
+		Username    string
+		DownloadURL string
+		ExpiresAt   string
+	}).DownloadURL
+	token := strings.TrimPrefix(link, "http://frontend/data-export/")
+	archiveKey := jobs.job.ArchiveKey
+
+	router := chi.NewRouter()
+	router.Get("/v1/data-exports/{token}", app.downloadDataExportHandler)
+	download := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/data-exports/"+token, nil))
+		return rr
+	}
+
+	rr := download()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var archive store.PersonalData
+	if err := json.NewDecoder(rr.Body).Decode(&archive); err != nil {
+		t.Fatal(err)
+	}
+	if archive.Profile.Username != "gopher" || len(archive.Posts) != 1 {
+		t.Errorf("unexpected archive %+v", archive)
+	}
+
+	if _, err := blobs.Get(context.Background(), archiveKey); err != blob.ErrNotFound {
+		t.Errorf("archive not deleted after download: %v", err)
+	}
+	if rr := download(); rr.Code != http.StatusBadRequest {
+		t.Errorf("second download: want 400, got %d", rr.Code)
+	}
+}
+
+func TestDataErasure(t *testing.T) {
+	blobs, err := blob.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{"exports/1/old.json", "attachments/1/a.png", "attachments/1/a_thumb.png"}
+	for _, key := range keys {
+		if err := blobs.Put(context.Background(), key, strings.NewReader("personal data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jobs := &fakeDataJobs{job: store.DataJob{ID: 4, UserID: 1, Kind: store.DataJobErasure, ArchiveKey: keys[0]}}
+
+	app := newTestApp()
+	app.store.DataJobs = jobs
+	app.blobs = blobs
+
+	app.runDataJob(context.Background(), dataJobsConfig{erasurePolicy: erasurePolicyAnonymize}, &jobs.job)
+	if jobs.job.Status != store.DataJobCompleted {
+		t.Fatalf("want completed job, got %q", jobs.job.Status)
+	}
+
+	for _, key := range keys {
+		if _, err := blobs.Get(context.Background(), key); err != blob.ErrNotFound {
+			t.Errorf("%s not deleted after erasure: %v", key, err)
+		}
+	}
+}
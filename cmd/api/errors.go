@@ -3,50 +3,106 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strings"
 )
 
+// The helpers below log the error and answer with an RFC 7807 problem. The
+// code comes from the error registry in problems.go, or the helper's default
+// when the error is not registered. Clients only see the message of registered
+// errors and clientErrors, anything else stays in the logs.
+
 func (app *application) InternaServerError(w http.ResponseWriter, r *http.Request, err error) {
-	app.logger.Errorw("internal error", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	app.contextLogger(r.Context()).Errorw("internal error", "method", r.Method, "path", r.URL.Path, "error", err.Error())
 
-	writeJSONError(w, http.StatusInternalServerError, "the server encountered  a problem")
+	// the cause stays in the logs, clients quote the request id instead
+	writeProblem(w, newProblem(r, http.StatusInternalServerError, nil, codeInternal, "the server encountered a problem"))
 }
 
 func (app *application) RecordNotFound(w http.ResponseWriter, r *http.Request, err error) {
-	app.logger.Errorw("Record Not Found", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	app.contextLogger(r.Context()).Errorw("Record Not Found", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+
+	writeProblem(w, newProblem(r, http.StatusNotFound, err, codeNotFound, ""))
+}
+
+// notFoundResponse answers requests no route matches
+func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
+	app.contextLogger(r.Context()).Warnw("route not found", "method", r.Method, "path", r.URL.Path)
 
-	writeJSONError(w, http.StatusNotFound, err.Error())
+	writeProblem(w, newProblem(r, http.StatusNotFound, nil, codeNotFound, "the requested resource does not exist"))
+}
+
+// methodNotAllowedResponse answers requests to a route that does not accept
+// their method, allowed goes in the Allow header
+func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request, allowed []string) {
+	app.contextLogger(r.Context()).Warnw("method not allowed", "method", r.Method, "path", r.URL.Path)
+
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+
+	writeProblem(w, newProblem(r, http.StatusMethodNotAllowed, nil, codeMethodNotAllowed, fmt.Sprintf("the %s method is not supported by this resource", r.Method)))
 }
 
 func (app *application) StatusBadRequest(w http.ResponseWriter, r *http.Request, err error) {
-	app.logger.Errorw("Bad Request", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	app.contextLogger(r.Context()).Errorw("Bad Request", "method", r.Method, "path", r.URL.Path, "error", err.Error())
 
-	writeJSONError(w, http.StatusBadRequest, err.Error())
+	writeProblem(w, newProblem(r, http.StatusBadRequest, err, codeBadRequest, ""))
 }
 
 func (app *application) InvalidBasicAuthorization(w http.ResponseWriter, r *http.Request, err error) {
-	app.logger.Errorw("invalid authorization", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	app.contextLogger(r.Context()).Errorw("invalid authorization", "method", r.Method, "path", r.URL.Path, "error", err.Error())
 
 	w.Header().Set("WWW-Authenticate", `Basic realm="restricted", charset="UTF-8"`)
 
-	writeJSONError(w, http.StatusUnauthorized, "invalid authorization")
+	writeProblem(w, newProblem(r, http.StatusUnauthorized, nil, codeUnauthorized, "invalid authorization"))
 }
 
 func (app *application) InvalidUserAuthorization(w http.ResponseWriter, r *http.Request, err error) {
-	app.logger.Errorw("invalid authorization", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	app.contextLogger(r.Context()).Errorw("invalid authorization", "method", r.Method, "path", r.URL.Path, "error", err.Error())
 
-	writeJSONError(w, http.StatusUnauthorized, "invalid authorization")
+	writeProblem(w, newProblem(r, http.StatusUnauthorized, nil, codeUnauthorized, "invalid authorization"))
 }
 
 func (app *application) ForbiddenRequest(w http.ResponseWriter, r *http.Request, err error) {
-	app.logger.Errorw("Forbidden request", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	app.contextLogger(r.Context()).Errorw("Forbidden request", "method", r.Method, "path", r.URL.Path, "error", err.Error())
 
-	writeJSONError(w, http.StatusForbidden, "Forbidden request")
+	writeProblem(w, newProblem(r, http.StatusForbidden, err, codeForbidden, "Forbidden request"))
 }
 
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter string) {
-	app.logger.Warnf("Maximum Attempt reached", "method", r.Method, "path", r.URL.Path)
+	app.contextLogger(r.Context()).Warnw("rate limit exceeded", "method", r.Method, "path", r.URL.Path)
+
+	w.Header().Set("Retry-After", retryAfter)
+
+	writeProblem(w, newProblem(r, http.StatusTooManyRequests, nil, codeRateLimited, fmt.Sprintf("rate limit exceeded, retry in %v seconds", retryAfter)))
+}
+
+func (app *application) tooManyLoginAttempts(w http.ResponseWriter, r *http.Request, retryAfter string) {
+	app.contextLogger(r.Context()).Warnw("login attempts throttled", "method", r.Method, "path", r.URL.Path, "retry_after", retryAfter)
 
 	w.Header().Set("Retry-After", retryAfter)
 
-	writeJSONError(w, http.StatusUnauthorized, fmt.Sprintf("Maximum Attempted reached, please wait for %v", retryAfter))
+	writeProblem(w, newProblem(r, http.StatusTooManyRequests, nil, codeLoginThrottled, fmt.Sprintf("too many failed login attempts, retry in %v seconds", retryAfter)))
+}
+
+func (app *application) PreconditionFailed(w http.ResponseWriter, r *http.Request, err error) {
+	app.contextLogger(r.Context()).Warnw("precondition failed", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+
+	writeProblem(w, newProblem(r, http.StatusPreconditionFailed, nil, codePreconditionFailed, "resource has been modified, fetch it again and retry"))
+}
+
+func (app *application) PreconditionRequired(w http.ResponseWriter, r *http.Request, err error) {
+	app.contextLogger(r.Context()).Warnw("precondition required", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+
+	writeProblem(w, newProblem(r, http.StatusPreconditionRequired, nil, codePreconditionRequired, "the If-Match header is required"))
+}
+
+func (app *application) RequestEntityTooLarge(w http.ResponseWriter, r *http.Request, err error) {
+	app.contextLogger(r.Context()).Warnw("request entity too large", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+
+	writeProblem(w, newProblem(r, http.StatusRequestEntityTooLarge, nil, codePayloadTooLarge, "the uploaded file is too large"))
+}
+
+func (app *application) ConflictResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.contextLogger(r.Context()).Warnw("conflict", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+
+	writeProblem(w, newProblem(r, http.StatusConflict, err, codeConflict, ""))
 }
@@ -40,6 +40,15 @@ func (app *application) userFeedHandler(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
+	posts := make([]*store.Post, len(*feeds))
+	for i := range *feeds {
+		posts[i] = &(*feeds)[i].Post
+	}
+	if err := app.loadAttachments(ctx, posts...); err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
 	if err := app.jsonResponse(w, http.StatusOK, feeds); err != nil {
 		app.InternaServerError(w, r, err)
 		return
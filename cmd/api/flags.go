@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"tiago-udemy/internal/flags"
+	"tiago-udemy/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type flagsConfig struct {
+	refreshInterval time.Duration
+}
+
+// FlagsMiddleware lets handlers evaluate flags with flags.Enabled(ctx, key).
+// UserAuthMiddleware narrows the evaluation to the authenticated user.
+func (app *application) FlagsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(flags.NewContext(r.Context(), app.flags)))
+	})
+}
+
+// flagRefreshLoop picks up flag changes made on other replicas until ctx is done
+func (app *application) flagRefreshLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.refreshFlags(ctx)
+		}
+	}
+}
+
+func (app *application) refreshFlags(ctx context.Context) {
+	if err := app.flags.Refresh(ctx); err != nil {
+		app.logger.Errorw("feature flag refresh failed", "error", err)
+	}
+}
+
+// ListFeatureFlags godoc
+//
+//	@Summary		Lists feature flags
+//	@Description	Lists feature flags ordered by key
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{array}		store.FeatureFlag
+//	@Failure		401	{object}	Problem
+//	@Failure		403	{object}	Problem
+//	@Failure		500	{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/admin/flags [get]
+func (app *application) listFeatureFlagsHandler(w http.ResponseWriter, r *http.Request) {
+	featureFlags, err := app.store.FeatureFlags.GetAll(r.Context())
+	if err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, featureFlags); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+type CreateFeatureFlagPayload struct {
+	Key               string  `json:"key" validate:"required,max=100,slug"`
+	Description       string  `json:"description" validate:"max=1000"`
+	Enabled           bool    `json:"enabled"`
+	RolloutPercentage int     `json:"rollout_percentage" validate:"gte=0,lte=100"`
+	AllowedUserIDs    []int64 `json:"allowed_user_ids" validate:"dive,gt=0"`
+}
+
+// CreateFeatureFlag godoc
+//
+//	@Summary		Creates a feature flag
+//	@Description	Creates a feature flag, it is off unless enabled. Keys are lowercase slugs such as new-feed or search.v2
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreateFeatureFlagPayload	true	"Flag payload"
+//	@Success		201		{object}	store.FeatureFlag
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		403		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/admin/flags [post]
+func (app *application) createFeatureFlagHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateFeatureFlagPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	flag := &store.FeatureFlag{
+		Key:               payload.Key,
+		Description:       payload.Description,
+		Enabled:           payload.Enabled,
+		RolloutPercentage: payload.RolloutPercentage,
+		AllowedUserIDs:    payload.AllowedUserIDs,
+	}
+	if flag.AllowedUserIDs == nil {
+		flag.AllowedUserIDs = []int64{}
+	}
+
+	ctx := r.Context()
+	if err := app.store.FeatureFlags.Create(ctx, flag); err != nil {
+		switch {
+		case errors.Is(err, store.ErrDuplicateFlag):
+			app.ConflictResponse(w, r, err)
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	app.refreshFlags(ctx)
+
+	if err := app.jsonResponse(w, http.StatusCreated, flag); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+// UpdateFeatureFlagPayload changes only the fields that are set
+type UpdateFeatureFlagPayload struct {
+	Description       *string  `json:"description" validate:"omitempty,max=1000"`
+	Enabled           *bool    `json:"enabled"`
+	RolloutPercentage *int     `json:"rollout_percentage" validate:"omitempty,gte=0,lte=100"`
+	AllowedUserIDs    *[]int64 `json:"allowed_user_ids" validate:"omitempty,dive,gt=0"`
+}
+
+// UpdateFeatureFlag godoc
+//
+//	@Summary		Updates a feature flag
+//	@Description	Turns a flag on or off or changes its rollout. The change applies on this replica at once and on the others within the flag refresh interval.
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			key		path		string						true	"Flag key"
+//	@Param			payload	body		UpdateFeatureFlagPayload	true	"Fields to change"
+//	@Success		200		{object}	store.FeatureFlag
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		403		{object}	Problem
+//	@Failure		404		{object}	Problem
+//	@Failure		409		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/admin/flags/{key} [patch]
+func (app *application) updateFeatureFlagHandler(w http.ResponseWriter, r *http.Request) {
+	var payload UpdateFeatureFlagPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	flag, err := app.store.FeatureFlags.Get(ctx, chi.URLParam(r, "key"))
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrRecordNotFound):
+			app.RecordNotFound(w, r, err)
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	if payload.Description != nil {
+		flag.Description = *payload.Description
+	}
+	if payload.Enabled != nil {
+		flag.Enabled = *payload.Enabled
+	}
+	if payload.RolloutPercentage != nil {
+		flag.RolloutPercentage = *payload.RolloutPercentage
+	}
+	if payload.AllowedUserIDs != nil {
+		flag.AllowedUserIDs = *payload.AllowedUserIDs
+	}
+
+	if err := app.store.FeatureFlags.Update(ctx, flag); err != nil {
+		switch {
+		case errors.Is(err, store.ErrEditConflict):
+			app.ConflictResponse(w, r, err)
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	app.refreshFlags(ctx)
+	app.logger.Infow("feature flag updated", "flag", flag.Key, "enabled", flag.Enabled,
+		"rollout_percentage", flag.RolloutPercentage, "allowed_users", len(flag.AllowedUserIDs),
+		"by", getUserCtx(r).ID)
+
+	if err := app.jsonResponse(w, http.StatusOK, flag); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
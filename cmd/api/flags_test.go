@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"tiago-udemy/internal/auth"
+	"tiago-udemy/internal/flags"
+	"tiago-udemy/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// fakeFeatureFlags keeps flags in memory and checks versions on update like
+// FeatureFlagStore. A key in editedElsewhere is changed by someone else
+// between the handler reading and writing it.
+type fakeFeatureFlags struct {
+	store.FeatureFlagRepository
+	flags           map[string]*store.FeatureFlag
+	editedElsewhere map[string]bool
+}
+
+func (f *fakeFeatureFlags) GetAll(ctx context.Context) ([]store.FeatureFlag, error) {
+	var all []store.FeatureFlag
+	for _, flag := range f.flags {
+		all = append(all, *flag)
+	}
+	return all, nil
+}
+
+func (f *fakeFeatureFlags) Get(ctx context.Context, key string) (*store.FeatureFlag, error) {
+	flag, ok := f.flags[key]
+	if !ok {
+		return nil, store.ErrRecordNotFound
+	}
+	got := *flag
+	if f.editedElsewhere[key] {
+		flag.Version++
+	}
+	return &got, nil
+}
+
+func (f *fakeFeatureFlags) Create(ctx context.Context, flag *store.FeatureFlag) error {
+	if _, ok := f.flags[flag.Key]; ok {
+		return store.ErrDuplicateFlag
+	}
+	flag.Version = 1
+	stored := *flag
+	f.flags[flag.Key] = &stored
+	return nil
+}
+
+func (f *fakeFeatureFlags) Update(ctx context.Context, flag *store.FeatureFlag) error {
+	stored, ok := f.flags[flag.Key]
+	if !ok || stored.Version != flag.Version {
+		return store.ErrEditConflict
+	}
+	flag.Version++
+	*stored = *flag
+	return nil
+}
+
+func TestFeatureFlagHandlers(t *testing.T) {
+	featureFlags := &fakeFeatureFlags{
+		flags: map[string]*store.FeatureFlag{
+			"new-feed":  {Key: "new-feed", Version: 1},
+			"contested": {Key: "contested", Version: 1},
+		},
+		editedElsewhere: map[string]bool{"contested": true},
+	}
+	app := newTestApp()
+	app.store.FeatureFlags = featureFlags
+	app.flags = flags.New(featureFlags.GetAll)
+	app.permissions = testPermissions(t, auth.PermissionFlagsManage)
+
+	router := chi.NewRouter()
+	router.Route("/v1/admin/flags", func(r chi.Router) {
+		r.Use(app.RequirePermission(auth.PermissionFlagsManage))
+		r.Get("/", app.listFeatureFlagsHandler)
+		r.Post("/", app.createFeatureFlagHandler)
+		r.Patch("/{key}", app.updateFeatureFlagHandler)
+	})
+
+	do := func(method, path, body string, roleID int64) *httptest.ResponseRecorder {
+		req := withTestUser(httptest.NewRequest(method, path, strings.NewReader(body)), 8, roleID)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		roleID     int64
+		wantStatus int
+	}{
+		{"list needs the permission", http.MethodGet, "/v1/admin/flags/", "", testUserRole, http.StatusForbidden},
+		{"create needs the permission", http.MethodPost, "/v1/admin/flags/", `{"key":"search.v2"}`, testUserRole, http.StatusForbidden},
+		{"update needs the permission", http.MethodPatch, "/v1/admin/flags/new-feed", `{"enabled":true}`, testUserRole, http.StatusForbidden},
+		{"list", http.MethodGet, "/v1/admin/flags/", "", testModeratorRole, http.StatusOK},
+		{"create", http.MethodPost, "/v1/admin/flags/", `{"key":"search.v2","rollout_percentage":10}`, testModeratorRole, http.StatusCreated},
+		{"create duplicate", http.MethodPost, "/v1/admin/flags/", `{"key":"new-feed"}`, testModeratorRole, http.StatusConflict},
+		{"create with a slash in the key", http.MethodPost, "/v1/admin/flags/", `{"key":"a/b"}`, testModeratorRole, http.StatusBadRequest},
+		{"create with a space in the key", http.MethodPost, "/v1/admin/flags/", `{"key":"new feed"}`, testModeratorRole, http.StatusBadRequest},
+		{"create with an uppercase key", http.MethodPost, "/v1/admin/flags/", `{"key":"NewFeed"}`, testModeratorRole, http.StatusBadRequest},
+		{"create with an invalid rollout", http.MethodPost, "/v1/admin/flags/", `{"key":"beta","rollout_percentage":101}`, testModeratorRole, http.StatusBadRequest},
+		{"update", http.MethodPatch, "/v1/admin/flags/new-feed", `{"enabled":true,"rollout_percentage":100}`, testModeratorRole, http.StatusOK},
+		{"update unknown flag", http.MethodPatch, "/v1/admin/flags/old-feed", `{"enabled":true}`, testModeratorRole, http.StatusNotFound},
+		{"update edited concurrently", http.MethodPatch, "/v1/admin/flags/contested", `{"enabled":true}`, testModeratorRole, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := do(tt.method, tt.path, tt.body, tt.roleID); rr.Code != tt.wantStatus {
+				t.Errorf("want %d, got %d; body=%s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	if flag := featureFlags.flags["search.v2"]; flag == nil || flag.RolloutPercentage != 10 {
+		t.Errorf("want search.v2 created with a 10%% rollout, got %+v", flag)
+	}
+	if flag := featureFlags.flags["new-feed"]; !flag.Enabled || flag.Version != 2 {
+		t.Errorf("want new-feed enabled at version 2, got %+v", flag)
+	}
+	if featureFlags.flags["contested"].Enabled {
+		t.Error("want the concurrent edit kept")
+	}
+	if !app.flags.Enabled("new-feed", 1) {
+		t.Error("want the flag set refreshed after an update")
+	}
+}
@@ -1,6 +1,11 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
 
 // healthcheckHandler godoc
 //
@@ -18,7 +23,106 @@ func (app *application) healthCheckHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	if err := writeJSON(w, http.StatusOK, data); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+// dependencyCheck is a readiness check for something the API talks to. A
+// failing required dependency takes the instance out of rotation, an optional
+// one is only reported.
+type dependencyCheck struct {
+	name     string
+	required bool
+	check    func(ctx context.Context) error
+}
+
+type healthConfig struct {
+	checkTimeout time.Duration
+	drainDelay   time.Duration // how long /readyz fails before the server stops accepting requests
+	checkMail    bool
+}
+
+// dependencyStatus is served on the public /readyz, the error behind a
+// failing check is only logged
+type dependencyStatus struct {
+	Status   string `json:"status"`
+	Required bool   `json:"required"`
+	Latency  string `json:"latency"`
+}
+
+type readinessResponse struct {
+	Status string                      `json:"status"`
+	Checks map[string]dependencyStatus `json:"checks"`
+}
+
+// livezHandler reports that the process is up. It checks no dependencies, an
+// outage elsewhere must not get healthy instances restarted.
+func (app *application) livezHandler(w http.ResponseWriter, r *http.Request) {
+	if err := writeJSON(w, http.StatusOK, map[string]string{"status": "ok"}); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+// readyzHandler reports whether the instance should receive traffic. It
+// fails while shutting down and when a required dependency is unreachable.
+func (app *application) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	res := readinessResponse{Status: "ok", Checks: make(map[string]dependencyStatus, len(app.readiness))}
+	status := http.StatusOK
+
+	if app.draining.Load() {
+		res.Status = "draining"
+		status = http.StatusServiceUnavailable
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, dep := range app.readiness {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			depStatus := app.runCheck(r.Context(), dep)
+
+			mu.Lock()
+			defer mu.Unlock()
+			res.Checks[dep.name] = depStatus
+			if depStatus.Status != "ok" && dep.required {
+				res.Status = "unavailable"
+				status = http.StatusServiceUnavailable
+			}
+		}()
+	}
+	wg.Wait()
+
+	if status != http.StatusOK {
+		app.contextLogger(r.Context()).Warnw("instance not ready", "status", res.Status, "checks", res.Checks)
+	}
+
+	if err := writeJSON(w, status, res); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+// runCheck bounds a check by the configured timeout, also for clients that
+// ignore the context
+func (app *application) runCheck(ctx context.Context, dep dependencyCheck) dependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, app.config.health.checkTimeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- dep.check(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
 
-		writeJSONError(w, http.StatusInternalServerError, err.Error())
+	res := dependencyStatus{Status: "ok", Required: dep.required, Latency: time.Since(start).String()}
+	if err != nil {
+		res.Status = "failing"
+		app.contextLogger(ctx).Warnw("readiness check failed", "dependency", dep.name, "required", dep.required, "error", err)
 	}
+	return res
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadyzHandler(t *testing.T) {
+	ok := func(ctx context.Context) error { return nil }
+	down := func(ctx context.Context) error { return errors.New("connection refused") }
+	hang := func(ctx context.Context) error { time.Sleep(time.Second); return nil } // ignores ctx
+
+	tests := []struct {
+		name       string
+		checks     []dependencyCheck
+		draining   bool
+		wantStatus int
+	}{
+		{name: "all up", checks: []dependencyCheck{{name: "postgres", required: true, check: ok}}, wantStatus: http.StatusOK},
+		{name: "required down", checks: []dependencyCheck{{name: "postgres", required: true, check: down}}, wantStatus: http.StatusServiceUnavailable},
+		{name: "optional down", checks: []dependencyCheck{{name: "postgres", required: true, check: ok}, {name: "mail", check: down}}, wantStatus: http.StatusOK},
+		{name: "required hangs", checks: []dependencyCheck{{name: "redis", required: true, check: hang}}, wantStatus: http.StatusServiceUnavailable},
+		{name: "draining", checks: []dependencyCheck{{name: "postgres", required: true, check: ok}}, draining: true, wantStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp()
+			app.config.health.checkTimeout = 50 * time.Millisecond
+			app.readiness = tt.checks
+			app.draining.Store(tt.draining)
+
+			rr := httptest.NewRecorder()
+			app.readyzHandler(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("want %d, got %d; body=%s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+
+			if strings.Contains(rr.Body.String(), "connection refused") {
+				t.Errorf("want check errors kept out of the response, got %s", rr.Body.String())
+			}
+
+			var res readinessResponse
+			if err := json.NewDecoder(rr.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+			if len(res.Checks) != len(tt.checks) {
+				t.Errorf("want every dependency reported, got %v", res.Checks)
+			}
+		})
+	}
+}
@@ -3,14 +3,34 @@ package main
 import (
 	"encoding/json"
 	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
 )
 
 var Validate *validator.Validate
 
+// slugRe matches lowercase words joined by single dashes, dots or
+// underscores, e.g. "new-feed" or "search.v2", safe to use in a URL path
+var slugRe = regexp.MustCompile(`^[a-z0-9]+([-._][a-z0-9]+)*$`)
+
 func init() {
 	Validate = validator.New(validator.WithRequiredStructEnabled())
+
+	// report fields by the names clients send
+	Validate.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	Validate.RegisterValidation("slug", func(fl validator.FieldLevel) bool {
+		return slugRe.MatchString(fl.Field().String())
+	})
 }
 
 func writeJSON(w http.ResponseWriter, status int, data any) error {
@@ -30,14 +50,6 @@ func readJSON(w http.ResponseWriter, r *http.Request, data any) error {
 	return decoder.Decode(data)
 }
 
-func writeJSONError(w http.ResponseWriter, status int, message string) error {
-	type envelop struct {
-		Error string `json:"error"`
-	}
-
-	return writeJSON(w, status, &envelop{Error: message})
-}
-
 func (app *application) jsonResponse(w http.ResponseWriter, status int, data any) error {
 	type envelop struct {
 		Data any `json:"data"`
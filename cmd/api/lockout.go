@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"tiago-udemy/internal/mailer"
+	"tiago-udemy/internal/store"
+	"time"
+)
+
+type lockoutConfig struct {
+	// window is how long a failed login counts towards the thresholds
+	window           time.Duration
+	accountThreshold int
+	ipThreshold      int
+	duration         time.Duration
+	baseDelay        time.Duration
+	maxDelay         time.Duration
+}
+
+// loginAttemptKeys returns the keys failed logins are counted under. The
+// account is keyed by email so unknown addresses behave like real ones.
+func loginAttemptKeys(r *http.Request, email string) (account string, ip string) {
+	return "email:" + strings.ToLower(email), "ip:" + clientIP(r)
+}
+
+// retryAfter returns how long the owner of attempt has to wait before trying
+// again. The wait doubles with every failure up to maxDelay, and lasts until
+// the end of the lockout once the key is locked.
+func (c lockoutConfig) retryAfter(attempt *store.LoginAttempt, now time.Time) time.Duration {
+	if attempt.LockedUntil.Valid && now.Before(attempt.LockedUntil.Time) {
+		return attempt.LockedUntil.Time.Sub(now)
+	}
+
+	delay := c.maxDelay
+	if shift := attempt.Failures - 1; shift < 16 {
+		delay = min(c.baseDelay<<shift, c.maxDelay)
+	}
+
+	return max(attempt.LastFailureAt.Add(delay).Sub(now), 0)
+}
+
+// loginRetryAfter returns the longest wait among the given keys
+func (app *application) loginRetryAfter(ctx context.Context, keys ...string) (time.Duration, error) {
+	var wait time.Duration
+	now := time.Now()
+
+	for _, key := range keys {
+		attempt, err := app.store.LoginAttempts.Get(ctx, key)
+		if err != nil {
+			if errors.Is(err, store.ErrRecordNotFound) {
+				continue
+			}
+			return 0, err
+		}
+		wait = max(wait, app.config.authConfig.lockout.retryAfter(attempt, now))
+	}
+
+	return wait, nil
+}
+
+// recordLoginFailure counts a failed login against the account and the IP,
+// and emails the user when their account just got locked
+func (app *application) recordLoginFailure(ctx context.Context, user *store.User, accountKey, ipKey string) {
+	cfg := app.config.authConfig.lockout
+
+	if _, err := app.store.LoginAttempts.RecordFailure(ctx, ipKey, cfg.window, cfg.ipThreshold, cfg.duration); err != nil {
+		app.logger.Errorw("recording failed login failed", "key", ipKey, "error", err)
+	}
+
+	attempt, err := app.store.LoginAttempts.RecordFailure(ctx, accountKey, cfg.window, cfg.accountThreshold, cfg.duration)
+	if err != nil {
+		app.logger.Errorw("recording failed login failed", "key", accountKey, "error", err)
+		return
+	}
+
+	if attempt.Failures == cfg.accountThreshold && user.ID != 0 {
+		app.logger.Warnw("account locked", "user_id", user.ID, "locked_until", attempt.LockedUntil.Time)
+
+		// sent in the background so the response time does not reveal that the email exists
+		go app.sendAccountLockedEmail(user, attempt.LockedUntil.Time)
+	}
+}
+
+func (app *application) sendAccountLockedEmail(user *store.User, lockedUntil time.Time) {
+	data := struct {
+		Username    string
+		LockedUntil string
+	}{
+		Username:    user.Username,
+		LockedUntil: lockedUntil.UTC().Format(time.RFC1123),
+	}
+
+	status, err := app.mailer.Send(context.Background(), mailer.AccountLockedTemplate, user.Email, data)
+	if err != nil {
+		app.logger.Errorw("account locked email failed", "user_id", user.ID, "error", err)
+		return
+	}
+
+	app.logger.Infow("Email sent", "status code", status)
+}
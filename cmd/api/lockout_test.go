@@ -0,0 +1,69 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"tiago-udemy/internal/store"
+	"time"
+)
+
+func TestLockoutRetryAfter(t *testing.T) {
+	cfg := lockoutConfig{
+		baseDelay: time.Second,
+		maxDelay:  30 * time.Second,
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		attempt store.LoginAttempt
+		want    time.Duration
+	}{
+		{
+			name:    "first failure waits the base delay",
+			attempt: store.LoginAttempt{Failures: 1, LastFailureAt: now},
+			want:    time.Second,
+		},
+		{
+			name:    "delay doubles with each failure",
+			attempt: store.LoginAttempt{Failures: 4, LastFailureAt: now.Add(-3 * time.Second)},
+			want:    5 * time.Second,
+		},
+		{
+			name:    "delay is capped",
+			attempt: store.LoginAttempt{Failures: 40, LastFailureAt: now},
+			want:    30 * time.Second,
+		},
+		{
+			name:    "delay already passed",
+			attempt: store.LoginAttempt{Failures: 2, LastFailureAt: now.Add(-time.Minute)},
+			want:    0,
+		},
+		{
+			name: "locked account waits for the lock to expire",
+			attempt: store.LoginAttempt{
+				Failures:      10,
+				LastFailureAt: now.Add(-time.Hour),
+				LockedUntil:   sql.NullTime{Time: now.Add(10 * time.Minute), Valid: true},
+			},
+			want: 10 * time.Minute,
+		},
+		{
+			name: "expired lock",
+			attempt: store.LoginAttempt{
+				Failures:      10,
+				LastFailureAt: now.Add(-time.Hour),
+				LockedUntil:   sql.NullTime{Time: now.Add(-time.Minute), Valid: true},
+			},
+			want: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfg.retryAfter(&tt.attempt, now); got != tt.want {
+				t.Errorf("retryAfter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+type accessLogConfig struct {
+	sampleRate    float64  // share of successful requests that are logged, failures always are
+	headers       bool     // log request headers
+	redactHeaders []string // headers logged as redactedValue
+}
+
+const redactedValue = "[REDACTED]"
+
+type accessLogKey string
+
+const accessLogCtx accessLogKey = "accessLog"
+
+// accessLogFields carries values that are only known further down the chain
+// back up to the access log
+type accessLogFields struct {
+	userID int64
+}
+
+// setAccessLogUser records the authenticated user on the request's access log
+func setAccessLogUser(ctx context.Context, userID int64) {
+	if fields, ok := ctx.Value(accessLogCtx).(*accessLogFields); ok {
+		fields.userID = userID
+	}
+}
+
+// RequestIDHeaderMiddleware echoes the request ID set by middleware.RequestID,
+// so clients can quote it when reporting a problem
+func RequestIDHeaderMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := middleware.GetReqID(r.Context()); id != "" {
+			w.Header().Set(requestIDHeader, id)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AccessLogMiddleware logs one line per request. Successful requests are
+// sampled, client and server errors are always logged.
+func (app *application) AccessLogMiddleware(cfg accessLogConfig) func(http.Handler) http.Handler {
+	redact := make(map[string]bool, len(cfg.redactHeaders))
+	for _, h := range cfg.redactHeaders {
+		redact[http.CanonicalHeaderKey(strings.TrimSpace(h))] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			fields := &accessLogFields{}
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), accessLogCtx, fields)))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if status < http.StatusBadRequest && rand.Float64() >= cfg.sampleRate {
+				return
+			}
+
+			kv := []any{
+				"method", r.Method,
+				"route", chi.RouteContext(r.Context()).RoutePattern(),
+				"path", r.URL.Path,
+				"status", status,
+				"bytes", ww.BytesWritten(),
+				"duration", time.Since(start),
+				"remote_ip", clientIP(r),
+			}
+			if fields.userID != 0 {
+				kv = append(kv, "user_id", fields.userID)
+			}
+			if cfg.headers {
+				kv = append(kv, "headers", logHeaders(r.Header, redact))
+			}
+
+			logger := app.contextLogger(r.Context())
+			switch {
+			case status >= http.StatusInternalServerError:
+				logger.Errorw("request", kv...)
+			case status >= http.StatusBadRequest:
+				logger.Warnw("request", kv...)
+			default:
+				logger.Infow("request", kv...)
+			}
+		})
+	}
+}
+
+func logHeaders(h http.Header, redact map[string]bool) map[string]any {
+	out := make(map[string]any, len(h))
+	for name, values := range h {
+		if redact[name] {
+			out[name] = redactedValue
+			continue
+		}
+		if len(values) == 1 {
+			out[name] = values[0]
+		} else {
+			out[name] = values
+		}
+	}
+	return out
+}
+
+// contextLogger returns the logger with the request ID and the trace and span
+// IDs of ctx, so log lines can be matched to a request and its trace
+func (app *application) contextLogger(ctx context.Context) *zap.SugaredLogger {
+	logger := app.logger
+	if id := middleware.GetReqID(ctx); id != "" {
+		logger = logger.With("request_id", id)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		logger = logger.With("trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
+	}
+	return logger
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"tiago-udemy/internal/store"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestAccessLogMiddleware(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	app := newTestApp()
+	app.logger = zap.New(core).Sugar()
+
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(RequestIDHeaderMiddleware)
+	r.Use(app.AccessLogMiddleware(accessLogConfig{headers: true, redactHeaders: []string{" authorization"}}))
+	r.Get("/things/{thingID}", func(w http.ResponseWriter, r *http.Request) {
+		setAccessLogUser(r.Context(), 42)
+		app.RecordNotFound(w, r, store.ErrRecordNotFound)
+	})
+	r.Get("/ok", func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest(http.MethodGet, "/things/1", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if got := rr.Header().Get("X-Request-ID"); got != "req-123" {
+		t.Errorf("want request id echoed, got %q", got)
+	}
+
+	if n := logs.FilterField(zap.String("request_id", "req-123")).Len(); n != 2 {
+		t.Fatalf("want the error and access log lines to carry the request id, got %v", logs.All())
+	}
+
+	access := logs.FilterMessage("request").All()
+	if len(access) != 1 {
+		t.Fatalf("want 1 access log line, got %d", len(access))
+	}
+	fields := access[0].ContextMap()
+	if fields["route"] != "/things/{thingID}" || fields["status"] != int64(http.StatusNotFound) || fields["user_id"] != int64(42) {
+		t.Errorf("unexpected access log fields %v", fields)
+	}
+	if headers := fields["headers"].(map[string]any); headers["Authorization"] != redactedValue {
+		t.Errorf("authorization header should be redacted, got %v", headers["Authorization"])
+	}
+
+	// sampled out successful requests are not logged
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+	if n := logs.FilterMessage("request").Len(); n != 1 {
+		t.Errorf("want successful requests sampled out at rate 0, got %d access log lines", n)
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"tiago-udemy/internal/mailer"
+	"tiago-udemy/internal/store"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// ReauthPayload confirms a sensitive account change. Password is the current
+// password, Code a TOTP or recovery code when two-factor authentication is on.
+type ReauthPayload struct {
+	Password string `json:"password" validate:"max=50"`
+	Code     string `json:"code" validate:"max=20"`
+}
+
+// reauthenticate checks the current password and second factor again before a
+// sensitive change. Users without a password, who signed up through social
+// login, must have logged in within reauthWindow instead. It writes the error
+// response and returns false when the check fails.
+func (app *application) reauthenticate(w http.ResponseWriter, r *http.Request, payload ReauthPayload) bool {
+	user := getUserCtx(r)
+	session := getSessionCtx(r)
+	ctx := r.Context()
+	attemptKey := twoFactorAttemptKey(user.ID)
+
+	wait, err := app.loginRetryAfter(ctx, attemptKey)
+	if err != nil {
+		app.InternaServerError(w, r, err)
+		return false
+	}
+	if wait > 0 {
+		app.tooManyLoginAttempts(w, r, strconv.Itoa(ceilSeconds(wait)))
+		return false
+	}
+
+	// GetUserbyID does not load the password hash
+	withPassword, err := app.store.Users.GetUserByEmail(ctx, user.Email)
+	if err != nil {
+		app.InternaServerError(w, r, err)
+		return false
+	}
+
+	if withPassword.Password.IsSet() {
+		if err := withPassword.Password.Compare(payload.Password); err != nil {
+			app.recordSecondFactorFailure(ctx, attemptKey)
+			app.InvalidUserAuthorization(w, r, fmt.Errorf("invalid credentials"))
+			return false
+		}
+	} else if time.Since(session.CreatedAt) > app.config.authConfig.reauthWindow {
+		app.InvalidUserAuthorization(w, r, fmt.Errorf("log in again to confirm this change"))
+		return false
+	}
+
+	twoFactor, err := app.store.TwoFactor.Get(ctx, user.ID)
+	if err != nil && !errors.Is(err, store.ErrRecordNotFound) {
+		app.InternaServerError(w, r, err)
+		return false
+	}
+	if twoFactor != nil && twoFactor.Enabled {
+		if err := app.checkSecondFactor(ctx, twoFactor, payload.Code); err != nil {
+			app.secondFactorFailed(w, r, attemptKey, err)
+			return false
+		}
+	}
+
+	return true
+}
+
+// GetMe godoc
+//
+//	@Summary		Fetches the current user
+//	@Description	Fetches the account of the logged in user
+//	@Tags			me
+//	@Produce		json
+//	@Success		200	{object}	store.User
+//	@Failure		401	{object}	Problem
+//	@Failure		403	{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/me [get]
+func (app *application) getMeHandler(w http.ResponseWriter, r *http.Request) {
+	if err := app.jsonResponse(w, http.StatusOK, getUserCtx(r)); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+type UpdateProfilePayload struct {
+	DisplayName *string `json:"display_name" validate:"omitempty,max=100"`
+	Bio         *string `json:"bio" validate:"omitempty,max=500"`
+	AvatarURL   *string `json:"avatar_url" validate:"omitempty,max=2048,eq=|http_url"`
+}
+
+// UpdateMe godoc
+//
+//	@Summary		Updates the current user's profile
+//	@Description	Updates the display name, bio and avatar of the logged in user. Omitted fields are left unchanged
+//	@Tags			me
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		UpdateProfilePayload	true	"Profile payload"
+//	@Success		200		{object}	store.User
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		403		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/me [patch]
+func (app *application) updateMeHandler(w http.ResponseWriter, r *http.Request) {
+	var payload UpdateProfilePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	// the user in the context may be the cached copy
+	user := *getUserCtx(r)
+	if payload.DisplayName != nil {
+		user.DisplayName = *payload.DisplayName
+	}
+	if payload.Bio != nil {
+		user.Bio = *payload.Bio
+	}
+	if payload.AvatarURL != nil {
+		user.AvatarURL = *payload.AvatarURL
+	}
+
+	if err := app.store.Users.UpdateProfile(r.Context(), &user); err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+type ChangeEmailPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+	ReauthPayload
+}
+
+// ChangeEmail godoc
+//
+//	@Summary		Changes the current user's email
+//	@Description	Emails a confirmation link to the new address. The account keeps its current address until the link is used. When the address already belongs to an account its owner is notified instead, the response is the same
+//	@Tags			me
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ChangeEmailPayload	true	"Email change payload"
+//	@Success		202		{string}	string				"Confirmation sent"
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		403		{object}	Problem
+//	@Failure		429		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/me/email [put]
+func (app *application) changeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ChangeEmailPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if !app.reauthenticate(w, r, payload.ReauthPayload) {
+		return
+	}
+
+	user := getUserCtx(r)
+	ctx := r.Context()
+
+	plainToken := uuid.New().String()
+
+	hash := sha256.Sum256([]byte(plainToken))
+	hashToken := hex.EncodeToString(hash[:])
+
+	confirmURL := fmt.Sprintf("%s/confirm-email/%s", app.config.frontendURL, plainToken)
+	template := mailer.EmailChangeTemplate
+	var data any = struct {
+		Username   string
+		ConfirmURL string
+	}{
+		Username:   user.Username,
+		ConfirmURL: confirmURL,
+	}
+
+	// the response is the same whether or not the address is taken, so it
+	// cannot be used to find out who has an account. The owner of the address
+	// is told by mail instead.
+	if err := app.store.Users.RequestEmailChange(ctx, user.ID, payload.Email, hashToken, app.config.mail.exp); err != nil {
+		switch {
+		case errors.Is(err, store.ErrDuplicateEmail):
+			template, data = mailer.EmailInUseTemplate, struct{}{}
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	status, err := app.mailer.Send(ctx, template, payload.Email, data)
+	if err != nil {
+		// the request is stored, the user can ask for another link
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	app.logger.Infow("Email sent", "status code", status)
+
+	if err := app.jsonResponse(w, http.StatusAccepted, nil); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+// ConfirmEmailChange godoc
+//
+//	@Summary		Confirms an email change
+//	@Description	Moves the account to the new address using the token emailed to it
+//	@Tags			authentication
+//	@Produce		json
+//	@Param			token	path		string	true	"Email change token"
+//	@Success		200		{string}	string	"Email changed"
+//	@Failure		400		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Router			/authentication/email/{token} [put]
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	plainToken := chi.URLParam(r, "token")
+
+	if plainToken == "" {
+		app.StatusBadRequest(w, r, clientErrorf("missing tokens"))
+		return
+	}
+
+	hash := sha256.Sum256([]byte(plainToken))
+	hashToken := hex.EncodeToString(hash[:])
+
+	if _, err := app.store.Users.ConfirmEmailChange(r.Context(), hashToken); err != nil {
+		switch {
+		case errors.Is(err, store.ErrInvalidToken), errors.Is(err, store.ErrDuplicateEmail):
+			app.StatusBadRequest(w, r, err)
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, "Email Successfully Changed"); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+type ChangePasswordPayload struct {
+	NewPassword string `json:"new_password" validate:"required,min=8,max=50"`
+	ReauthPayload
+}
+
+// ChangePassword godoc
+//
+//	@Summary		Changes the current user's password
+//	@Description	Sets a new password after checking the current one, and logs out every other session
+//	@Tags			me
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ChangePasswordPayload	true	"Password change payload"
+//	@Success		204		{string}	string					"Password changed"
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		403		{object}	Problem
+//	@Failure		429		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/me/password [put]
+func (app *application) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ChangePasswordPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if !app.reauthenticate(w, r, payload.ReauthPayload) {
+		return
+	}
+
+	user := &store.User{ID: getUserCtx(r).ID}
+	if err := user.Password.Set(payload.NewPassword); err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	if err := app.store.Users.UpdatePassword(ctx, user); err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	if _, err := app.store.Sessions.DeleteOthers(ctx, user.ID, getSessionCtx(r).ID); err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+// DeleteMe godoc
+//
+//	@Summary		Deletes the current user's account
+//	@Description	Deletes the logged in user together with their posts, comments, uploads and data exports
+//	@Tags			me
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ReauthPayload	true	"Re-authentication payload"
+//	@Success		204		{string}	string			"Account deleted"
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		403		{object}	Problem
+//	@Failure		429		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/me [delete]
+func (app *application) deleteMeHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ReauthPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if !app.reauthenticate(w, r, payload) {
+		return
+	}
+
+	// erasing first deletes the files the rows point to, the cascade from
+	// deleting the user would only drop the rows
+	ctx := r.Context()
+	userID := getUserCtx(r).ID
+	if err := app.eraseUser(ctx, userID, true); err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Users.Delete(ctx, userID); err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"tiago-udemy/internal/blob"
+	"tiago-udemy/internal/store"
+	"time"
+)
+
+func TestUpdateMeHandler(t *testing.T) {
+	app := newTestApp()
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		want       store.User
+	}{
+		{
+			name:       "omitted fields are kept",
+			body:       `{"bio": "new bio"}`,
+			wantStatus: http.StatusOK,
+			want:       store.User{DisplayName: "Gopher", Bio: "new bio", AvatarURL: "https://example.com/a.png"},
+		},
+		{
+			name:       "empty string clears a field",
+			body:       `{"avatar_url": ""}`,
+			wantStatus: http.StatusOK,
+			want:       store.User{DisplayName: "Gopher", Bio: "old bio"},
+		},
+		{
+			name:       "avatar must be a web URL",
+			body:       `{"avatar_url": "javascript:alert(1)"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &store.User{ID: 1, DisplayName: "Gopher", Bio: "old bio", AvatarURL: "https://example.com/a.png"}
+
+			req := httptest.NewRequest(http.MethodPatch, "/v1/me", strings.NewReader(tt.body))
+			req = req.WithContext(context.WithValue(req.Context(), userCtx, user))
+			rr := httptest.NewRecorder()
+			app.updateMeHandler(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("want %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Data store.User `json:"data"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			got := resp.Data
+			if got.DisplayName != tt.want.DisplayName || got.Bio != tt.want.Bio || got.AvatarURL != tt.want.AvatarURL {
+				t.Errorf("got %q/%q/%q, want %q/%q/%q", got.DisplayName, got.Bio, got.AvatarURL, tt.want.DisplayName, tt.want.Bio, tt.want.AvatarURL)
+			}
+			if user.Bio != "old bio" {
+				t.Error("the user in the context was modified")
+			}
+		})
+	}
+}
+
+// fakeLoginAttempts records no failures, nobody is locked out
+type fakeLoginAttempts struct {
+	store.LoginAttemptRepository
+}
+
+func (f *fakeLoginAttempts) Get(ctx context.Context, key string) (*store.LoginAttempt, error) {
+	return nil, store.ErrRecordNotFound
+}
+
+func (f *fakeLoginAttempts) RecordFailure(ctx context.Context, key string, window time.Duration, threshold int, lockout time.Duration) (*store.LoginAttempt, error) {
+	return &store.LoginAttempt{}, nil
+}
+
+// fakeTwoFactor holds the two-factor state of every user and the hashes of
+// their unused recovery codes
+type fakeTwoFactor struct {
+	store.TwoFactorRepository
+	users    map[int64]*store.TwoFactor
+	recovery map[string]bool
+}
+
+func (f *fakeTwoFactor) UseRecoveryCode(ctx context.Context, userID int64, hashcode string) error {
+	if !f.recovery[hashcode] {
+		return store.ErrRecordNotFound
+	}
+	delete(f.recovery, hashcode)
+	return nil
+}
+
+func (f *fakeTwoFactor) Get(ctx context.Context, userID int64) (*store.TwoFactor, error) {
+	tf, ok := f.users[userID]
+	if !ok {
+		return nil, store.ErrRecordNotFound
+	}
+	return tf, nil
+}
+
+func (f *fakeTwoFactor) Disable(ctx context.Context, userID int64) error {
+	delete(f.users, userID)
+	return nil
+}
+
+// withTestSession stands in for the session UserAuthMiddleware loads
+func withTestSession(r *http.Request, userID int64, createdAt time.Time) *http.Request {
+	session := &store.Session{ID: "test-session", UserID: userID, CreatedAt: createdAt}
+	return r.WithContext(context.WithValue(r.Context(), sessionCtx, session))
+}
+
+type deletingUsers struct {
+	store.MockUserStore
+	deleted []int64
+}
+
+func (f *deletingUsers) Delete(ctx context.Context, id int64) error {
+	f.deleted = append(f.deleted, id)
+	return nil
+}
+
+func TestDeleteMeErasesFiles(t *testing.T) {
+	blobs, err := blob.NewFilesystemStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{"exports/1/old.json", "attachments/1/a.png", "attachments/1/a_thumb.png"}
+	for _, key := range keys {
+		if err := blobs.Put(context.Background(), key, strings.NewReader("personal data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	users := &deletingUsers{}
+	app := newTestApp()
+	app.blobs = blobs
+	app.config.authConfig.reauthWindow = time.Minute
+	app.store.Users = users
+	app.store.DataJobs = &fakeDataJobs{job: store.DataJob{ArchiveKey: keys[0]}}
+	app.store.LoginAttempts = &fakeLoginAttempts{}
+	app.store.TwoFactor = &fakeTwoFactor{}
+
+	req := httptest.NewRequest(http.MethodDelete, "/v1/me", strings.NewReader(`{}`))
+	req = withTestSession(withTestUser(req, 1, testUserRole), 1, time.Now())
+	rr := httptest.NewRecorder()
+	app.deleteMeHandler(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("want 204, got %d; body=%s", rr.Code, rr.Body.String())
+	}
+	if len(users.deleted) != 1 || users.deleted[0] != 1 {
+		t.Errorf("want user 1 deleted, got %v", users.deleted)
+	}
+	for _, key := range keys {
+		if _, err := blobs.Get(context.Background(), key); err != blob.ErrNotFound {
+			t.Errorf("%s not deleted with the account: %v", key, err)
+		}
+	}
+}
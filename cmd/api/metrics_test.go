@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	app := newTestApp()
+
+	r := chi.NewRouter()
+	r.Use(app.MetricsMiddleware)
+	r.Get("/things/{thingID}", func(w http.ResponseWriter, r *http.Request) {
+		if chi.URLParam(r, "thingID") == "missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	for _, path := range []string{"/things/1", "/things/2", "/things/missing", "/nowhere"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if got := testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, "/things/{thingID}", "200")); got != 2 {
+		t.Errorf("want 2 requests under the route pattern, got %v", got)
+	}
+	if got := testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, "/things/{thingID}", "404")); got != 1 {
+		t.Errorf("want 1 not found request, got %v", got)
+	}
+	if got := testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, "unmatched", "404")); got != 1 {
+		t.Errorf("want 1 unmatched request, got %v", got)
+	}
+
+	rr := httptest.NewRecorder()
+	app.mountAdmin().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want 200 from /metrics, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `http_requests_total{method="GET",route="/things/{thingID}",status="200"} 2`) {
+		t.Errorf("metrics output misses the request counter")
+	}
+}
@@ -3,15 +3,57 @@ package main
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
+	"net"
 	"net/http"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"tiago-udemy/internal/flags"
 	"tiago-udemy/internal/store"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// RecoverPanic answers a panicking handler with an internal error problem.
+// http.ErrAbortHandler is passed on, it asks the server to drop the
+// connection.
+func (app *application) RecoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rvr := recover()
+			if rvr == nil {
+				return
+			}
+			if rvr == http.ErrAbortHandler {
+				panic(rvr)
+			}
+
+			app.contextLogger(r.Context()).Errorw("panic recovered", "method", r.Method, "path", r.URL.Path, "panic", rvr, "stack", string(debug.Stack()))
+
+			w.Header().Set("Connection", "close")
+			writeProblem(w, newProblem(r, http.StatusInternalServerError, nil, codeInternal, "the server encountered a problem"))
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowedMethods lists the methods routes serves path with, for the Allow
+// header of a 405
+func allowedMethods(routes chi.Routes, path string) []string {
+	var allowed []string
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions} {
+		if routes.Match(chi.NewRouteContext(), method, path) {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
+
 type userKey string
 
 const userCtx userKey = "user"
@@ -42,7 +84,7 @@ func (app *application) BasicAuthMiddleware() func(http.Handler) http.Handler {
 			}
 
 			creds := strings.SplitN(string(decoded), ":", 2)
-			if len(auth_list) != 2 ||
+			if len(creds) != 2 ||
 				creds[0] != app.config.authConfig.basicAuth.username ||
 				creds[1] != app.config.authConfig.basicAuth.password {
 				app.InvalidBasicAuthorization(w, r, fmt.Errorf("incorrect username/pass"))
@@ -62,70 +104,154 @@ func (app *application) UserAuthMiddleware(next http.Handler) http.Handler {
 		auth := r.Header.Get("Authorization")
 
 		if auth == "" {
-			app.InvalidUserAuthorization(w, r, fmt.Errorf("no auth found"))
+			app.authenticationFailed(w, r, fmt.Errorf("no auth found"))
 			return
 		}
 
 		auth_list := strings.SplitN(auth, " ", 2)
 
-		if len(auth_list) != 2 || auth_list[0] != "Bearer" {
-			app.InvalidUserAuthorization(w, r, fmt.Errorf("malformed Auth"))
+		if len(auth_list) != 2 {
+			app.authenticationFailed(w, r, fmt.Errorf("malformed Auth"))
 			return
 		}
 
-		tokens := auth_list[1]
+		ctx := r.Context()
+
+		var userID int64
+		switch auth_list[0] {
+		case "Bearer":
+			id, sid, err := app.parseAccessToken(auth_list[1])
+			if err != nil {
+				app.authenticationFailed(w, r, err)
+				return
+			}
+
+			session, err := app.getSession(ctx, sid)
+			if err != nil {
+				switch {
+				case errors.Is(err, store.ErrRecordNotFound):
+					app.authenticationFailed(w, r, fmt.Errorf("session %s is revoked or expired", sid))
+					return
+				default:
+					app.InternaServerError(w, r, err)
+					return
+				}
+			}
+			if session.UserID != id {
+				app.authenticationFailed(w, r, fmt.Errorf("session %s belongs to another user", sid))
+				return
+			}
+
+			userID = id
+			ctx = context.WithValue(ctx, sessionCtx, session)
+		case "ApiKey":
+			key, err := app.store.APIKeys.GetByHash(ctx, sha256Hex(auth_list[1]))
+			if err != nil {
+				switch {
+				case errors.Is(err, store.ErrRecordNotFound):
+					app.authenticationFailed(w, r, fmt.Errorf("invalid or expired api key"))
+					return
+				default:
+					app.InternaServerError(w, r, err)
+					return
+				}
+			}
+
+			if err := app.store.APIKeys.TouchLastUsed(ctx, key.ID); err != nil {
+				app.logger.Errorw("api key last used update failed", "api_key_id", key.ID, "error", err)
+			}
 
-		jwt_tokens, err := app.authenticator.ValidateToken(tokens)
+			userID = key.UserID
+			ctx = context.WithValue(ctx, apiKeyCtx, key)
+		default:
+			app.authenticationFailed(w, r, fmt.Errorf("malformed Auth"))
+			return
+		}
+
+		//Extract User
+		users, err := app.store.Users.GetUserbyID(ctx, userID)
 		if err != nil {
-			app.InvalidUserAuthorization(w, r, err)
+			app.authenticationFailed(w, r, fmt.Errorf("invalid token subject"))
 			return
 		}
 
-		claims, ok := jwt_tokens.Claims.(jwt.MapClaims)
-		if !ok || !jwt_tokens.Valid {
-			app.InvalidUserAuthorization(w, r, fmt.Errorf("invalid token claims"))
+		if !users.IsActivated {
+			app.authenticationFailed(w, r, fmt.Errorf("user %d is not active", users.ID))
 			return
 		}
 
-		// extract userId from claims
-		userID, ok := claims["sub"].(float64)
-		if !ok {
-			app.InvalidUserAuthorization(w, r, fmt.Errorf("invalid token subject"))
+		if users.IsSuspended {
+			app.ForbiddenRequest(w, r, fmt.Errorf("user %d is suspended", users.ID))
 			return
 		}
-		ctx := r.Context()
 
-		//Extract User
-		users, err := app.getUserFromCache(ctx, int64(userID))
-		if err != nil {
-			app.InvalidUserAuthorization(w, r, fmt.Errorf("invalid token subject"))
+		if users.IsDisabled {
+			app.ForbiddenRequest(w, r, errAccountDisabled)
 			return
 		}
 
+		setAccessLogUser(ctx, users.ID)
+		ctx = flags.WithUser(ctx, users.ID)
 		ctx = context.WithValue(ctx, userCtx, users)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 
 }
 
-func (app *application) UserPostAuthorizationMiddleware(requiredRole string) func(http.Handler) http.Handler {
+// authenticationFailed charges a rejected credential to the client's IP. Once
+// the auth_failure policy is spent the client gets 429 instead of 401, valid
+// credentials from the same address are not affected.
+func (app *application) authenticationFailed(w http.ResponseWriter, r *http.Request, err error) {
+	if limiter, ok := app.limiters[rateLimitAuthFailure]; ok {
+		res := limiter.Allow(rateLimitAuthFailure + ":ip:" + clientIP(r))
+		if !res.Allowed {
+			app.rateLimitExceededResponse(w, r, strconv.Itoa(ceilSeconds(res.Reset)))
+			return
+		}
+	}
+
+	app.InvalidUserAuthorization(w, r, err)
+}
+
+// parseAccessToken validates a JWT issued at login and returns its subject
+// and session
+func (app *application) parseAccessToken(token string) (int64, string, error) {
+	jwt_tokens, err := app.authenticator.ValidateToken(token)
+	if err != nil {
+		return 0, "", err
+	}
+
+	claims, ok := jwt_tokens.Claims.(jwt.MapClaims)
+	if !ok || !jwt_tokens.Valid {
+		return 0, "", fmt.Errorf("invalid token claims")
+	}
+
+	// challenge tokens only grant access to the second login step
+	if _, ok := claims["typ"]; ok {
+		return 0, "", fmt.Errorf("not an access token")
+	}
+
+	// extract userId from claims
+	userID, ok := claims["sub"].(float64)
+	if !ok {
+		return 0, "", fmt.Errorf("invalid token subject")
+	}
+
+	sid, ok := claims["sid"].(string)
+	if !ok || sid == "" {
+		return 0, "", fmt.Errorf("token has no session")
+	}
+
+	return int64(userID), sid, nil
+}
+
+func (app *application) UserPostAuthorizationMiddleware(permission string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			user := getUserCtx(r)
 			post := getPostCtx(r)
 
-			if user.ID == post.UserID {
-				// the owner can always proceed
-				next.ServeHTTP(w, r)
-				return
-			}
-			ctx := r.Context()
-			allow, err := app.store.Role.HasPermission(ctx, requiredRole, user.Role.Level)
-			if err != nil {
-				app.InternaServerError(w, r, err)
-				return
-			}
-			if !allow {
+			if !app.canAccessPost(user, post, permission) {
 				app.ForbiddenRequest(w, r, fmt.Errorf("user not allowed to perform this action"))
 				return
 			}
@@ -136,6 +262,43 @@ func (app *application) UserPostAuthorizationMiddleware(requiredRole string) fun
 
 }
 
+// canAccessPost reports whether user may act on post, the owner always can
+// and anyone else needs permission
+func (app *application) canAccessPost(user *store.User, post *store.Post, permission string) bool {
+	return user.ID == post.UserID || app.permissions.Can(user.Role.ID, permission)
+}
+
+// permissionRefreshLoop keeps the permission snapshot in sync with changes
+// made on other replicas until ctx is done
+func (app *application) permissionRefreshLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.refreshPermissions(ctx)
+		}
+	}
+}
+
+func (app *application) RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := getUserCtx(r)
+
+			if !app.permissions.Can(user.Role.ID, permission) {
+				app.ForbiddenRequest(w, r, fmt.Errorf("user lacks permission %q", permission))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func getUserCtx(r *http.Request) *store.User {
 	user, ok := r.Context().Value(userCtx).(*store.User)
 
@@ -145,65 +308,49 @@ func getUserCtx(r *http.Request) *store.User {
 	return user
 }
 
-func (app *application) getUserFromCache(ctx context.Context, userID int64) (*store.User, error) {
-
-	if !app.config.cacheConfig.enabled {
-		return app.store.Users.GetUserbyID(ctx, int64(userID))
+// RateLimitingMiddleware applies the given rate limit policy. Requests are
+// keyed by user ID when UserAuthMiddleware ran before it and by IP otherwise.
+func (app *application) RateLimitingMiddleware(policy string) func(http.Handler) http.Handler {
+	limiter, ok := app.limiters[policy]
+	if !ok {
+		panic(fmt.Sprintf("unknown rate limit policy %q", policy))
 	}
 
-	// Get from cache
-	// Try cache first
-	user, found, err := app.cache.Users.Get(ctx, userID)
-	if err != nil {
-		// Log cache error but don't fail the request
-		app.logger.Errorw("cache get failed",
-			"user_id", userID,
-			"error", err,
-		)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res := limiter.Allow(policy + ":" + rateLimitKey(r))
 
-	} else if found {
-		// Cache hit - return immediately
-		app.logger.Info("Getting User from Cache")
-		return user, nil
-	}
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(max(res.Remaining, 0)))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
 
-	app.logger.Info("Getting User from DB")
-	// Cache miss or error - fetch from DB
-	user, err = app.store.Users.GetUserbyID(ctx, userID)
-	if err != nil {
-		return nil, err // DB error is critical
-	}
+			if !res.Allowed {
+				app.rateLimitExceededResponse(w, r, strconv.Itoa(ceilSeconds(res.Reset)))
+				return
+			}
 
-	// Try to populate cache (fire and forget)
-	if err := app.cache.Users.Set(ctx, user); err != nil {
-		// Log but don't fail - user already got their data
-		app.logger.Errorw("cache set failed",
-			"user_id", userID,
-			"error", err,
-		)
+			next.ServeHTTP(w, r)
+		})
 	}
+}
 
-	//if found user in cache
-	return user, nil
-
+func rateLimitKey(r *http.Request) string {
+	if user, ok := r.Context().Value(userCtx).(*store.User); ok {
+		return "user:" + strconv.FormatInt(user.ID, 10)
+	}
+	return "ip:" + clientIP(r)
 }
 
-func (app *application) RateLimitingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+// clientIP returns RemoteAddr without the port, middleware.RealIP has already
+// replaced it with the forwarded address when there is one
+func clientIP(r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	return ip
+}
 
-		// Extract IP without port
-		ip := r.RemoteAddr
-		if idx := strings.LastIndex(ip, ":"); idx != -1 {
-			ip = ip[:idx]
-		}
-		//a function that checks a requests ip's limit
-		allow, waiting := app.limiter.Allow(ip)
-		log.Println("from IP", ip)
-		if !allow {
-			app.rateLimitExceededResponse(w, r, waiting.String())
-			return
-		} else {
-			next.ServeHTTP(w, r)
-		}
-	})
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
 }
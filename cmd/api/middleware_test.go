@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"tiago-udemy/internal/auth"
+	"tiago-udemy/internal/ratelimiter"
+	"tiago-udemy/internal/store"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestBasicAuthMiddleware(t *testing.T) {
+	app := newTestApp()
+	app.config.authConfig.basicAuth = basicAuth{username: "operator", password: "secret"}
+
+	basic := func(creds string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(creds))
+	}
+
+	tests := []struct {
+		name       string
+		header     string
+		wantStatus int
+		wantNext   bool
+	}{
+		{name: "missing header", header: "", wantStatus: http.StatusUnauthorized},
+		{name: "wrong scheme", header: "Bearer abc", wantStatus: http.StatusUnauthorized},
+		{name: "credentials without colon", header: basic("operator"), wantStatus: http.StatusUnauthorized},
+		{name: "wrong password", header: basic("operator:nope"), wantStatus: http.StatusUnauthorized},
+		{name: "valid credentials", header: basic("operator:secret"), wantStatus: http.StatusOK, wantNext: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nextCalled := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextCalled = true
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/debug/vars", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+
+			rr := httptest.NewRecorder()
+			app.BasicAuthMiddleware()(next).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("want %d, got %d; body=%s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if nextCalled != tt.wantNext {
+				t.Errorf("next called: got %v, want %v", nextCalled, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestRateLimitingMiddleware(t *testing.T) {
+	app := newTestApp()
+	app.limiters = map[string]ratelimiter.Limiter{
+		rateLimitLogin: ratelimiter.NewFixedWindowLimiter(2, time.Minute),
+	}
+
+	handler := app.RateLimitingMiddleware(rateLimitLogin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	send := func(remoteAddr string, user *store.User) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/authentication/login", nil)
+		req.RemoteAddr = remoteAddr
+		if user != nil {
+			req = req.WithContext(context.WithValue(req.Context(), userCtx, user))
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i, wantRemaining := range []string{"1", "0"} {
+		rr := send("10.0.0.1:1234", nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("request %d: want 200, got %d", i+1, rr.Code)
+		}
+		if got := rr.Header().Get("RateLimit-Remaining"); got != wantRemaining {
+			t.Errorf("request %d: RateLimit-Remaining = %q, want %q", i+1, got, wantRemaining)
+		}
+		if got := rr.Header().Get("RateLimit-Limit"); got != "2" {
+			t.Errorf("request %d: RateLimit-Limit = %q, want 2", i+1, got)
+		}
+	}
+
+	rr := send("10.0.0.1:5678", nil)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("want 429 once the limit is used up, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" || rr.Header().Get("RateLimit-Reset") == "" {
+		t.Errorf("missing Retry-After or RateLimit-Reset header: %v", rr.Header())
+	}
+
+	// authenticated users behind the same IP get their own limit
+	if rr := send("10.0.0.1:1234", &store.User{ID: 7}); rr.Code != http.StatusOK {
+		t.Errorf("authenticated request: want 200, got %d", rr.Code)
+	}
+}
+
+func TestAuthenticationFailuresAreRateLimited(t *testing.T) {
+	app := newTestApp()
+	app.authenticator = auth.NewJWTAuthenticator("test-secret", "test", "test")
+	app.limiters = map[string]ratelimiter.Limiter{
+		rateLimitDefault:     ratelimiter.NewFixedWindowLimiter(2, time.Minute),
+		rateLimitAuthFailure: ratelimiter.NewFixedWindowLimiter(2, time.Minute),
+	}
+	app.store.Users = &store.MockUserStore{GetUserbyIDFunc: func(ctx context.Context, id int64) (*store.User, error) {
+		return &store.User{ID: id, IsActivated: true}, nil
+	}}
+
+	// the same stack as the authenticated routes in mount
+	handler := app.UserAuthMiddleware(
+		app.RateLimitingMiddleware(rateLimitDefault)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})))
+
+	do := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/v1/posts/1", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	var codes []int
+	for range 3 {
+		codes = append(codes, do("guessed-token"))
+	}
+	want := []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}
+	for i := range want {
+		if codes[i] != want[i] {
+			t.Fatalf("want %v for repeated bad tokens, got %v", want, codes)
+		}
+	}
+
+	// users sharing the address are limited by their own bucket only
+	for _, userID := range []int64{1, 2} {
+		session := &store.Session{ID: fmt.Sprintf("sid-%d", userID), UserID: userID, ExpiresAt: time.Now().Add(time.Hour)}
+		if err := app.store.Sessions.Create(context.Background(), session); err != nil {
+			t.Fatal(err)
+		}
+		token, err := app.authenticator.GenerateToken(jwt.MapClaims{
+			"sub": userID, "sid": session.ID, "exp": time.Now().Add(time.Hour).Unix(), "iss": "test", "aud": "test",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range 2 {
+			if code := do(token); code != http.StatusOK {
+				t.Fatalf("user %d request %d: want 200 behind a shared address, got %d", userID, i+1, code)
+			}
+		}
+	}
+}
+
+// disablingUsers keeps the disabled flag in memory, activation is left alone
+type disablingUsers struct {
+	store.MockUserStore
+	disabled map[int64]bool
+}
+
+func (f *disablingUsers) GetUserbyID(ctx context.Context, id int64) (*store.User, error) {
+	return &store.User{ID: id, IsActivated: true, IsDisabled: f.disabled[id]}, nil
+}
+
+func (f *disablingUsers) SetDisabled(ctx context.Context, userID int64, disabled bool) error {
+	f.disabled[userID] = disabled
+	return nil
+}
+
+func TestDisabledAccounts(t *testing.T) {
+	users := &disablingUsers{disabled: map[int64]bool{}}
+	app := newTestApp()
+	app.config.authConfig.jwtAuth = jwtAuth{secret: "test-secret", iss: "test", exp: time.Hour}
+	app.authenticator = auth.NewJWTAuthenticator("test-secret", "test", "test")
+	app.store.Users = users
+	app.store.TwoFactor = &fakeTwoFactor{}
+
+	token, err := app.newSessionToken(httptest.NewRequest(http.MethodPost, "/v1/authentication/login", nil), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticate := func() *httptest.ResponseRecorder {
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/v1/users/feed", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		app.UserAuthMiddleware(next).ServeHTTP(rr, req)
+		return rr
+	}
+
+	setDisabled := func(disabled bool) {
+		req := withTestUser(httptest.NewRequest(http.MethodPut, "/v1/admin/users/2/deactivate", nil), 1, testUserRole)
+		req = req.WithContext(context.WithValue(req.Context(), targetUserCtx, &store.User{ID: 2}))
+		rr := httptest.NewRecorder()
+		app.setUserDisabled(rr, req, disabled)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("want 204, got %d; body=%s", rr.Code, rr.Body.String())
+		}
+	}
+
+	login := func() int {
+		user, _ := users.GetUserbyID(context.Background(), 2)
+		rr := httptest.NewRecorder()
+		app.loginResponse(rr, httptest.NewRequest(http.MethodPost, "/v1/authentication/token", nil), user)
+		return rr.Code
+	}
+
+	setDisabled(true)
+
+	t.Run("disabled account cannot use its token", func(t *testing.T) {
+		rr := authenticate()
+		if rr.Code != http.StatusForbidden {
+			t.Fatalf("want 403, got %d", rr.Code)
+		}
+		var p Problem
+		if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Code != codeAccountDisabled {
+			t.Errorf("want code %q, got %q", codeAccountDisabled, p.Code)
+		}
+	})
+
+	t.Run("disabled account cannot log in", func(t *testing.T) {
+		if code := login(); code != http.StatusForbidden {
+			t.Errorf("want 403, got %d", code)
+		}
+	})
+
+	setDisabled(false)
+
+	t.Run("re-enabled account is back", func(t *testing.T) {
+		if rr := authenticate(); rr.Code != http.StatusOK {
+			t.Errorf("want 200 with the token, got %d", rr.Code)
+		}
+		if code := login(); code != http.StatusCreated {
+			t.Errorf("want 201 from login, got %d", code)
+		}
+	})
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"tiago-udemy/internal/auth"
+	"tiago-udemy/internal/mailer"
+	"tiago-udemy/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	errSuspendSelf  = errors.New("moderators cannot suspend themselves")
+	errSuspendStaff = errors.New("suspending staff requires the users:manage permission")
+)
+
+type CreateReportPayload struct {
+	TargetType string `json:"target_type" validate:"required,oneof=post comment user"`
+	TargetID   int64  `json:"target_id" validate:"required,gte=1"`
+	Reason     string `json:"reason" validate:"required,max=500"`
+}
+
+// CreateReport godoc
+//
+//	@Summary		Reports content
+//	@Description	Reports a post, comment or user to the moderators
+//	@Tags			moderation
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreateReportPayload	true	"Report payload"
+//	@Success		201		{object}	store.Report
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		404		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/reports [post]
+func (app *application) createReportHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateReportPayload
+
+	if err := readJSON(w, r, &payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	user := getUserCtx(r)
+
+	report := &store.Report{
+		ReporterID: user.ID,
+		TargetType: payload.TargetType,
+		TargetID:   payload.TargetID,
+		Reason:     payload.Reason,
+	}
+
+	ctx := r.Context()
+	if err := app.store.Moderation.CreateReport(ctx, report); err != nil {
+		switch {
+		case errors.Is(err, store.ErrRecordNotFound):
+			app.RecordNotFound(w, r, err)
+			return
+		case errors.Is(err, store.ErrInvalidReportTarget):
+			app.StatusBadRequest(w, r, err)
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, report); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+// GetReportQueue godoc
+//
+//	@Summary		Lists reports for review
+//	@Description	Lists reports by status, oldest first by default
+//	@Tags			moderation
+//	@Produce		json
+//	@Param			status	query		string	false	"Report status (open, dismissed, resolved)"
+//	@Param			limit	query		int		false	"Page size"
+//	@Param			offset	query		int		false	"Page offset"
+//	@Param			sort	query		string	false	"Sort by creation date (asc, desc)"
+//	@Success		200		{array}		store.Report
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		403		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/moderation/reports [get]
+func (app *application) getReportQueueHandler(w http.ResponseWriter, r *http.Request) {
+
+	rq := store.PaginatedReportQuery{
+		Limit:  20,
+		Offset: 0,
+		Sort:   "asc",
+		Status: store.ReportStatusOpen,
+	}
+	rq, err := rq.Parse(w, r)
+	if err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(rq); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	reports, err := app.store.Moderation.GetReports(ctx, rq)
+	if err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, reports); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+type ModerateReportPayload struct {
+	Action string `json:"action" validate:"required,oneof=dismiss hide warn suspend"`
+	Note   string `json:"note" validate:"max=500"`
+}
+
+// ModerateReport godoc
+//
+//	@Summary		Acts on a report
+//	@Description	Dismisses a report, hides the reported content, or warns or suspends its author. Warned users are notified by email. Moderators cannot suspend themselves, and suspending staff requires users:manage
+//	@Tags			moderation
+//	@Accept			json
+//	@Produce		json
+//	@Param			reportID	path		int						true	"Report ID"
+//	@Param			payload		body		ModerateReportPayload	true	"Moderation payload"
+//	@Success		200			{object}	store.ModerationAction
+//	@Failure		400			{object}	Problem
+//	@Failure		401			{object}	Problem
+//	@Failure		403			{object}	Problem
+//	@Failure		404			{object}	Problem
+//	@Failure		500			{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/moderation/reports/{reportID}/actions [post]
+func (app *application) moderateReportHandler(w http.ResponseWriter, r *http.Request) {
+
+	reportID, err := strconv.ParseInt(chi.URLParam(r, "reportID"), 10, 64)
+	if err != nil || reportID <= 0 {
+		app.StatusBadRequest(w, r, clientErrorf("invalid reportID"))
+		return
+	}
+
+	var payload ModerateReportPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	moderator := getUserCtx(r)
+	ctx := r.Context()
+
+	if payload.Action == store.ModerationActionSuspend {
+		if err := app.checkSuspendTarget(ctx, moderator, reportID); err != nil {
+			switch {
+			case errors.Is(err, store.ErrRecordNotFound):
+				app.RecordNotFound(w, r, err)
+				return
+			case errors.Is(err, errSuspendSelf), errors.Is(err, errSuspendStaff):
+				app.ForbiddenRequest(w, r, err)
+				return
+			default:
+				app.InternaServerError(w, r, err)
+				return
+			}
+		}
+	}
+
+	action := &store.ModerationAction{
+		ReportID:    reportID,
+		ModeratorID: moderator.ID,
+		Action:      payload.Action,
+		Note:        payload.Note,
+	}
+
+	if err := app.store.Moderation.Resolve(ctx, action); err != nil {
+		switch {
+		case errors.Is(err, store.ErrRecordNotFound):
+			app.RecordNotFound(w, r, err)
+			return
+		case errors.Is(err, store.ErrInvalidModerationAction):
+			app.StatusBadRequest(w, r, err)
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	if action.Action == store.ModerationActionWarn {
+		app.sendWarningEmail(ctx, action)
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, action); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+// checkSuspendTarget stops moderators from suspending themselves, and from
+// suspending other staff unless they can manage users
+func (app *application) checkSuspendTarget(ctx context.Context, moderator *store.User, reportID int64) error {
+	targetID, err := app.store.Moderation.GetTargetUserID(ctx, reportID)
+	if err != nil {
+		return err
+	}
+
+	if targetID == moderator.ID {
+		return errSuspendSelf
+	}
+
+	target, err := app.store.Users.GetUserbyID(ctx, targetID)
+	if err != nil {
+		return err
+	}
+
+	isStaff := app.permissions.Can(target.Role.ID, auth.PermissionReportsReview) ||
+		app.permissions.Can(target.Role.ID, auth.PermissionUsersManage)
+	if isStaff && !app.permissions.Can(moderator.Role.ID, auth.PermissionUsersManage) {
+		return errSuspendStaff
+	}
+
+	return nil
+}
+
+// sendWarningEmail tells the warned user about the warning. The action is
+// already recorded, so a failure is only logged.
+func (app *application) sendWarningEmail(ctx context.Context, action *store.ModerationAction) {
+	user, err := app.store.Users.GetUserbyID(ctx, *action.TargetUserID)
+	if err != nil {
+		app.logger.Errorw("warning email failed", "user_id", *action.TargetUserID, "error", err)
+		return
+	}
+
+	data := struct {
+		Username string
+		Note     string
+	}{
+		Username: user.Username,
+		Note:     action.Note,
+	}
+
+	status, err := app.mailer.Send(ctx, mailer.AccountWarnedTemplate, user.Email, data)
+	if err != nil {
+		app.logger.Errorw("warning email failed", "user_id", user.ID, "error", err)
+		return
+	}
+
+	app.logger.Infow("Email sent", "status code", status)
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"tiago-udemy/internal/auth"
+	"tiago-udemy/internal/store"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	testUserRole      int64 = 1
+	testModeratorRole int64 = 2
+)
+
+// testPermissions grants the moderator role everything a moderator needs
+func testPermissions(t *testing.T, extra ...string) *auth.PermissionCache {
+	t.Helper()
+	permissions := auth.NewPermissionCache(func(ctx context.Context) (map[int64][]string, error) {
+		return map[int64][]string{
+			testModeratorRole: append([]string{auth.PermissionReportsReview}, extra...),
+		}, nil
+	})
+	if err := permissions.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return permissions
+}
+
+// withTestUser stands in for UserAuthMiddleware
+func withTestUser(r *http.Request, userID, roleID int64) *http.Request {
+	user := &store.User{ID: userID, Role: store.Role{ID: roleID}}
+	return r.WithContext(context.WithValue(r.Context(), userCtx, user))
+}
+
+// fakeModeration keeps reports in memory. Posts 1 to 9 exist and are written
+// by user 6, users can be warned but their content cannot be hidden, as in
+// ModerationStore.
+type fakeModeration struct {
+	store.ModerationRepository
+	reports []store.Report
+	actions []store.ModerationAction
+}
+
+func (f *fakeModeration) CreateReport(ctx context.Context, report *store.Report) error {
+	if report.TargetType == store.ReportTargetPost && report.TargetID > 9 {
+		return store.ErrRecordNotFound
+	}
+	report.ID = int64(len(f.reports) + 1)
+	report.Status = store.ReportStatusOpen
+	f.reports = append(f.reports, *report)
+	return nil
+}
+
+func (f *fakeModeration) GetReports(ctx context.Context, rq store.PaginatedReportQuery) ([]store.Report, error) {
+	var reports []store.Report
+	for _, report := range f.reports {
+		if report.Status == rq.Status {
+			reports = append(reports, report)
+		}
+	}
+	return reports, nil
+}
+
+func (f *fakeModeration) GetTargetUserID(ctx context.Context, reportID int64) (int64, error) {
+	if reportID < 1 || reportID > int64(len(f.reports)) {
+		return 0, store.ErrRecordNotFound
+	}
+	report := f.reports[reportID-1]
+	if report.TargetType == store.ReportTargetUser {
+		return report.TargetID, nil
+	}
+	return 6, nil
+}
+
+func (f *fakeModeration) Resolve(ctx context.Context, action *store.ModerationAction) error {
+	if action.ReportID < 1 || action.ReportID > int64(len(f.reports)) {
+		return store.ErrRecordNotFound
+	}
+	report := &f.reports[action.ReportID-1]
+	if action.Action == store.ModerationActionHide && report.TargetType == store.ReportTargetUser {
+		return store.ErrInvalidModerationAction
+	}
+	if action.Action == store.ModerationActionWarn || action.Action == store.ModerationActionSuspend {
+		userID, _ := f.GetTargetUserID(ctx, action.ReportID)
+		action.TargetUserID = &userID
+	}
+	report.Status = store.ReportStatusResolved
+	f.actions = append(f.actions, *action)
+	return nil
+}
+
+func TestModerationHandlers(t *testing.T) {
+	moderation := &fakeModeration{}
+	app := newTestApp()
+	app.store.Moderation = moderation
+	app.permissions = testPermissions(t)
+
+	router := chi.NewRouter()
+	router.Post("/v1/reports", app.createReportHandler)
+	router.Route("/v1/moderation", func(r chi.Router) {
+		r.Use(app.RequirePermission(auth.PermissionReportsReview))
+		r.Get("/reports", app.getReportQueueHandler)
+		r.Post("/reports/{reportID}/actions", app.moderateReportHandler)
+	})
+
+	do := func(method, path, body string, userID, roleID int64) *httptest.ResponseRecorder {
+		req := withTestUser(httptest.NewRequest(method, path, strings.NewReader(body)), userID, roleID)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("reporter is the caller", func(t *testing.T) {
+		rr := do(http.MethodPost, "/v1/reports", `{"target_type":"post","target_id":3,"reason":"spam"}`, 5, testUserRole)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("want 201, got %d; body=%s", rr.Code, rr.Body.String())
+		}
+		if got := moderation.reports[0].ReporterID; got != 5 {
+			t.Errorf("want reporter 5, got %d", got)
+		}
+	})
+
+	t.Run("invalid targets", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			body       string
+			wantStatus int
+		}{
+			{"unknown target type", `{"target_type":"group","target_id":1,"reason":"spam"}`, http.StatusBadRequest},
+			{"missing target id", `{"target_type":"post","reason":"spam"}`, http.StatusBadRequest},
+			{"missing reason", `{"target_type":"post","target_id":1}`, http.StatusBadRequest},
+			{"target does not exist", `{"target_type":"post","target_id":42,"reason":"spam"}`, http.StatusNotFound},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if rr := do(http.MethodPost, "/v1/reports", tt.body, 5, testUserRole); rr.Code != tt.wantStatus {
+					t.Errorf("want %d, got %d; body=%s", tt.wantStatus, rr.Code, rr.Body.String())
+				}
+			})
+		}
+		if len(moderation.reports) != 1 {
+			t.Errorf("want no report stored for invalid targets, got %d reports", len(moderation.reports))
+		}
+	})
+
+	t.Run("queue and actions need the review permission", func(t *testing.T) {
+		if rr := do(http.MethodGet, "/v1/moderation/reports", "", 5, testUserRole); rr.Code != http.StatusForbidden {
+			t.Errorf("want 403 listing the queue, got %d", rr.Code)
+		}
+		if rr := do(http.MethodPost, "/v1/moderation/reports/1/actions", `{"action":"dismiss"}`, 5, testUserRole); rr.Code != http.StatusForbidden {
+			t.Errorf("want 403 acting on a report, got %d", rr.Code)
+		}
+		if len(moderation.actions) != 0 {
+			t.Errorf("want no action recorded, got %v", moderation.actions)
+		}
+	})
+
+	t.Run("moderator lists the open queue", func(t *testing.T) {
+		rr := do(http.MethodGet, "/v1/moderation/reports", "", 8, testModeratorRole)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("want 200, got %d; body=%s", rr.Code, rr.Body.String())
+		}
+		var body struct {
+			Data []store.Report `json:"data"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if len(body.Data) != 1 || body.Data[0].ID != 1 {
+			t.Errorf("want report 1 in the queue, got %+v", body.Data)
+		}
+	})
+
+	t.Run("moderator actions", func(t *testing.T) {
+		moderation.reports = append(moderation.reports, store.Report{ID: 2, TargetType: store.ReportTargetUser, TargetID: 7, Status: store.ReportStatusOpen})
+
+		tests := []struct {
+			name       string
+			path       string
+			body       string
+			wantStatus int
+		}{
+			{"unknown action", "/v1/moderation/reports/1/actions", `{"action":"ban"}`, http.StatusBadRequest},
+			{"invalid report id", "/v1/moderation/reports/abc/actions", `{"action":"dismiss"}`, http.StatusBadRequest},
+			{"unknown report", "/v1/moderation/reports/9/actions", `{"action":"dismiss"}`, http.StatusNotFound},
+			{"action not allowed for target", "/v1/moderation/reports/2/actions", `{"action":"hide"}`, http.StatusBadRequest},
+			{"hide a post", "/v1/moderation/reports/1/actions", `{"action":"hide","note":"spam"}`, http.StatusOK},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if rr := do(http.MethodPost, tt.path, tt.body, 8, testModeratorRole); rr.Code != tt.wantStatus {
+					t.Errorf("want %d, got %d; body=%s", tt.wantStatus, rr.Code, rr.Body.String())
+				}
+			})
+		}
+
+		if len(moderation.actions) != 1 || moderation.actions[0].ModeratorID != 8 {
+			t.Errorf("want one action by moderator 8, got %+v", moderation.actions)
+		}
+	})
+}
+
+func TestModerationTargets(t *testing.T) {
+	const adminRole = int64(3)
+
+	permissions := auth.NewPermissionCache(func(ctx context.Context) (map[int64][]string, error) {
+		return map[int64][]string{
+			testModeratorRole: {auth.PermissionReportsReview},
+			adminRole:         {auth.PermissionReportsReview, auth.PermissionUsersManage},
+		}, nil
+	})
+	if err := permissions.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// users 8 and 9 are moderators, everyone else is a regular user
+	roles := map[int64]int64{8: testModeratorRole, 9: testModeratorRole}
+
+	mail := &recordingMailer{}
+	app := newTestApp()
+	app.permissions = permissions
+	app.mailer = mail
+	app.store.Users = &store.MockUserStore{
+		GetUserbyIDFunc: func(ctx context.Context, userID int64) (*store.User, error) {
+			roleID, ok := roles[userID]
+			if !ok {
+				roleID = testUserRole
+			}
+			return &store.User{ID: userID, Username: fmt.Sprintf("user%d", userID), Role: store.Role{ID: roleID}}, nil
+		},
+	}
+
+	router := chi.NewRouter()
+	router.Post("/v1/moderation/reports/{reportID}/actions", app.moderateReportHandler)
+
+	act := func(reportID int64, body string, userID, roleID int64) *httptest.ResponseRecorder {
+		path := fmt.Sprintf("/v1/moderation/reports/%d/actions", reportID)
+		req := withTestUser(httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)), userID, roleID)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("suspend", func(t *testing.T) {
+		moderation := &fakeModeration{reports: []store.Report{
+			{ID: 1, TargetType: store.ReportTargetUser, TargetID: 8, Status: store.ReportStatusOpen},
+			{ID: 2, TargetType: store.ReportTargetUser, TargetID: 9, Status: store.ReportStatusOpen},
+			{ID: 3, TargetType: store.ReportTargetPost, TargetID: 1, Status: store.ReportStatusOpen},
+		}}
+		app.store.Moderation = moderation
+
+		tests := []struct {
+			name       string
+			reportID   int64
+			userID     int64
+			roleID     int64
+			wantStatus int
+		}{
+			{"moderator cannot suspend themselves", 1, 8, testModeratorRole, http.StatusForbidden},
+			{"moderator cannot suspend another moderator", 2, 8, testModeratorRole, http.StatusForbidden},
+			{"admin can suspend a moderator", 2, 10, adminRole, http.StatusOK},
+			{"moderator suspends a regular user", 3, 8, testModeratorRole, http.StatusOK},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				rr := act(tt.reportID, `{"action":"suspend"}`, tt.userID, tt.roleID)
+				if rr.Code != tt.wantStatus {
+					t.Errorf("want %d, got %d; body=%s", tt.wantStatus, rr.Code, rr.Body.String())
+				}
+				if tt.wantStatus == http.StatusForbidden {
+					var p Problem
+					if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+						t.Fatal(err)
+					}
+					if p.Code != codeSuspendNotAllowed {
+						t.Errorf("want code %q, got %q", codeSuspendNotAllowed, p.Code)
+					}
+				}
+			})
+		}
+
+		if len(moderation.actions) != 2 {
+			t.Errorf("want only the allowed suspensions recorded, got %+v", moderation.actions)
+		}
+	})
+
+	t.Run("warn emails the author", func(t *testing.T) {
+		app.store.Moderation = &fakeModeration{reports: []store.Report{
+			{ID: 1, TargetType: store.ReportTargetPost, TargetID: 1, Status: store.ReportStatusOpen},
+		}}
+
+		if rr := act(1, `{"action":"warn","note":"no spam please"}`, 8, testModeratorRole); rr.Code != http.StatusOK {
+			t.Fatalf("want 200, got %d; body=%s", rr.Code, rr.Body.String())
+		}
+
+		data, ok := mail.data.(struct {
+			Username string
+			Note     string
+		})
+		if !ok || data.Username != "user6" || data.Note != "no spam please" {
+			t.Errorf("want a warning email to user6, got %+v", mail.data)
+		}
+	})
+}
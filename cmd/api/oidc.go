@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"tiago-udemy/internal/auth"
+	"tiago-udemy/internal/store"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// oidcStateCookie ties the callback to the browser that started the login
+const oidcStateCookie = "oidc_state"
+
+var usernameUnsafe = regexp.MustCompile(`[^a-z0-9_]`)
+
+type oidcConfig struct {
+	providers []auth.OIDCConfig
+	// redirectBaseURL is the public URL of this API the providers redirect back to
+	redirectBaseURL string
+	stateExp        time.Duration
+}
+
+// OIDCLogin godoc
+//
+//	@Summary		Starts a login with an OIDC provider
+//	@Description	Redirects to the provider's login page using the authorization code flow with PKCE
+//	@Tags			authentication
+//	@Param			provider	path	string	true	"Provider name"
+//	@Success		302
+//	@Failure		404	{object}	Problem
+//	@Failure		500	{object}	Problem
+//	@Router			/authentication/oidc/{provider}/login [get]
+func (app *application) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oidcProviders[chi.URLParam(r, "provider")]
+	if !ok {
+		app.RecordNotFound(w, r, clientErrorf("unknown login provider"))
+		return
+	}
+
+	state := &store.OIDCLoginState{
+		State:        auth.GenerateOIDCVerifier(),
+		Provider:     provider.Name,
+		Nonce:        auth.GenerateOIDCVerifier(),
+		CodeVerifier: auth.GenerateOIDCVerifier(),
+	}
+	plainState := state.State
+	state.State = sha256Hex(plainState)
+
+	exp := app.config.oidcConfig.stateExp
+	if err := app.store.ExternalIdentities.CreateLoginState(r.Context(), state, exp); err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    plainState,
+		Path:     "/v1/authentication/oidc",
+		MaxAge:   int(exp.Seconds()),
+		HttpOnly: true,
+		Secure:   app.config.env == "production",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, provider.AuthCodeURL(plainState, state.Nonce, state.CodeVerifier), http.StatusFound)
+}
+
+// OIDCCallback godoc
+//
+//	@Summary		Completes a login with an OIDC provider
+//	@Description	Exchanges the authorization code, then logs in the linked user. A user with the same verified email is linked, otherwise a new user is created
+//	@Tags			authentication
+//	@Produce		json
+//	@Param			provider	path		string	true	"Provider name"
+//	@Param			code		query		string	true	"Authorization code"
+//	@Param			state		query		string	true	"Login state"
+//	@Success		201			{string}	string	"Token"
+//	@Success		202			{object}	TwoFactorChallenge
+//	@Failure		400			{object}	Problem
+//	@Failure		401			{object}	Problem
+//	@Failure		404			{object}	Problem
+//	@Failure		409			{object}	Problem
+//	@Failure		500			{object}	Problem
+//	@Router			/authentication/oidc/{provider}/callback [get]
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.oidcProviders[chi.URLParam(r, "provider")]
+	if !ok {
+		app.RecordNotFound(w, r, clientErrorf("unknown login provider"))
+		return
+	}
+
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		app.InvalidUserAuthorization(w, r, fmt.Errorf("provider returned %s", providerErr))
+		return
+	}
+
+	plainState := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || plainState == "" || cookie.Value != plainState {
+		app.StatusBadRequest(w, r, clientErrorf("login state does not match"))
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/v1/authentication/oidc", MaxAge: -1})
+
+	ctx := r.Context()
+	state, err := app.store.ExternalIdentities.ConsumeLoginState(ctx, sha256Hex(plainState), provider.Name)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrInvalidToken):
+			app.StatusBadRequest(w, r, clientErrorf("login state is invalid or expired"))
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	identity, err := provider.Exchange(ctx, query.Get("code"), state.Nonce, state.CodeVerifier)
+	if err != nil {
+		app.InvalidUserAuthorization(w, r, err)
+		return
+	}
+
+	user, err := app.userForExternalIdentity(ctx, identity)
+	if err != nil {
+		switch {
+		case errors.Is(err, errEmailNotVerified):
+			app.InvalidUserAuthorization(w, r, err)
+			return
+		case errors.Is(err, store.ErrDuplicateEmail):
+			// an account that was never activated holds the email, linking it
+			// would hand over whatever password it was registered with
+			app.ConflictResponse(w, r, clientErrorf("activate the account registered with this email first"))
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	app.logger.Infow("oidc login", "provider", provider.Name, "user_id", user.ID)
+
+	app.loginResponse(w, r, user)
+}
+
+var errEmailNotVerified = errors.New("the provider has not verified the email address")
+
+// userForExternalIdentity returns the user linked to identity. On the first
+// login with a provider the identity is linked to the active user with the
+// same email, or a new user is created.
+func (app *application) userForExternalIdentity(ctx context.Context, identity *auth.ExternalIdentity) (*store.User, error) {
+	userID, err := app.store.ExternalIdentities.GetUserID(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		return app.store.Users.GetUserbyID(ctx, userID)
+	}
+	if !errors.Is(err, store.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if !identity.EmailVerified || identity.Email == "" {
+		return nil, errEmailNotVerified
+	}
+
+	link := &store.ExternalIdentity{
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	}
+
+	user, err := app.store.Users.GetUserByEmail(ctx, identity.Email)
+	switch {
+	case err == nil:
+		link.UserID = user.ID
+		if err := app.store.ExternalIdentities.Link(ctx, link); err != nil {
+			return nil, err
+		}
+		return user, nil
+	case !errors.Is(err, store.ErrRecordNotFound):
+		return nil, err
+	}
+
+	// usernames are unique, retry a few times with a fresh suffix
+	for range 3 {
+		user = &store.User{
+			Username: usernameFromEmail(identity.Email),
+			Email:    identity.Email,
+			Role:     store.Role{Name: "user"},
+		}
+
+		err = app.store.ExternalIdentities.CreateUser(ctx, user, link)
+		if !errors.Is(err, store.ErrDuplicateUsername) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// usernameFromEmail derives a username from the local part of an email with
+// a random suffix, e.g. jane_doe_3fa91c
+func usernameFromEmail(email string) string {
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+	local = usernameUnsafe.ReplaceAllString(local, "_")
+	if len(local) > 12 {
+		local = local[:12]
+	}
+
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+
+	return local + "_" + hex.EncodeToString(suffix)
+}
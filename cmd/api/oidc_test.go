@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"tiago-udemy/internal/auth"
+	"tiago-udemy/internal/auth/oidctest"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestOIDCCallbackHandler_StateMismatch(t *testing.T) {
+	stub := oidctest.NewProvider(t, "gopher-client")
+	provider, err := auth.NewOIDCProvider(context.Background(), auth.OIDCConfig{
+		Name:        "stub",
+		Issuer:      stub.URL,
+		ClientID:    "gopher-client",
+		RedirectURL: "http://localhost:8080/v1/authentication/oidc/stub/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := newTestApp()
+	app.oidcProviders = map[string]*auth.OIDCProvider{"stub": provider}
+
+	tests := []struct {
+		name       string
+		provider   string
+		cookie     string
+		wantStatus int
+	}{
+		{name: "unknown provider", provider: "nope", cookie: "abc", wantStatus: http.StatusNotFound},
+		{name: "missing state cookie", provider: "stub", wantStatus: http.StatusBadRequest},
+		{name: "state from another browser", provider: "stub", cookie: "other", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/v1/authentication/oidc/"+tt.provider+"/callback?code=c&state=abc", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: tt.cookie})
+			}
+
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("provider", tt.provider)
+			req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+
+			rr := httptest.NewRecorder()
+			app.oidcCallbackHandler(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("want %d, got %d; body=%s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestUsernameFromEmail(t *testing.T) {
+	tests := map[string]*regexp.Regexp{
+		"jane.doe@example.com":                   regexp.MustCompile(`^jane_doe_[0-9a-f]{6}$`),
+		"A.Very+Long-Address.Indeed@example.com": regexp.MustCompile(`^a_very_long__[0-9a-f]{6}$`),
+	}
+
+	for email, want := range tests {
+		if got := usernameFromEmail(email); !want.MatchString(got) {
+			t.Errorf("usernameFromEmail(%q) = %q, want match for %s", email, got, want)
+		}
+	}
+}
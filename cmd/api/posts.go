@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"tiago-udemy/internal/store"
 
 	"github.com/go-chi/chi/v5"
@@ -30,9 +31,9 @@ const postCtx postKey = "post"
 //	@Produce		json
 //	@Param			payload	body		CreatePayload	true	"Post payload"
 //	@Success		201		{object}	store.Post
-//	@Failure		400		{object}	error
-//	@Failure		401		{object}	error
-//	@Failure		500		{object}	error
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		500		{object}	Problem
 //	@Security		ApiKeyAuth
 //	@Router			/posts [post]
 func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request) {
@@ -80,10 +81,11 @@ func (app *application) createPostHandler(w http.ResponseWriter, r *http.Request
 //	@Produce		json
 //	@Param			postID	path		int	true	"Post ID"
 //	@Success		200		{object}	store.Post
-//	@Failure		400		{object}	error
-//	@Failure		401		{object}	error
-//	@Failure		404		{object}	error
-//	@Failure		500		{object}	error
+//	@Header			200		{string}	ETag	"Post version, send it back in If-Match to update or delete"
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		404		{object}	Problem
+//	@Failure		500		{object}	Problem
 //	@Security		ApiKeyAuth
 //	@Router			/posts/{postID} [get]
 func (app *application) getPostHandler(w http.ResponseWriter, r *http.Request) {
@@ -100,6 +102,13 @@ func (app *application) getPostHandler(w http.ResponseWriter, r *http.Request) {
 
 	post.Comments = comments
 
+	if err := app.loadAttachments(ctx, post); err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", postETag(post))
+
 	if err := app.jsonResponse(w, http.StatusOK, post); err != nil {
 		app.InternaServerError(w, r, err)
 		return
@@ -112,41 +121,27 @@ func (app *application) getPostHandler(w http.ResponseWriter, r *http.Request) {
 //	@Description	Deletes a post by ID
 //	@Tags			posts
 //	@Produce		json
-//	@Param			postID	path		int	true	"Post ID"
-//	@Success		200		{object}	store.Post
-//	@Failure		400		{object}	error
-//	@Failure		401		{object}	error
-//	@Failure		404		{object}	error
-//	@Failure		500		{object}	error
+//	@Param			postID		path		int		true	"Post ID"
+//	@Param			If-Match	header		string	true	"ETag of the post as returned by GET"
+//	@Success		200			{object}	store.Post
+//	@Failure		400			{object}	Problem
+//	@Failure		401			{object}	Problem
+//	@Failure		404			{object}	Problem
+//	@Failure		412			{object}	Problem
+//	@Failure		428			{object}	Problem
+//	@Failure		500			{object}	Problem
 //	@Security		ApiKeyAuth
 //	@Router			/posts/{postID} [delete]
 func (app *application) deletePostHandler(w http.ResponseWriter, r *http.Request) {
 
-	idStr := chi.URLParam(r, "postID")
-	if idStr == "" {
-		app.StatusBadRequest(w, r, fmt.Errorf("missing postID"))
-		return
-	}
-
-	// 2) Convert to int64
-	id, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil || id <= 0 {
-		app.StatusBadRequest(w, r, err)
-		return
-	}
+	post := getPostCtx(r)
 
 	ctx := r.Context()
-	if err := app.store.Comment.DeleteCommentByPostID(ctx, id); err != nil {
-		app.InternaServerError(w, r, err)
-		return
-	}
-
-	post, err := app.store.Posts.DeletePost(ctx, id)
-
+	deleted, err := app.store.Posts.DeletePost(ctx, post.ID, post.Version)
 	if err != nil {
 		switch {
-		case errors.Is(err, store.ErrRecordNotFound):
-			app.RecordNotFound(w, r, err)
+		case errors.Is(err, store.ErrEditConflict):
+			app.PreconditionFailed(w, r, err)
 			return
 		default:
 			app.InternaServerError(w, r, err)
@@ -154,7 +149,7 @@ func (app *application) deletePostHandler(w http.ResponseWriter, r *http.Request
 		}
 	}
 
-	if err := app.jsonResponse(w, http.StatusOK, post); err != nil {
+	if err := app.jsonResponse(w, http.StatusOK, deleted); err != nil {
 		app.InternaServerError(w, r, err)
 		return
 	}
@@ -172,13 +167,16 @@ type UpdatePayload struct {
 //	@Tags			posts
 //	@Accept			json
 //	@Produce		json
-//	@Param			postID	path		int				true	"Post ID"
-//	@Param			payload	body		UpdatePayload	true	"Post payload"
-//	@Success		200		{object}	store.Post
-//	@Failure		400		{object}	error
-//	@Failure		401		{object}	error
-//	@Failure		404		{object}	error
-//	@Failure		500		{object}	error
+//	@Param			postID		path		int				true	"Post ID"
+//	@Param			If-Match	header		string			true	"ETag of the post as returned by GET"
+//	@Param			payload		body		UpdatePayload	true	"Post payload"
+//	@Success		200			{object}	store.Post
+//	@Failure		400			{object}	Problem
+//	@Failure		401			{object}	Problem
+//	@Failure		404			{object}	Problem
+//	@Failure		412			{object}	Problem
+//	@Failure		428			{object}	Problem
+//	@Failure		500			{object}	Problem
 //	@Security		ApiKeyAuth
 //	@Router			/posts/{postID} [patch]
 func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request) {
@@ -197,7 +195,7 @@ func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	if payload.Title == nil && payload.Content == nil {
-		app.StatusBadRequest(w, r, fmt.Errorf("no fields to update"))
+		app.StatusBadRequest(w, r, clientErrorf("no fields to update"))
 		return
 	}
 
@@ -208,10 +206,14 @@ func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request
 		post.Content = *payload.Content
 	}
 
+	// post.Version is the version postContextMiddleWare read and
+	// postIfMatchMiddleware matched against If-Match. UpdatePost only writes
+	// while the row still has that version, so an update landing between the
+	// read and this write is reported as a conflict.
 	if err := app.store.Posts.UpdatePost(r.Context(), post); err != nil {
 		switch {
-		case errors.Is(err, store.ErrRecordNotFound):
-			app.RecordNotFound(w, r, fmt.Errorf("version mismatch"))
+		case errors.Is(err, store.ErrEditConflict):
+			app.PreconditionFailed(w, r, err)
 			return
 		default:
 			app.InternaServerError(w, r, err)
@@ -219,6 +221,8 @@ func (app *application) updatePostHandler(w http.ResponseWriter, r *http.Request
 		}
 	}
 
+	w.Header().Set("ETag", postETag(post))
+
 	if err := app.jsonResponse(w, http.StatusOK, post); err != nil {
 		app.InternaServerError(w, r, err)
 		return
@@ -231,7 +235,7 @@ func (app *application) postContextMiddleWare(next http.Handler) http.Handler {
 
 		idStr := chi.URLParam(r, "postID")
 		if idStr == "" {
-			app.StatusBadRequest(w, r, fmt.Errorf("missing postID"))
+			app.StatusBadRequest(w, r, clientErrorf("missing postID"))
 			return
 		}
 
@@ -263,6 +267,42 @@ func (app *application) postContextMiddleWare(next http.Handler) http.Handler {
 
 }
 
+// postIfMatchMiddleware rejects writes to a post unless the client proves it
+// has seen the current version by sending its ETag in If-Match
+func (app *application) postIfMatchMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		post := getPostCtx(r)
+
+		ifMatch := r.Header.Get("If-Match")
+		if ifMatch == "" {
+			app.PreconditionRequired(w, r, fmt.Errorf("missing If-Match for post %d", post.ID))
+			return
+		}
+
+		if !etagMatches(ifMatch, postETag(post)) {
+			app.PreconditionFailed(w, r, fmt.Errorf("If-Match %s does not match post %d version %d", ifMatch, post.ID, post.Version))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func postETag(post *store.Post) string {
+	return fmt.Sprintf(`"%d"`, post.Version)
+}
+
+// etagMatches applies the strong comparison of RFC 9110 to an If-Match header value
+func etagMatches(ifMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
 func getPostCtx(r *http.Request) *store.Post {
 	post, _ := r.Context().Value(postCtx).(*store.Post)
 	return post
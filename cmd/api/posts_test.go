@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"tiago-udemy/internal/store"
+)
+
+func TestPostIfMatchMiddleware(t *testing.T) {
+	app := newTestApp()
+
+	tests := []struct {
+		name       string
+		ifMatch    string
+		wantStatus int
+		wantNext   bool
+	}{
+		{name: "missing If-Match", ifMatch: "", wantStatus: http.StatusPreconditionRequired},
+		{name: "stale version", ifMatch: `"2"`, wantStatus: http.StatusPreconditionFailed},
+		{name: "weak etag does not match", ifMatch: `W/"3"`, wantStatus: http.StatusPreconditionFailed},
+		{name: "current version", ifMatch: `"3"`, wantStatus: http.StatusOK, wantNext: true},
+		{name: "current version in list", ifMatch: `"1", "3"`, wantStatus: http.StatusOK, wantNext: true},
+		{name: "wildcard", ifMatch: "*", wantStatus: http.StatusOK, wantNext: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nextCalled := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextCalled = true
+				w.WriteHeader(http.StatusOK)
+			})
+
+			post := &store.Post{ID: 7, Version: 3}
+			req := httptest.NewRequest(http.MethodPatch, "/v1/posts/7", nil)
+			req = req.WithContext(context.WithValue(req.Context(), postCtx, post))
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+
+			rr := httptest.NewRecorder()
+			app.postIfMatchMiddleware(next).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("want %d, got %d; body=%s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if nextCalled != tt.wantNext {
+				t.Errorf("next called: got %v, want %v", nextCalled, tt.wantNext)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"tiago-udemy/internal/auth"
+	"tiago-udemy/internal/media"
+	"tiago-udemy/internal/store"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-playground/validator/v10"
+	"github.com/lib/pq"
+)
+
+// problemTypeBase prefixes the code to form the RFC 7807 type URI
+const problemTypeBase = "urn:tiago-udemy:problem:"
+
+// Error codes. They are part of the API contract, clients branch on them
+// instead of the message text, so existing codes must never change.
+const (
+	codeBadRequest           = "bad_request"
+	codeInvalidJSON          = "invalid_json"
+	codeInvalidParameter     = "invalid_parameter"
+	codeValidationFailed     = "validation_failed"
+	codeUnauthorized         = "unauthorized"
+	codeForbidden            = "forbidden"
+	codeNotFound             = "not_found"
+	codeMethodNotAllowed     = "method_not_allowed"
+	codeConflict             = "conflict"
+	codeEditConflict         = "edit_conflict"
+	codePreconditionFailed   = "precondition_failed"
+	codePreconditionRequired = "precondition_required"
+	codePayloadTooLarge      = "payload_too_large"
+	codeRateLimited          = "rate_limited"
+	codeLoginThrottled       = "login_throttled"
+	codeInternal             = "internal_error"
+
+	codeDuplicateEmail          = "duplicate_email"
+	codeDuplicateUsername       = "duplicate_username"
+	codeDuplicateRole           = "duplicate_role"
+	codeDuplicateFlag           = "duplicate_flag"
+	codeInvalidToken            = "invalid_token"
+	codeInvalidSecondFactor     = "invalid_second_factor"
+	codeTwoFactorEnabled        = "two_factor_enabled"
+	codeCodeAlreadyUsed         = "code_already_used"
+	codeUnknownPermission       = "unknown_permission"
+	codeInvalidReportTarget     = "invalid_report_target"
+	codeInvalidModerationAction = "invalid_moderation_action"
+	codeJobAlreadyOpen          = "job_already_open"
+	codeUnsupportedMediaType    = "unsupported_media_type"
+	codeFileTooLarge            = "file_too_large"
+	codeNonceMismatch           = "nonce_mismatch"
+	codeSuspendNotAllowed       = "suspend_not_allowed"
+	codeAccountDisabled         = "account_disabled"
+)
+
+// errorRegistry maps the errors handlers pass to the helpers in errors.go to a
+// stable code. Their messages are written for clients and are sent as is.
+var errorRegistry = []struct {
+	err  error
+	code string
+}{
+	{store.ErrRecordNotFound, codeNotFound},
+	{store.ErrEditConflict, codeEditConflict},
+	{store.ErrDuplicateEmail, codeDuplicateEmail},
+	{store.ErrDuplicateUsername, codeDuplicateUsername},
+	{store.ErrDuplicateRole, codeDuplicateRole},
+	{store.ErrDuplicateFlag, codeDuplicateFlag},
+	{store.ErrInvalidToken, codeInvalidToken},
+	{store.ErrTwoFactorEnabled, codeTwoFactorEnabled},
+	{store.ErrCodeAlreadyUsed, codeCodeAlreadyUsed},
+	{store.ErrUnknownPermission, codeUnknownPermission},
+	{store.ErrInvalidReportTarget, codeInvalidReportTarget},
+	{store.ErrInvalidModerationAction, codeInvalidModerationAction},
+	{store.ErrJobAlreadyOpen, codeJobAlreadyOpen},
+	{media.ErrUnsupportedType, codeUnsupportedMediaType},
+	{media.ErrTooLarge, codeFileTooLarge},
+	{auth.ErrNonceMismatch, codeNonceMismatch},
+	{errInvalidSecondFactor, codeInvalidSecondFactor},
+	{errSuspendSelf, codeSuspendNotAllowed},
+	{errSuspendStaff, codeSuspendNotAllowed},
+	{errAccountDisabled, codeAccountDisabled},
+}
+
+// Problem is an RFC 7807 error response
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Code      string       `json:"code"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is a single failed validation rule of the request body
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// clientError carries a detail written for clients, for one-off handler
+// messages that do not deserve a sentinel in errorRegistry. The cause, when
+// set, is only logged.
+type clientError struct {
+	detail string
+	cause  error
+}
+
+func (e *clientError) Error() string {
+	if e.cause == nil {
+		return e.detail
+	}
+	return e.detail + ": " + e.cause.Error()
+}
+
+func (e *clientError) Unwrap() error { return e.cause }
+
+// clientErrorf formats a detail that is shown to clients as is
+func clientErrorf(format string, args ...any) error {
+	return &clientError{detail: fmt.Sprintf(format, args...)}
+}
+
+// newProblem builds the response for err. fallback is the code used when
+// err is not a known error, detail replaces the message of unknown errors.
+// Without a detail they only get the status text, their message may hold
+// internals.
+func newProblem(r *http.Request, status int, err error, fallback, detail string) Problem {
+	p := Problem{
+		Title:     http.StatusText(status),
+		Status:    status,
+		Code:      fallback,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		RequestID: middleware.GetReqID(r.Context()),
+	}
+
+	if code, msg, fields, ok := classify(err); ok {
+		if code != "" {
+			p.Code = code
+		}
+		p.Detail, p.Errors = msg, fields
+	} else if detail == "" {
+		p.Detail = http.StatusText(status)
+	}
+
+	p.Type = problemTypeBase + p.Code
+	return p
+}
+
+// classify returns the code and a client safe message for errors it knows.
+// Errors from the database are never shown and keep the helper's code, they
+// only reach clients when a handler forgot to map them.
+func classify(err error) (code, detail string, fields []FieldError, ok bool) {
+	if err == nil {
+		return "", "", nil, false
+	}
+
+	var clientErr *clientError
+	if errors.As(err, &clientErr) {
+		return "", clientErr.detail, nil, true
+	}
+
+	for _, e := range errorRegistry {
+		if errors.Is(err, e.err) {
+			return e.code, e.err.Error(), nil, true
+		}
+	}
+
+	var validationErrs validator.ValidationErrors
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var maxBytesErr *http.MaxBytesError
+	var numErr *strconv.NumError
+	var pqErr *pq.Error
+
+	switch {
+	case errors.As(err, &validationErrs):
+		for _, fe := range validationErrs {
+			fields = append(fields, FieldError{Field: fieldPath(fe), Rule: fe.Tag(), Message: fieldMessage(fe)})
+		}
+		return codeValidationFailed, "the request body failed validation", fields, true
+	case errors.As(err, &syntaxErr):
+		return codeInvalidJSON, fmt.Sprintf("malformed JSON at offset %d", syntaxErr.Offset), nil, true
+	case errors.As(err, &typeErr):
+		return codeInvalidJSON, fmt.Sprintf("field %q must be a %s", typeErr.Field, typeErr.Type), nil, true
+	case errors.As(err, &maxBytesErr):
+		return codePayloadTooLarge, fmt.Sprintf("the request body must not exceed %d bytes", maxBytesErr.Limit), nil, true
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return codeInvalidJSON, "the request body is empty or incomplete", nil, true
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return codeInvalidJSON, strings.TrimPrefix(err.Error(), "json: "), nil, true
+	case errors.As(err, &numErr):
+		return codeInvalidParameter, fmt.Sprintf("%q is not a valid number", numErr.Num), nil, true
+	case errors.As(err, &pqErr), strings.HasPrefix(err.Error(), "sql: "):
+		return "", "the request could not be processed", nil, true
+	}
+
+	return "", "", nil, false
+}
+
+// fieldPath drops the payload struct name, e.g. "RegisterUserPayload.email"
+// becomes "email"
+func fieldPath(fe validator.FieldError) string {
+	_, path, found := strings.Cut(fe.Namespace(), ".")
+	if !found {
+		return fe.Field()
+	}
+	return path
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	var unit string
+	switch fe.Kind() {
+	case reflect.String:
+		unit = " characters long"
+	case reflect.Slice, reflect.Map:
+		unit = " items"
+	}
+
+	switch tag := fe.Tag(); {
+	case tag == "required":
+		return "is required"
+	case tag == "email":
+		return "must be a valid email address"
+	case tag == "min", tag == "gte":
+		return fmt.Sprintf("must be at least %s%s", fe.Param(), unit)
+	case tag == "max", tag == "lte":
+		return fmt.Sprintf("must be at most %s%s", fe.Param(), unit)
+	case tag == "len":
+		return fmt.Sprintf("must be exactly %s%s", fe.Param(), unit)
+	case tag == "oneof":
+		return fmt.Sprintf("must be one of %s", strings.ReplaceAll(fe.Param(), " ", ", "))
+	case tag == "numeric":
+		return "must contain only digits"
+	case tag == "slug":
+		return "must be lowercase letters and digits, optionally joined by single dashes, dots or underscores"
+	case strings.HasSuffix(tag, "http_url"):
+		return "must be a valid http(s) URL"
+	default:
+		return fmt.Sprintf("failed the %q rule", tag)
+	}
+}
+
+func writeProblem(w http.ResponseWriter, p Problem) error {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	return json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"tiago-udemy/internal/store"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/lib/pq"
+)
+
+func TestProblemResponses(t *testing.T) {
+	app := newTestApp()
+
+	invalid := Validate.Struct(RegisterUserPayload{Email: "not-an-email", Username: "short", Password: "longenough"})
+
+	tests := []struct {
+		name       string
+		respond    func(w http.ResponseWriter, r *http.Request)
+		wantStatus int
+		wantCode   string
+		wantDetail string
+		wantFields map[string]string
+	}{
+		{
+			name:       "validation errors are listed per field",
+			respond:    func(w http.ResponseWriter, r *http.Request) { app.StatusBadRequest(w, r, invalid) },
+			wantStatus: http.StatusBadRequest,
+			wantCode:   codeValidationFailed,
+			wantDetail: "the request body failed validation",
+			wantFields: map[string]string{"email": "must be a valid email address", "username": "must be at least 8 characters long"},
+		},
+		{
+			name: "registered errors get their code",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				app.StatusBadRequest(w, r, fmt.Errorf("activate: %w", store.ErrInvalidToken))
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   codeInvalidToken,
+			wantDetail: "invalid or expired token",
+		},
+		{
+			name: "database errors are not shown",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				app.StatusBadRequest(w, r, &pq.Error{Message: "relation users does not exist"})
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   codeBadRequest,
+			wantDetail: "the request could not be processed",
+		},
+		{
+			name: "internal errors are not shown",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				app.InternaServerError(w, r, errors.New("dial tcp: refused"))
+			},
+			wantStatus: http.StatusInternalServerError,
+			wantCode:   codeInternal,
+			wantDetail: "the server encountered a problem",
+		},
+		{
+			name: "handler messages keep the helper code",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				app.StatusBadRequest(w, r, clientErrorf("missing tokens"))
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   codeBadRequest,
+			wantDetail: "missing tokens",
+		},
+		{
+			name: "causes of handler messages are not shown",
+			respond: func(w http.ResponseWriter, r *http.Request) {
+				app.StatusBadRequest(w, r, &clientError{detail: "missing file", cause: errors.New("http: no such file")})
+			},
+			wantStatus: http.StatusBadRequest,
+			wantCode:   codeBadRequest,
+			wantDetail: "missing file",
+		},
+		{
+			name:       "unregistered errors only get the status text",
+			respond:    func(w http.ResponseWriter, r *http.Request) { app.StatusBadRequest(w, r, errors.New("missing tokens")) },
+			wantStatus: http.StatusBadRequest,
+			wantCode:   codeBadRequest,
+			wantDetail: "Bad Request",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/things", nil)
+			req = req.WithContext(context.WithValue(req.Context(), middleware.RequestIDKey, "req-1"))
+			rr := httptest.NewRecorder()
+			tt.respond(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("want %d, got %d", tt.wantStatus, rr.Code)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "application/problem+json" {
+				t.Errorf("want problem+json, got %q", ct)
+			}
+
+			var p Problem
+			if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Code != tt.wantCode || p.Type != problemTypeBase+tt.wantCode {
+				t.Errorf("want code %q, got %q (type %q)", tt.wantCode, p.Code, p.Type)
+			}
+			if p.Detail != tt.wantDetail {
+				t.Errorf("want detail %q, got %q", tt.wantDetail, p.Detail)
+			}
+			if p.RequestID != "req-1" || p.Instance != "/v1/things" || p.Status != tt.wantStatus {
+				t.Errorf("unexpected problem %+v", p)
+			}
+
+			if len(p.Errors) != len(tt.wantFields) {
+				t.Fatalf("want %d field errors, got %+v", len(tt.wantFields), p.Errors)
+			}
+			for _, fe := range p.Errors {
+				if tt.wantFields[fe.Field] != fe.Message {
+					t.Errorf("field %q: want %q, got %q", fe.Field, tt.wantFields[fe.Field], fe.Message)
+				}
+			}
+		})
+	}
+}
+
+func TestRouterProblems(t *testing.T) {
+	app := newTestApp()
+
+	r := chi.NewRouter()
+	r.Use(app.RecoverPanic)
+	r.NotFound(app.notFoundResponse)
+	r.MethodNotAllowed(func(w http.ResponseWriter, req *http.Request) {
+		app.methodNotAllowedResponse(w, req, allowedMethods(r, req.URL.Path))
+	})
+	r.Route("/v1", func(r chi.Router) {
+		r.Get("/things", func(w http.ResponseWriter, r *http.Request) {})
+		r.Post("/things", func(w http.ResponseWriter, r *http.Request) {})
+		r.Get("/panic", func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantCode   string
+		wantAllow  string
+	}{
+		{name: "unknown route", method: http.MethodGet, path: "/v1/nothing", wantStatus: http.StatusNotFound, wantCode: codeNotFound},
+		{name: "unknown top level route", method: http.MethodGet, path: "/nothing", wantStatus: http.StatusNotFound, wantCode: codeNotFound},
+		{name: "wrong method", method: http.MethodDelete, path: "/v1/things", wantStatus: http.StatusMethodNotAllowed, wantCode: codeMethodNotAllowed, wantAllow: "GET, POST"},
+		{name: "panic", method: http.MethodGet, path: "/v1/panic", wantStatus: http.StatusInternalServerError, wantCode: codeInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("want %d, got %d", tt.wantStatus, rr.Code)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "application/problem+json" {
+				t.Errorf("want problem+json, got %q", ct)
+			}
+			if allow := rr.Header().Get("Allow"); allow != tt.wantAllow {
+				t.Errorf("want Allow %q, got %q", tt.wantAllow, allow)
+			}
+
+			var p Problem
+			if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Code != tt.wantCode {
+				t.Errorf("want code %q, got %q", tt.wantCode, p.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	appconfig "tiago-udemy/internal/config"
+	"tiago-udemy/internal/ratelimiter"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// configWatchInterval is how often the config file is checked for changes
+const configWatchInterval = 5 * time.Second
+
+// logLevel returns the configured level or the default of the environment
+func logLevel(c *appconfig.Config) zapcore.Level {
+	if level, err := zapcore.ParseLevel(c.LogLevel); c.LogLevel != "" && err == nil {
+		return level
+	}
+	if c.Env == appconfig.EnvProduction {
+		return zapcore.InfoLevel
+	}
+	return zapcore.DebugLevel
+}
+
+// configReloadLoop reloads the config on SIGHUP and whenever the file at path
+// changes, until ctx is done. Only the log level, rate limits and cache TTLs
+// are applied, other changes are logged and take effect on the next restart.
+// Feature flags are re-read from the database on every reload.
+func (app *application) configReloadLoop(ctx context.Context, path string, current *appconfig.Config) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var watch <-chan time.Time
+	var version fileVersion
+	if path != "" {
+		ticker := time.NewTicker(configWatchInterval)
+		defer ticker.Stop()
+		watch = ticker.C
+		version = statFile(path)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			app.logger.Infow("reloading config", "reason", "SIGHUP")
+		case <-watch:
+			v := statFile(path)
+			if v == version {
+				continue
+			}
+			version = v
+			app.logger.Infow("reloading config", "reason", "file changed", "path", path)
+		}
+
+		current = app.reloadConfig(ctx, path, current)
+	}
+}
+
+// fileVersion tells edits apart without reading the file. A missing file has
+// the zero version, so it is reloaded, and rejected, once it reappears.
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(path string) fileVersion {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}
+	}
+	return fileVersion{modTime: info.ModTime(), size: info.Size()}
+}
+
+// reloadConfig loads the config and applies the settings that can change at
+// runtime. It returns the config now in effect: current with those settings
+// replaced, or current unchanged when the new config is invalid.
+func (app *application) reloadConfig(ctx context.Context, path string, current *appconfig.Config) *appconfig.Config {
+	next, warnings, err := appconfig.Load(path)
+	if err != nil {
+		app.logger.Errorw("config reload rejected, keeping the current config", "error", err)
+		return current
+	}
+	for _, warning := range warnings {
+		app.logger.Warn(warning)
+	}
+
+	running := withRuntimeSettings(current, next)
+	app.applyConfig(current, running)
+	if changed := restartRequired(running, next); len(changed) > 0 {
+		app.logger.Warnw("config changes need a restart to take effect", "settings", changed)
+	}
+
+	app.refreshFlags(ctx)
+	return running
+}
+
+// withRuntimeSettings returns a copy of base with the settings applied at
+// runtime taken from next
+func withRuntimeSettings(base, next *appconfig.Config) *appconfig.Config {
+	c := *base
+	c.LogLevel = next.LogLevel
+	c.RateLimit.Default = next.RateLimit.Default
+	c.RateLimit.Login = next.RateLimit.Login
+	c.RateLimit.Register = next.RateLimit.Register
+	c.RateLimit.AuthFailure = next.RateLimit.AuthFailure
+	c.Cache.TTL = next.Cache.TTL
+	c.Cache.LocalTTL = next.Cache.LocalTTL
+	return &c
+}
+
+// applyConfig hands every subsystem its new settings and logs what changed
+func (app *application) applyConfig(old, next *appconfig.Config) {
+	if from, to := app.logLevel.Level(), logLevel(next); from != to {
+		app.logLevel.SetLevel(to)
+		app.logger.Infow("log level changed", "from", from, "to", to)
+	}
+
+	policies := map[string][2]appconfig.RateLimitPolicy{
+		rateLimitDefault:     {old.RateLimit.Default, next.RateLimit.Default},
+		rateLimitLogin:       {old.RateLimit.Login, next.RateLimit.Login},
+		rateLimitRegister:    {old.RateLimit.Register, next.RateLimit.Register},
+		rateLimitAuthFailure: {old.RateLimit.AuthFailure, next.RateLimit.AuthFailure},
+	}
+	for name, p := range policies {
+		from, to := p[0], p[1]
+		limiter, ok := app.limiters[name].(ratelimiter.Tunable)
+		if from == to || !ok {
+			continue
+		}
+		limiter.SetLimit(to.Requests, to.Window)
+		app.logger.Infow("rate limit changed", "policy", name,
+			"requests_from", from.Requests, "requests_to", to.Requests,
+			"window_from", from.Window, "window_to", to.Window)
+	}
+
+	if from, to := old.Cache.TTL, next.Cache.TTL; from != to {
+		app.caches.SetTTL(to)
+		app.logger.Infow("cache ttl changed", "from", from, "to", to)
+	}
+	if from, to := old.Cache.LocalTTL, next.Cache.LocalTTL; from != to && app.localCache != nil {
+		app.localCache.SetLocalTTL(to)
+		app.logger.Infow("local cache ttl changed", "from", from, "to", to)
+	}
+}
+
+// restartRequired lists the top level sections of next that differ from the
+// running config, whose runtime settings are already up to date
+func restartRequired(running, next *appconfig.Config) []string {
+	var changed []string
+	o, n := reflect.ValueOf(*running), reflect.ValueOf(*next)
+	for i := range o.NumField() {
+		if !reflect.DeepEqual(o.Field(i).Interface(), n.Field(i).Interface()) {
+			changed = append(changed, o.Type().Field(i).Tag.Get("yaml"))
+		}
+	}
+	return changed
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	appconfig "tiago-udemy/internal/config"
+	"tiago-udemy/internal/flags"
+	"tiago-udemy/internal/ratelimiter"
+	"tiago-udemy/internal/store"
+	"tiago-udemy/internal/store/cache"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestReloadConfig(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	app := newTestApp()
+	app.logger = zap.New(core).Sugar()
+	app.logLevel = zap.NewAtomicLevelAt(zapcore.DebugLevel)
+	app.caches = cache.NewNoOpStore(zap.NewNop().Sugar())
+	app.flags = flags.New(func(ctx context.Context) ([]store.FeatureFlag, error) { return nil, nil })
+	login := ratelimiter.NewFixedWindowLimiter(5, time.Minute)
+	app.limiters = map[string]ratelimiter.Limiter{rateLimitLogin: ratelimiter.Instrument(rateLimitLogin, login)}
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("log_level: debug\n")
+	current, _, err := appconfig.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write(`
+log_level: warn
+addr: ":9000"
+rate_limit:
+  login:
+    window: 1m
+    requests: 2
+`)
+	current = app.reloadConfig(context.Background(), path, current)
+
+	if got := app.logLevel.Level(); got != zapcore.WarnLevel {
+		t.Errorf("want log level warn, got %s", got)
+	}
+	if res := login.Allow("10.0.0.1"); res.Limit != 2 {
+		t.Errorf("want login limit 2, got %d", res.Limit)
+	}
+	if n := logs.FilterMessage("rate limit changed").FilterField(zap.String("policy", rateLimitLogin)).Len(); n != 1 {
+		t.Errorf("want the login limit change logged, got %d entries", n)
+	}
+	restart := logs.FilterMessage("config changes need a restart to take effect").All()
+	if len(restart) != 1 || len(restart[0].ContextMap()["settings"].([]any)) != 1 {
+		t.Errorf("want only addr reported as needing a restart, got %v", restart)
+	}
+	if current.Addr != ":8080" || current.RateLimit.Login.Requests != 2 {
+		t.Errorf("want the startup addr kept and the login limit applied, got addr %q and limit %d", current.Addr, current.RateLimit.Login.Requests)
+	}
+
+	// the pending restart is reported until the change is reverted
+	current = app.reloadConfig(context.Background(), path, current)
+	if n := logs.FilterMessage("config changes need a restart to take effect").Len(); n != 2 {
+		t.Errorf("want the pending restart reported again, got %d entries", n)
+	}
+	write("log_level: warn\nrate_limit:\n  login:\n    window: 1m\n    requests: 2\n")
+	current = app.reloadConfig(context.Background(), path, current)
+	if n := logs.FilterMessage("config changes need a restart to take effect").Len(); n != 2 {
+		t.Errorf("want no restart reported once addr is reverted, got %d entries", n)
+	}
+
+	write("rate_limit:\n  login:\n    requests: 0\n")
+	if got := app.reloadConfig(context.Background(), path, current); got != current {
+		t.Error("want an invalid config rejected")
+	}
+	if res := login.Allow("10.0.0.2"); res.Limit != 2 {
+		t.Errorf("want the login limit kept after a rejected reload, got %d", res.Limit)
+	}
+	if n := logs.FilterMessage("config reload rejected, keeping the current config").Len(); n != 1 {
+		t.Errorf("want the rejected reload logged, got %d entries", n)
+	}
+}
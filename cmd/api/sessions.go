@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"tiago-udemy/internal/store"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	// sessionSeenInterval bounds how often last_seen_at is written per session
+	sessionSeenInterval = time.Minute
+	maxUserAgentLength  = 512
+)
+
+type sessionKey string
+
+const sessionCtx sessionKey = "session"
+
+// newSessionToken records a session for a login and returns its access token
+func (app *application) newSessionToken(r *http.Request, userID int64) (string, error) {
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	session := &store.Session{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		UserAgent: userAgent,
+		IP:        clientIP(r),
+		ExpiresAt: time.Now().Add(app.config.authConfig.jwtAuth.exp),
+	}
+
+	if err := app.store.Sessions.Create(r.Context(), session); err != nil {
+		return "", err
+	}
+
+	return app.generateAccessToken(userID, session.ID)
+}
+
+// getSession returns an unexpired session and moves its last_seen_at forward,
+// at most once per sessionSeenInterval
+func (app *application) getSession(ctx context.Context, id string) (*store.Session, error) {
+	session, err := app.store.Sessions.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Since(session.LastSeenAt) < sessionSeenInterval {
+		return session, nil
+	}
+
+	if err := app.store.Sessions.TouchLastSeen(ctx, session.ID); err != nil {
+		app.logger.Errorw("session last seen update failed", "session_id", session.ID, "error", err)
+		return session, nil
+	}
+	session.LastSeenAt = time.Now()
+
+	return session, nil
+}
+
+// ListSessions godoc
+//
+//	@Summary		Lists sessions
+//	@Description	Lists the devices the current user is logged in on
+//	@Tags			sessions
+//	@Produce		json
+//	@Success		200	{array}		store.Session
+//	@Failure		401	{object}	Problem
+//	@Failure		403	{object}	Problem
+//	@Failure		500	{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/me/sessions [get]
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserCtx(r)
+	current := getSessionCtx(r)
+
+	sessions, err := app.store.Sessions.GetByUser(r.Context(), user.ID)
+	if err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current.ID
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, sessions); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+// RevokeSession godoc
+//
+//	@Summary		Revokes a session
+//	@Description	Logs the current user out on one device
+//	@Tags			sessions
+//	@Produce		json
+//	@Param			sessionID	path		string	true	"Session ID"
+//	@Success		204			{string}	string	"Session revoked"
+//	@Failure		400			{object}	Problem
+//	@Failure		401			{object}	Problem
+//	@Failure		403			{object}	Problem
+//	@Failure		404			{object}	Problem
+//	@Failure		500			{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/me/sessions/{sessionID} [delete]
+func (app *application) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "sessionID")
+	if err := uuid.Validate(id); err != nil {
+		app.StatusBadRequest(w, r, clientErrorf("invalid sessionID"))
+		return
+	}
+
+	user := getUserCtx(r)
+	ctx := r.Context()
+
+	if err := app.store.Sessions.Delete(ctx, id, user.ID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrRecordNotFound):
+			app.RecordNotFound(w, r, err)
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+// RevokeOtherSessions godoc
+//
+//	@Summary		Revokes all other sessions
+//	@Description	Logs the current user out everywhere except on the device making the request
+//	@Tags			sessions
+//	@Produce		json
+//	@Success		204	{string}	string	"Sessions revoked"
+//	@Failure		401	{object}	Problem
+//	@Failure		403	{object}	Problem
+//	@Failure		500	{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/me/sessions/revoke-others [post]
+func (app *application) revokeOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserCtx(r)
+	current := getSessionCtx(r)
+	ctx := r.Context()
+
+	if _, err := app.store.Sessions.DeleteOthers(ctx, user.ID, current.ID); err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+func getSessionCtx(r *http.Request) *store.Session {
+	session, ok := r.Context().Value(sessionCtx).(*store.Session)
+
+	if !ok {
+		panic("expecting session")
+	}
+	return session
+}
+
+// purgeExpiredSessions deletes sessions past their expiry, they can no longer
+// authenticate and only grow the table
+func (app *application) purgeExpiredSessions(ctx context.Context) {
+	n, err := app.store.Sessions.DeleteExpired(ctx)
+	if err != nil {
+		app.logger.Errorw("expired sessions purge failed", "error", err)
+		return
+	}
+
+	if n > 0 {
+		app.logger.Infow("expired sessions purged", "count", n)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"tiago-udemy/internal/auth"
+	"tiago-udemy/internal/store"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestSessionRevocation(t *testing.T) {
+	app := newTestApp()
+	app.config.authConfig.jwtAuth = jwtAuth{secret: "test-secret", iss: "test", exp: time.Hour}
+	app.authenticator = auth.NewJWTAuthenticator("test-secret", "test", "test")
+	app.store.Users = &store.MockUserStore{
+		GetUserbyIDFunc: func(ctx context.Context, userID int64) (*store.User, error) {
+			return &store.User{ID: userID, IsActivated: true}, nil
+		},
+	}
+
+	login := func(userID int64) string {
+		req := httptest.NewRequest(http.MethodPost, "/v1/authentication/login", nil)
+		token, err := app.newSessionToken(req, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	router := chi.NewRouter()
+	router.With(app.UserAuthMiddleware).Route("/v1/me/sessions", func(r chi.Router) {
+		r.Get("/", app.listSessionsHandler)
+		r.Post("/revoke-others", app.revokeOtherSessionsHandler)
+		r.Delete("/{sessionID}", app.revokeSessionHandler)
+	})
+
+	do := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	laptop, phone, tablet := login(1), login(1), login(1)
+	other := login(2)
+
+	t.Run("token without a session is rejected", func(t *testing.T) {
+		token, err := app.authenticator.GenerateToken(jwt.MapClaims{
+			"sub": 1, "exp": time.Now().Add(time.Hour).Unix(), "iss": "test", "aud": "test",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code := do(http.MethodGet, "/v1/me/sessions/", token); code != http.StatusUnauthorized {
+			t.Errorf("want 401, got %d", code)
+		}
+	})
+
+	t.Run("revoked session is rejected", func(t *testing.T) {
+		_, phoneSession, err := app.parseAccessToken(phone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code := do(http.MethodDelete, "/v1/me/sessions/"+phoneSession, laptop); code != http.StatusNoContent {
+			t.Fatalf("want 204, got %d", code)
+		}
+		if code := do(http.MethodGet, "/v1/me/sessions/", phone); code != http.StatusUnauthorized {
+			t.Errorf("want 401, got %d", code)
+		}
+	})
+
+	t.Run("cannot revoke another user's session", func(t *testing.T) {
+		_, otherSession, err := app.parseAccessToken(other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code := do(http.MethodDelete, "/v1/me/sessions/"+otherSession, laptop); code != http.StatusNotFound {
+			t.Errorf("want 404, got %d", code)
+		}
+	})
+
+	t.Run("revoke others keeps the current session", func(t *testing.T) {
+		if code := do(http.MethodPost, "/v1/me/sessions/revoke-others", laptop); code != http.StatusNoContent {
+			t.Fatalf("want 204, got %d", code)
+		}
+		if code := do(http.MethodGet, "/v1/me/sessions/", tablet); code != http.StatusUnauthorized {
+			t.Errorf("tablet: want 401, got %d", code)
+		}
+		if code := do(http.MethodGet, "/v1/me/sessions/", laptop); code != http.StatusOK {
+			t.Errorf("laptop: want 200, got %d", code)
+		}
+		if code := do(http.MethodGet, "/v1/me/sessions/", other); code != http.StatusOK {
+			t.Errorf("other user: want 200, got %d", code)
+		}
+	})
+}
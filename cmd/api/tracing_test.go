@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { provider.Shutdown(t.Context()) })
+
+	core, logs := observer.New(zap.InfoLevel)
+	app := newTestApp()
+	app.logger = zap.New(core).Sugar()
+
+	r := chi.NewRouter()
+	r.Use(app.TracingMiddleware)
+	r.Get("/things/{thingID}", func(w http.ResponseWriter, r *http.Request) {
+		app.contextLogger(r.Context()).Infow("handled")
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/things/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("want 1 span, got %d", len(spans))
+	}
+	span := spans[0]
+
+	if span.Name != "GET /things/{thingID}" {
+		t.Errorf("span should be named after the route pattern, got %q", span.Name)
+	}
+	if got := span.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("span should continue the incoming trace, got trace %s", got)
+	}
+	if got := span.Parent.SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("span should be a child of the incoming span, got parent %s", got)
+	}
+	if span.Status.Description != http.StatusText(http.StatusInternalServerError) {
+		t.Errorf("5xx responses should mark the span as failed, got status %v", span.Status)
+	}
+
+	entries := logs.FilterField(zap.String("trace_id", "4bf92f3577b34da6a3ce929d0e0e4736")).All()
+	if len(entries) != 1 {
+		t.Errorf("log lines should carry the trace id, got %v", logs.All())
+	}
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"tiago-udemy/internal/auth"
+	"tiago-udemy/internal/store"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// challengeTokenType marks tokens that are only good for the second login step
+const challengeTokenType = "2fa_challenge"
+
+var errInvalidSecondFactor = errors.New("invalid two-factor code")
+
+type twoFactorConfig struct {
+	issuer        string
+	challengeExp  time.Duration
+	recoveryCodes int
+}
+
+type TwoFactorChallenge struct {
+	ChallengeToken string `json:"challenge_token"`
+}
+
+type TwoFactorEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// EnrollTwoFactor godoc
+//
+//	@Summary		Starts two-factor enrollment
+//	@Description	Creates a TOTP secret for the current user. Scan the otpauth URI with an authenticator app and confirm it with a code to turn two-factor authentication on
+//	@Tags			two-factor
+//	@Produce		json
+//	@Success		201	{object}	TwoFactorEnrollment
+//	@Failure		401	{object}	Problem
+//	@Failure		409	{object}	Problem
+//	@Failure		500	{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/me/2fa/enroll [post]
+func (app *application) enrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserCtx(r)
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	if err := app.store.TwoFactor.CreatePending(ctx, user.ID, secret); err != nil {
+		switch {
+		case errors.Is(err, store.ErrTwoFactorEnabled):
+			app.ConflictResponse(w, r, err)
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	enrollment := TwoFactorEnrollment{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(secret, app.config.authConfig.twoFactor.issuer, user.Email),
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, enrollment); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+type ConfirmTwoFactorPayload struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+// ConfirmTwoFactor godoc
+//
+//	@Summary		Confirms two-factor enrollment
+//	@Description	Turns two-factor authentication on once the authenticator app produces a valid code. The recovery codes are only shown once
+//	@Tags			two-factor
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ConfirmTwoFactorPayload	true	"TOTP code"
+//	@Success		200		{object}	RecoveryCodes
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		404		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/me/2fa/confirm [post]
+func (app *application) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ConfirmTwoFactorPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	user := getUserCtx(r)
+	ctx := r.Context()
+
+	twoFactor, err := app.store.TwoFactor.Get(ctx, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrRecordNotFound):
+			app.RecordNotFound(w, r, clientErrorf("no pending two-factor enrollment"))
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+	if twoFactor.Enabled {
+		app.ConflictResponse(w, r, store.ErrTwoFactorEnabled)
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(twoFactor.Secret, payload.Code, time.Now())
+	if !ok {
+		app.StatusBadRequest(w, r, errInvalidSecondFactor)
+		return
+	}
+
+	codes, err := auth.GenerateRecoveryCodes(app.config.authConfig.twoFactor.recoveryCodes)
+	if err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = sha256Hex(code)
+	}
+
+	if err := app.store.TwoFactor.Enable(ctx, user.ID, step, hashes); err != nil {
+		switch {
+		case errors.Is(err, store.ErrRecordNotFound):
+			app.RecordNotFound(w, r, clientErrorf("no pending two-factor enrollment"))
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, RecoveryCodes{RecoveryCodes: codes}); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+// DisableTwoFactor godoc
+//
+//	@Summary		Turns two-factor authentication off
+//	@Description	Turns two-factor authentication off after checking a TOTP or recovery code and the password. Users without a password must have logged in recently instead
+//	@Tags			two-factor
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ReauthPayload	true	"Re-authentication payload"
+//	@Success		204		{string}	string			"Two-factor authentication disabled"
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		404		{object}	Problem
+//	@Failure		429		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Security		ApiKeyAuth
+//	@Router			/me/2fa [delete]
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ReauthPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	user := getUserCtx(r)
+	ctx := r.Context()
+
+	if _, err := app.store.TwoFactor.Get(ctx, user.ID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrRecordNotFound):
+			app.RecordNotFound(w, r, clientErrorf("two-factor authentication is not enabled"))
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	// also asks for the second factor, since it is still on
+	if !app.reauthenticate(w, r, payload) {
+		return
+	}
+
+	if err := app.store.TwoFactor.Disable(ctx, user.ID); err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+type VerifyTwoFactorPayload struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code" validate:"required,max=11"`
+}
+
+// VerifyTwoFactor godoc
+//
+//	@Summary		Completes a two-factor login
+//	@Description	Exchanges the challenge token from /authentication/login and a TOTP or recovery code for an access token
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		VerifyTwoFactorPayload	true	"Challenge payload"
+//	@Success		201		{string}	string					"Token"
+//	@Failure		400		{object}	Problem
+//	@Failure		401		{object}	Problem
+//	@Failure		429		{object}	Problem
+//	@Failure		500		{object}	Problem
+//	@Router			/authentication/2fa/verify [post]
+func (app *application) verifyTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var payload VerifyTwoFactorPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.StatusBadRequest(w, r, err)
+		return
+	}
+
+	userID, err := app.parseChallengeToken(payload.ChallengeToken)
+	if err != nil {
+		app.InvalidUserAuthorization(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	attemptKey := twoFactorAttemptKey(userID)
+
+	wait, err := app.loginRetryAfter(ctx, attemptKey)
+	if err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+	if wait > 0 {
+		app.tooManyLoginAttempts(w, r, strconv.Itoa(ceilSeconds(wait)))
+		return
+	}
+
+	twoFactor, err := app.store.TwoFactor.Get(ctx, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrRecordNotFound):
+			app.InvalidUserAuthorization(w, r, fmt.Errorf("two-factor authentication is not enabled"))
+			return
+		default:
+			app.InternaServerError(w, r, err)
+			return
+		}
+	}
+
+	if err := app.checkSecondFactor(ctx, twoFactor, payload.Code); err != nil {
+		app.secondFactorFailed(w, r, attemptKey, err)
+		return
+	}
+
+	if err := app.store.LoginAttempts.Reset(ctx, attemptKey); err != nil {
+		app.logger.Errorw("resetting failed logins failed", "key", attemptKey, "error", err)
+	}
+
+	// the account may have been disabled since the first step
+	user, err := app.store.Users.GetUserbyID(ctx, userID)
+	if err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+	if user.IsDisabled {
+		app.ForbiddenRequest(w, r, errAccountDisabled)
+		return
+	}
+
+	token, err := app.newSessionToken(r, userID)
+	if err != nil {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, token); err != nil {
+		app.InternaServerError(w, r, err)
+	}
+}
+
+func (app *application) generateChallengeToken(user *store.User) (string, error) {
+	claims := jwt.MapClaims{
+		"sub": user.ID,
+		"typ": challengeTokenType,
+		"exp": time.Now().Add(app.config.authConfig.twoFactor.challengeExp).Unix(),
+		"iat": time.Now().Unix(),
+		"nbf": time.Now().Unix(),
+		"iss": app.config.authConfig.jwtAuth.iss,
+		"aud": app.config.authConfig.jwtAuth.iss,
+	}
+
+	return app.authenticator.GenerateToken(claims)
+}
+
+func (app *application) parseChallengeToken(token string) (int64, error) {
+	jwtToken, err := app.authenticator.ValidateToken(token)
+	if err != nil {
+		return 0, err
+	}
+
+	claims, ok := jwtToken.Claims.(jwt.MapClaims)
+	if !ok || !jwtToken.Valid || claims["typ"] != challengeTokenType {
+		return 0, fmt.Errorf("invalid challenge token")
+	}
+
+	userID, ok := claims["sub"].(float64)
+	if !ok {
+		return 0, fmt.Errorf("invalid token subject")
+	}
+
+	return int64(userID), nil
+}
+
+// checkSecondFactor accepts a TOTP code that was not used before, or an unused
+// recovery code
+func (app *application) checkSecondFactor(ctx context.Context, twoFactor *store.TwoFactor, code string) error {
+	if !twoFactor.Enabled {
+		return fmt.Errorf("two-factor authentication is not enabled")
+	}
+
+	if strings.Contains(code, "-") {
+		err := app.store.TwoFactor.UseRecoveryCode(ctx, twoFactor.UserID, sha256Hex(strings.ToLower(code)))
+		if errors.Is(err, store.ErrRecordNotFound) {
+			return errInvalidSecondFactor
+		}
+		return err
+	}
+
+	step, ok := auth.ValidateTOTP(twoFactor.Secret, code, time.Now())
+	if !ok {
+		return errInvalidSecondFactor
+	}
+
+	if err := app.store.TwoFactor.UseStep(ctx, twoFactor.UserID, step); err != nil {
+		if errors.Is(err, store.ErrCodeAlreadyUsed) {
+			return errInvalidSecondFactor
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (app *application) secondFactorFailed(w http.ResponseWriter, r *http.Request, attemptKey string, err error) {
+	if !errors.Is(err, errInvalidSecondFactor) {
+		app.InternaServerError(w, r, err)
+		return
+	}
+
+	app.recordSecondFactorFailure(r.Context(), attemptKey)
+	app.InvalidUserAuthorization(w, r, err)
+}
+
+// recordSecondFactorFailure counts wrong codes like failed logins, so the six
+// digits cannot be guessed with a stolen password
+func (app *application) recordSecondFactorFailure(ctx context.Context, key string) {
+	cfg := app.config.authConfig.lockout
+
+	if _, err := app.store.LoginAttempts.RecordFailure(ctx, key, cfg.window, cfg.accountThreshold, cfg.duration); err != nil {
+		app.logger.Errorw("recording failed login failed", "key", key, "error", err)
+	}
+}
+
+func twoFactorAttemptKey(userID int64) string {
+	return "2fa:" + strconv.FormatInt(userID, 10)
+}
+
+func sha256Hex(plain string) string {
+	hash := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(hash[:])
+}
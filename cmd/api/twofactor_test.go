@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"tiago-udemy/internal/auth"
+	"tiago-udemy/internal/store"
+	"time"
+)
+
+func TestChallengeToken(t *testing.T) {
+	app := newTestApp()
+	app.config.authConfig.jwtAuth = jwtAuth{secret: "test-secret", iss: "test", exp: time.Hour}
+	app.config.authConfig.twoFactor = twoFactorConfig{challengeExp: time.Minute}
+	app.authenticator = auth.NewJWTAuthenticator("test-secret", "test", "test")
+	app.store.Users = &store.MockUserStore{
+		GetUserbyIDFunc: func(ctx context.Context, userID int64) (*store.User, error) {
+			return &store.User{ID: userID, IsActivated: true}, nil
+		},
+	}
+
+	user := &store.User{ID: 42}
+	challenge, err := app.generateChallengeToken(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	access, err := app.newSessionToken(httptest.NewRequest(http.MethodPost, "/v1/authentication/login", nil), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("challenge token is accepted by the verify step", func(t *testing.T) {
+		userID, err := app.parseChallengeToken(challenge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if userID != user.ID {
+			t.Errorf("got user %d, want %d", userID, user.ID)
+		}
+	})
+
+	t.Run("access token is not a challenge", func(t *testing.T) {
+		if _, err := app.parseChallengeToken(access); err == nil {
+			t.Error("want error for an access token")
+		}
+	})
+
+	authenticate := func(token string) int {
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/v1/users/feed", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		app.UserAuthMiddleware(next).ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	t.Run("challenge token cannot access the API", func(t *testing.T) {
+		if code := authenticate(challenge); code != http.StatusUnauthorized {
+			t.Errorf("want 401, got %d", code)
+		}
+	})
+
+	t.Run("access token can access the API", func(t *testing.T) {
+		if code := authenticate(access); code != http.StatusOK {
+			t.Errorf("want 200, got %d", code)
+		}
+	})
+}
+
+func TestDisableTwoFactorWithoutPassword(t *testing.T) {
+	app := newTestApp()
+	app.config.authConfig.reauthWindow = time.Minute
+	app.store.LoginAttempts = &fakeLoginAttempts{}
+
+	tests := []struct {
+		name        string
+		enabled     bool
+		body        string
+		loggedInAgo time.Duration
+		wantStatus  int
+	}{
+		{"recent login and recovery code", true, `{"code":"abcd-efgh"}`, 0, http.StatusNoContent},
+		{"recent login without a code", true, `{}`, 0, http.StatusUnauthorized},
+		{"login outside the reauth window", true, `{"code":"abcd-efgh"}`, time.Hour, http.StatusUnauthorized},
+		{"two-factor not enabled", false, `{"code":"abcd-efgh"}`, 0, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// MockUserStore returns users without a password, like social login accounts
+			twoFactor := &fakeTwoFactor{
+				users:    map[int64]*store.TwoFactor{},
+				recovery: map[string]bool{sha256Hex("abcd-efgh"): true},
+			}
+			if tt.enabled {
+				twoFactor.users[1] = &store.TwoFactor{UserID: 1, Enabled: true}
+			}
+			app.store.TwoFactor = twoFactor
+
+			req := httptest.NewRequest(http.MethodDelete, "/v1/me/2fa", strings.NewReader(tt.body))
+			req = withTestSession(withTestUser(req, 1, testUserRole), 1, time.Now().Add(-tt.loggedInAgo))
+			rr := httptest.NewRecorder()
+			app.disableTwoFactorHandler(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("want %d, got %d; body=%s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if _, stillOn := twoFactor.users[1]; stillOn != (tt.enabled && tt.wantStatus != http.StatusNoContent) {
+				t.Errorf("two-factor still enabled: %v", stillOn)
+			}
+		})
+	}
+}
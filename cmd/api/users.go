@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"errors"
-	"fmt"
 	"net/http"
 	"strconv"
 	"tiago-udemy/internal/store"
@@ -24,9 +23,9 @@ const targetUserCtx targetUserKey = "targetUser"
 //	@Produce		json
 //	@Param			userID	path		int	true	"User ID"
 //	@Success		200		{object}	store.User
-//	@Failure		400		{object}	error
-//	@Failure		404		{object}	error
-//	@Failure		500		{object}	error
+//	@Failure		400		{object}	Problem
+//	@Failure		404		{object}	Problem
+//	@Failure		500		{object}	Problem
 //	@Security		ApiKeyAuth
 //	@Router			/users/{userID} [get]
 func (app *application) getUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -50,8 +49,8 @@ func (app *application) getUserHandler(w http.ResponseWriter, r *http.Request) {
 //	@Produce		json
 //	@Param			userID	path		int		true	"User ID"
 //	@Success		204		{string}	string	"User followed"
-//	@Failure		400		{object}	error	"User payload missing"
-//	@Failure		404		{object}	error	"User not found"
+//	@Failure		400		{object}	Problem	"User payload missing"
+//	@Failure		404		{object}	Problem	"User not found"
 //	@Security		ApiKeyAuth
 //	@Router			/users/{userID}/follow [put]
 func (app *application) followUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -81,8 +80,8 @@ func (app *application) followUserHandler(w http.ResponseWriter, r *http.Request
 //	@Produce		json
 //	@Param			userID	path		int		true	"User ID"
 //	@Success		204		{string}	string	"User unfollowed"
-//	@Failure		400		{object}	error	"User payload missing"
-//	@Failure		404		{object}	error	"User not found"
+//	@Failure		400		{object}	Problem	"User payload missing"
+//	@Failure		404		{object}	Problem	"User not found"
 //	@Security		ApiKeyAuth
 //	@Router			/users/{userID}/unfollow [put]
 func (app *application) unfollowUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -107,7 +106,7 @@ func (app *application) GetTargetUserMiddlewareContext(next http.Handler) http.H
 
 		targetUserStr := chi.URLParam(r, "userID")
 		if targetUserStr == "" {
-			app.StatusBadRequest(w, r, fmt.Errorf("missing userID"))
+			app.StatusBadRequest(w, r, clientErrorf("missing userID"))
 			return
 		}
 
@@ -0,0 +1,136 @@
+// Command migrate applies the SQL migrations embedded at build time.
+//
+//	migrate up          apply every pending migration
+//	migrate down N      revert the last N migrations
+//	migrate goto V      migrate up or down to version V, 0 reverts everything
+//	migrate version     print the applied version
+//	migrate force V     set the version without running migrations
+//	migrate status      list migrations and whether they are applied
+//
+// The database address is read from DB_ADDR, or the file named by
+// DB_ADDR_FILE, and otherwise from the db section of the config file in
+// CONFIG_FILE. Only the db settings are loaded and validated, the rest of the
+// API config does not need to be set.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"tiago-udemy/cmd/migrate/migrations"
+	"tiago-udemy/internal/config"
+	"tiago-udemy/internal/db"
+	"tiago-udemy/internal/migrate"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: migrate up | down N | goto V | version | force V | status")
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.LoadDB(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	conn, err := db.NewDBConnection(cfg.Addr, 2, 2, "15m")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	m, err := migrate.New(conn, migrations.FS)
+	if err != nil {
+		log.Fatal(err)
+	}
+	m.Log = log.Printf
+
+	if err := run(context.Background(), m, args); err != nil {
+		if errors.Is(err, migrate.ErrNoChange) {
+			log.Print("no change")
+			return
+		}
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, m *migrate.Migrator, args []string) error {
+	command, args := args[0], args[1:]
+
+	switch command {
+	case "up":
+		return m.Up(ctx)
+	case "down":
+		n, err := intArg(args)
+		if err != nil {
+			return err
+		}
+		return m.Down(ctx, n)
+	case "goto":
+		v, err := intArg(args)
+		if err != nil {
+			return err
+		}
+		return m.Goto(ctx, uint(v))
+	case "force":
+		v, err := intArg(args)
+		if err != nil {
+			return err
+		}
+		return m.Force(ctx, uint(v))
+	case "version":
+		v, dirty, err := m.Version(ctx)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			fmt.Printf("%d (dirty)\n", v)
+		} else {
+			fmt.Println(v)
+		}
+		return nil
+	case "status":
+		v, dirty, err := m.Version(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.Migrations() {
+			status := "pending"
+			switch {
+			case migration.Version == v && dirty:
+				status = "dirty"
+			case migration.Version <= v:
+				status = "applied"
+			}
+			fmt.Printf("%06d  %-8s %s\n", migration.Version, status, migration.Name)
+		}
+		return nil
+	default:
+		flag.Usage()
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+// intArg parses the single non-negative number a command takes
+func intArg(args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, errors.New("expected a single number argument")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a valid number", args[0])
+	}
+	return n, nil
+}
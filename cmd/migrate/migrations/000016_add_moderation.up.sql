@@ -0,0 +1,42 @@
+ALTER TABLE posts
+ADD COLUMN is_hidden BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE comments
+ADD COLUMN is_hidden BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE users
+ADD COLUMN is_suspended BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS reports (
+  id bigserial PRIMARY KEY,
+  reporter_id bigint NOT NULL,
+  target_type VARCHAR(20) NOT NULL,
+  target_id bigint NOT NULL,
+  reason text NOT NULL,
+  status VARCHAR(20) NOT NULL DEFAULT 'open',
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  resolved_at timestamp(0) with time zone,
+
+  FOREIGN KEY (reporter_id) REFERENCES users (id) ON DELETE CASCADE,
+  CHECK (target_type IN ('post', 'comment', 'user')),
+  CHECK (status IN ('open', 'dismissed', 'resolved'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_reports_status ON reports (status, created_at);
+
+CREATE TABLE IF NOT EXISTS moderation_actions (
+  id bigserial PRIMARY KEY,
+  report_id bigint NOT NULL,
+  moderator_id bigint NOT NULL,
+  action VARCHAR(20) NOT NULL,
+  target_type VARCHAR(20) NOT NULL,
+  target_id bigint NOT NULL,
+  target_user_id bigint,
+  note text NOT NULL DEFAULT '',
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+  FOREIGN KEY (report_id) REFERENCES reports (id) ON DELETE CASCADE,
+  FOREIGN KEY (moderator_id) REFERENCES users (id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_moderation_actions_target_user_id ON moderation_actions (target_user_id);
@@ -0,0 +1,60 @@
+CREATE TABLE IF NOT EXISTS permissions (
+  id BIGSERIAL PRIMARY KEY,
+  name VARCHAR(255) NOT NULL UNIQUE,
+  description TEXT
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+  role_id bigint NOT NULL,
+  permission_id bigint NOT NULL,
+
+  PRIMARY KEY (role_id, permission_id),
+  FOREIGN KEY (role_id) REFERENCES roles (id) ON DELETE CASCADE,
+  FOREIGN KEY (permission_id) REFERENCES permissions (id) ON DELETE CASCADE
+);
+
+INSERT INTO
+  permissions (name, description)
+VALUES
+  ('posts:read:any', 'Read posts of other users'),
+  ('posts:update:any', 'Update posts of other users'),
+  ('posts:delete:any', 'Delete posts of other users'),
+  ('comments:delete:any', 'Delete comments of other users'),
+  ('reports:review', 'Review reports and take moderation actions'),
+  ('users:manage', 'List users, change their role and account status'),
+  ('roles:manage', 'Create roles and change their permissions');
+
+-- carry over the previous level hierarchy: moderator (2) and above
+INSERT INTO
+  role_permissions (role_id, permission_id)
+SELECT
+  r.id,
+  p.id
+FROM
+  roles r,
+  permissions p
+WHERE
+  r.level >= 2
+  AND p.name IN (
+    'posts:read:any',
+    'posts:update:any',
+    'comments:delete:any',
+    'reports:review'
+  );
+
+-- admin (3) and above
+INSERT INTO
+  role_permissions (role_id, permission_id)
+SELECT
+  r.id,
+  p.id
+FROM
+  roles r,
+  permissions p
+WHERE
+  r.level >= 3
+  AND p.name IN (
+    'posts:delete:any',
+    'users:manage',
+    'roles:manage'
+  );
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS attachments (
+  id bigserial PRIMARY KEY,
+  -- NULL until the upload is attached to a post; unattached uploads are
+  -- garbage-collected
+  post_id bigint,
+  user_id bigint NOT NULL,
+  blob_key text NOT NULL,
+  thumbnail_key text NOT NULL,
+  mime_type VARCHAR(50) NOT NULL,
+  size bigint NOT NULL,
+  width int NOT NULL,
+  height int NOT NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+  FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE SET NULL,
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_attachments_post_id ON attachments (post_id);
+
+CREATE INDEX IF NOT EXISTS idx_attachments_orphans ON attachments (created_at) WHERE post_id IS NULL;
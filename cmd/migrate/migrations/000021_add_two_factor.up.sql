@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS user_two_factor (
+  user_id bigint PRIMARY KEY,
+  secret text NOT NULL,
+  enabled boolean NOT NULL DEFAULT false,
+  last_used_step bigint NOT NULL DEFAULT 0,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL,
+  code_hash bytea NOT NULL,
+  used_at timestamp(0) with time zone,
+
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes (user_id);
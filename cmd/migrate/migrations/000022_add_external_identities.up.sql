@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS external_identities (
+  id bigserial PRIMARY KEY,
+  user_id bigint NOT NULL,
+  provider text NOT NULL,
+  subject text NOT NULL,
+  email citext NOT NULL,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+  UNIQUE (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_external_identities_user_id ON external_identities (user_id);
+
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+  state bytea PRIMARY KEY,
+  provider text NOT NULL,
+  nonce text NOT NULL,
+  code_verifier text NOT NULL,
+  expiry timestamp(0) with time zone NOT NULL
+);
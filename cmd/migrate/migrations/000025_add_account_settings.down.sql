@@ -0,0 +1,25 @@
+DELETE FROM moderation_actions WHERE moderator_id IS NULL;
+
+ALTER TABLE moderation_actions
+ALTER COLUMN moderator_id SET NOT NULL,
+DROP CONSTRAINT moderation_actions_moderator_id_fkey,
+ADD CONSTRAINT moderation_actions_moderator_id_fkey FOREIGN KEY (moderator_id) REFERENCES users (id);
+
+ALTER TABLE comments
+DROP CONSTRAINT fk_post,
+ADD CONSTRAINT fk_post FOREIGN KEY (post_id) REFERENCES posts (id);
+
+ALTER TABLE comments
+DROP CONSTRAINT fk_user,
+ADD CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users (id);
+
+ALTER TABLE posts
+DROP CONSTRAINT fk_user,
+ADD CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users (id);
+
+DROP TABLE IF EXISTS email_changes;
+
+ALTER TABLE users
+DROP COLUMN avatar_url,
+DROP COLUMN bio,
+DROP COLUMN display_name;
@@ -0,0 +1,32 @@
+ALTER TABLE users
+ADD COLUMN display_name VARCHAR(100) NOT NULL DEFAULT '',
+ADD COLUMN bio text NOT NULL DEFAULT '',
+ADD COLUMN avatar_url text NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS email_changes (
+  token bytea PRIMARY KEY,
+  user_id bigint NOT NULL,
+  new_email citext NOT NULL,
+  expiry timestamp(0) with time zone NOT NULL,
+
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+-- deleting an account removes its posts and comments, moderation history
+-- keeps the action without the moderator
+ALTER TABLE posts
+DROP CONSTRAINT fk_user,
+ADD CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+
+ALTER TABLE comments
+DROP CONSTRAINT fk_user,
+ADD CONSTRAINT fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE;
+
+ALTER TABLE comments
+DROP CONSTRAINT fk_post,
+ADD CONSTRAINT fk_post FOREIGN KEY (post_id) REFERENCES posts (id) ON DELETE CASCADE;
+
+ALTER TABLE moderation_actions
+ALTER COLUMN moderator_id DROP NOT NULL,
+DROP CONSTRAINT moderation_actions_moderator_id_fkey,
+ADD CONSTRAINT moderation_actions_moderator_id_fkey FOREIGN KEY (moderator_id) REFERENCES users (id) ON DELETE SET NULL;
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS data_jobs (
+  id bigserial PRIMARY KEY,
+  user_id bigint,
+  kind VARCHAR(20) NOT NULL,
+  status VARCHAR(20) NOT NULL DEFAULT 'pending',
+  error text NOT NULL DEFAULT '',
+  -- export archive in the blob store, removed once downloaded or expired
+  archive_key text NOT NULL DEFAULT '',
+  download_token bytea UNIQUE,
+  download_expires_at timestamp(0) with time zone,
+  downloaded_at timestamp(0) with time zone,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  started_at timestamp(0) with time zone,
+  completed_at timestamp(0) with time zone,
+
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE SET NULL,
+  CHECK (kind IN ('export', 'erasure')),
+  CHECK (status IN ('pending', 'running', 'completed', 'failed'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_data_jobs_user_id ON data_jobs (user_id);
+
+CREATE INDEX IF NOT EXISTS idx_data_jobs_pending ON data_jobs (created_at) WHERE status = 'pending';
+
+-- one open job of each kind per user
+CREATE UNIQUE INDEX IF NOT EXISTS idx_data_jobs_open ON data_jobs (user_id, kind) WHERE status IN ('pending', 'running');
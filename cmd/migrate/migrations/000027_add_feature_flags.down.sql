@@ -0,0 +1,4 @@
+DELETE FROM permissions
+WHERE name = 'flags:manage';
+
+DROP TABLE IF EXISTS feature_flags;
@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS feature_flags (
+  key VARCHAR(100) PRIMARY KEY,
+  description TEXT NOT NULL DEFAULT '',
+  enabled BOOLEAN NOT NULL DEFAULT FALSE,
+  -- users outside the allowlist get the flag when their hashed ID falls below the percentage
+  rollout_percentage INT NOT NULL DEFAULT 0,
+  allowed_user_ids BIGINT[] NOT NULL DEFAULT '{}',
+  version INT NOT NULL DEFAULT 0,
+  created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+  updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+
+  CHECK (rollout_percentage BETWEEN 0 AND 100)
+);
+
+INSERT INTO
+  permissions (name, description)
+VALUES
+  ('flags:manage', 'Create feature flags and change their rollout');
+
+-- admin (3) and above
+INSERT INTO
+  role_permissions (role_id, permission_id)
+SELECT
+  r.id,
+  p.id
+FROM
+  roles r,
+  permissions p
+WHERE
+  r.level >= 3
+  AND p.name = 'flags:manage';
@@ -0,0 +1,6 @@
+UPDATE users
+SET is_active = false
+WHERE disabled_at IS NOT NULL;
+
+ALTER TABLE users
+DROP COLUMN disabled_at;
@@ -0,0 +1,16 @@
+ALTER TABLE users
+ADD COLUMN disabled_at timestamp(0) with time zone;
+
+-- admins used to deactivate accounts by clearing is_active. An inactive user
+-- without a pending invitation had confirmed their email, move them over.
+-- Erased accounts keep is_active = false.
+UPDATE users u
+SET
+  disabled_at = NOW(),
+  is_active = true
+WHERE
+  u.is_active = false
+  AND u.email NOT LIKE 'deleted-%@invalid'
+  AND NOT EXISTS (
+    SELECT 1 FROM user_invitation ui WHERE ui.user_id = u.id
+  );
@@ -0,0 +1,8 @@
+// Package migrations embeds the SQL migrations so the migrate command and the
+// API's schema check ship with the files they were built with.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
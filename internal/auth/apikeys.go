@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"crypto/rand"
+	"strings"
+)
+
+// API key scopes. A key can only reach routes that require one of its scopes,
+// access tokens are not restricted by scopes.
+const (
+	ScopePostsRead     = "posts:read"
+	ScopePostsWrite    = "posts:write"
+	ScopeCommentsWrite = "comments:write"
+	ScopeUsersRead     = "users:read"
+	ScopeUsersWrite    = "users:write"
+)
+
+const (
+	apiKeyTag = "gsk_"
+	// apiKeyPrefixLen is how much of the key is stored in clear, so users can
+	// tell their keys apart
+	apiKeyPrefixLen = len(apiKeyTag) + 8
+)
+
+var Scopes = []string{
+	ScopePostsRead,
+	ScopePostsWrite,
+	ScopeCommentsWrite,
+	ScopeUsersRead,
+	ScopeUsersWrite,
+}
+
+// GenerateAPIKey returns a new random key and its displayable prefix
+func GenerateAPIKey() (key string, prefix string) {
+	key = apiKeyTag + strings.ToLower(rand.Text())
+	return key, key[:apiKeyPrefixLen]
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"tiago-udemy/internal/auth/oidctest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDCProvider(t *testing.T) {
+	stub := oidctest.NewProvider(t, "gopher-client")
+	ctx := context.Background()
+
+	provider, err := NewOIDCProvider(ctx, OIDCConfig{
+		Name:         "stub",
+		Issuer:       stub.URL,
+		ClientID:     "gopher-client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/v1/authentication/oidc/stub/callback",
+	})
+	require.NoError(t, err)
+
+	identity := oidctest.Identity{Subject: "1234", Email: "gopher@example.com", EmailVerified: true, Name: "Gopher"}
+
+	t.Run("code exchange returns the verified identity", func(t *testing.T) {
+		state, nonce, verifier := GenerateOIDCVerifier(), GenerateOIDCVerifier(), GenerateOIDCVerifier()
+
+		callback, err := stub.Authorize(provider.AuthCodeURL(state, nonce, verifier), identity)
+		require.NoError(t, err)
+		assert.Equal(t, state, callback.Query().Get("state"))
+
+		got, err := provider.Exchange(ctx, callback.Query().Get("code"), nonce, verifier)
+		require.NoError(t, err)
+		assert.Equal(t, &ExternalIdentity{
+			Provider:      "stub",
+			Subject:       "1234",
+			Email:         "gopher@example.com",
+			EmailVerified: true,
+			Name:          "Gopher",
+		}, got)
+	})
+
+	t.Run("wrong PKCE verifier is rejected", func(t *testing.T) {
+		nonce := GenerateOIDCVerifier()
+		callback, err := stub.Authorize(provider.AuthCodeURL("state", nonce, GenerateOIDCVerifier()), identity)
+		require.NoError(t, err)
+
+		_, err = provider.Exchange(ctx, callback.Query().Get("code"), nonce, GenerateOIDCVerifier())
+		assert.Error(t, err)
+	})
+
+	t.Run("nonce from another login is rejected", func(t *testing.T) {
+		verifier := GenerateOIDCVerifier()
+		callback, err := stub.Authorize(provider.AuthCodeURL("state", GenerateOIDCVerifier(), verifier), identity)
+		require.NoError(t, err)
+
+		_, err = provider.Exchange(ctx, callback.Query().Get("code"), GenerateOIDCVerifier(), verifier)
+		assert.ErrorIs(t, err, ErrNonceMismatch)
+	})
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"context"
+	"sync"
+)
+
+// Permission names checked by the API
+const (
+	PermissionPostsReadAny      = "posts:read:any"
+	PermissionPostsUpdateAny    = "posts:update:any"
+	PermissionPostsDeleteAny    = "posts:delete:any"
+	PermissionCommentsDeleteAny = "comments:delete:any"
+	PermissionReportsReview     = "reports:review"
+	PermissionUsersManage       = "users:manage"
+	PermissionRolesManage       = "roles:manage"
+	PermissionFlagsManage       = "flags:manage"
+)
+
+// PermissionLoader returns the permission names granted to each role, keyed by role ID
+type PermissionLoader func(ctx context.Context) (map[int64][]string, error)
+
+// PermissionCache keeps an in-memory snapshot of the role to permission
+// mapping so authorization checks do not hit the database.
+type PermissionCache struct {
+	sync.RWMutex
+	load  PermissionLoader
+	roles map[int64]map[string]struct{}
+}
+
+func NewPermissionCache(load PermissionLoader) *PermissionCache {
+	return &PermissionCache{
+		load:  load,
+		roles: make(map[int64]map[string]struct{}),
+	}
+}
+
+// Refresh reloads the snapshot. On error the previous snapshot is kept.
+func (c *PermissionCache) Refresh(ctx context.Context) error {
+	permissions, err := c.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	roles := make(map[int64]map[string]struct{}, len(permissions))
+	for roleID, names := range permissions {
+		set := make(map[string]struct{}, len(names))
+		for _, name := range names {
+			set[name] = struct{}{}
+		}
+		roles[roleID] = set
+	}
+
+	c.Lock()
+	c.roles = roles
+	c.Unlock()
+
+	return nil
+}
+
+// Can reports whether the role has been granted the permission
+func (c *PermissionCache) Can(roleID int64, permission string) bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	_, ok := c.roles[roleID][permission]
+	return ok
+}
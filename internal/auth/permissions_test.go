@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPermissionCache(t *testing.T) {
+	snapshot := map[int64][]string{
+		2: {PermissionPostsUpdateAny, PermissionReportsReview},
+		3: {PermissionPostsUpdateAny, PermissionUsersManage},
+	}
+	var loadErr error
+
+	c := NewPermissionCache(func(ctx context.Context) (map[int64][]string, error) {
+		return snapshot, loadErr
+	})
+
+	assert.False(t, c.Can(2, PermissionPostsUpdateAny), "empty cache should deny")
+
+	require.NoError(t, c.Refresh(context.Background()))
+
+	assert.True(t, c.Can(2, PermissionReportsReview))
+	assert.True(t, c.Can(3, PermissionUsersManage))
+	assert.False(t, c.Can(2, PermissionUsersManage), "permissions are not hierarchical")
+	assert.False(t, c.Can(1, PermissionPostsUpdateAny), "unknown role should deny")
+
+	t.Run("failed refresh keeps previous snapshot", func(t *testing.T) {
+		loadErr = errors.New("db down")
+		snapshot = nil
+
+		assert.Error(t, c.Refresh(context.Background()))
+		assert.True(t, c.Can(2, PermissionReportsReview))
+	})
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateTOTP(t *testing.T) {
+	// RFC 6238 appendix B test secret, truncated to 6 digits
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		name     string
+		code     string
+		at       time.Time
+		wantOK   bool
+		wantStep int64
+	}{
+		{name: "rfc vector at 59s", code: "287082", at: time.Unix(59, 0), wantOK: true, wantStep: 1},
+		{name: "rfc vector at 1111111109s", code: "081804", at: time.Unix(1111111109, 0), wantOK: true, wantStep: 37037036},
+		{name: "previous step is accepted", code: "287082", at: time.Unix(89, 0), wantOK: true, wantStep: 1},
+		{name: "two steps late is rejected", code: "287082", at: time.Unix(125, 0)},
+		{name: "wrong code", code: "123456", at: time.Unix(59, 0)},
+		{name: "wrong length", code: "28708", at: time.Unix(59, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(secret, tt.code, tt.at)
+			if ok != tt.wantOK {
+				t.Fatalf("ValidateTOTP() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && step != tt.wantStep {
+				t.Errorf("ValidateTOTP() step = %d, want %d", step, tt.wantStep)
+			}
+		})
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("JBSWY3DPEHPK3PXP", "GopherSocial", "alice@example.com")
+
+	if !strings.HasPrefix(uri, "otpauth://totp/GopherSocial:alice@example.com?") {
+		t.Errorf("unexpected label in %q", uri)
+	}
+	for _, param := range []string{"secret=JBSWY3DPEHPK3PXP", "issuer=GopherSocial", "digits=6", "period=30"} {
+		if !strings.Contains(uri, param) {
+			t.Errorf("%q is missing %s", uri, param)
+		}
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("malformed recovery code %q", code)
+		}
+		if seen[code] {
+			t.Errorf("duplicate recovery code %q", code)
+		}
+		seen[code] = true
+	}
+}
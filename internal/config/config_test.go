@@ -0,0 +1,189 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("defaults are valid", func(t *testing.T) {
+		cfg, warnings, err := Load("")
+		require.NoError(t, err)
+		assert.Empty(t, warnings)
+		assert.Equal(t, Default(), *cfg)
+	})
+
+	t.Run("env overrides the file", func(t *testing.T) {
+		path := writeFile(t, "config.yaml", `
+db:
+  max_open_conns: 50
+  max_idle_conns: 10
+rate_limit:
+  login:
+    window: 2m
+    requests: 3
+`)
+		t.Setenv("DB_MAX_IDLE_CONNS", "20")
+		t.Setenv("RATE_LIMIT_DEFAULT_REQUESTS", "500")
+
+		cfg, _, err := Load(path)
+		require.NoError(t, err)
+		assert.Equal(t, 50, cfg.DB.MaxOpenConns)
+		assert.Equal(t, 20, cfg.DB.MaxIdleConns)
+		assert.Equal(t, RateLimitPolicy{Window: 2 * time.Minute, Requests: 3}, cfg.RateLimit.Login)
+		assert.Equal(t, RateLimitPolicy{Window: time.Hour, Requests: 500}, cfg.RateLimit.Default)
+	})
+
+	t.Run("toml file", func(t *testing.T) {
+		path := writeFile(t, "config.toml", `
+env = "staging"
+
+[cache]
+enabled = true
+local_ttl = "1m"
+`)
+		cfg, _, err := Load(path)
+		require.NoError(t, err)
+		assert.Equal(t, EnvStaging, cfg.Env)
+		assert.True(t, cfg.Cache.Enabled)
+		assert.Equal(t, time.Minute, cfg.Cache.LocalTTL)
+	})
+
+	t.Run("unknown file keys are rejected", func(t *testing.T) {
+		path := writeFile(t, "config.yaml", "db:\n  max_open_conn: 50\n")
+		_, _, err := Load(path)
+		assert.ErrorContains(t, err, "max_open_conn")
+	})
+
+	t.Run("invalid env values fail", func(t *testing.T) {
+		t.Setenv("DB_MAX_OPEN_CONNS", "lots")
+		t.Setenv("CACHE_LOCAL_TTL", "soon")
+
+		_, _, err := Load("")
+		assert.ErrorContains(t, err, "DB_MAX_OPEN_CONNS")
+		assert.ErrorContains(t, err, "CACHE_LOCAL_TTL")
+	})
+
+	t.Run("invalid values fail validation", func(t *testing.T) {
+		t.Setenv("RATE_LIMITER_STRATEGY", "leaky")
+		t.Setenv("DB_MAX_IDLE_CONNS", "100")
+
+		_, _, err := Load("")
+		assert.ErrorContains(t, err, "rate_limit.strategy")
+		assert.ErrorContains(t, err, "db.max_idle_conns")
+	})
+
+	t.Run("deprecated names warn", func(t *testing.T) {
+		t.Setenv("REDDIS_ADDR", "redis:6379")
+
+		cfg, warnings, err := Load("")
+		require.NoError(t, err)
+		assert.Equal(t, "redis:6379", cfg.Redis.Addr)
+		assert.Equal(t, []string{"REDDIS_ADDR is deprecated, use REDIS_ADDR"}, warnings)
+	})
+
+	t.Run("secrets from files", func(t *testing.T) {
+		t.Setenv("JWT_SECRET_FILE", writeFile(t, "jwt", "from-a-file\n"))
+
+		cfg, _, err := Load("")
+		require.NoError(t, err)
+		assert.Equal(t, "from-a-file", cfg.Auth.JWTSecret)
+	})
+
+	t.Run("oidc providers", func(t *testing.T) {
+		t.Setenv("OIDC_PROVIDERS", "google")
+		t.Setenv("OIDC_GOOGLE_ISSUER", "https://accounts.google.com")
+		t.Setenv("OIDC_GOOGLE_CLIENT_ID", "id")
+		t.Setenv("OIDC_GOOGLE_CLIENT_SECRET", "secret")
+		t.Setenv("OIDC_GOOGLE_SCOPES", "email profile")
+
+		cfg, _, err := Load("")
+		require.NoError(t, err)
+		assert.Equal(t, []OIDCProvider{{
+			Name:         "google",
+			Issuer:       "https://accounts.google.com",
+			ClientID:     "id",
+			ClientSecret: "secret",
+			Scopes:       []string{"email", "profile"},
+		}}, cfg.OIDC.Providers)
+	})
+}
+
+func TestValidateProduction(t *testing.T) {
+	cfg := Default()
+	cfg.Env = EnvProduction
+
+	err := cfg.Validate()
+	assert.ErrorContains(t, err, "set JWT_SECRET")
+	assert.ErrorContains(t, err, "set BASIC_USERNAME")
+	assert.ErrorContains(t, err, "set BASIC_PASSWORD")
+	assert.ErrorContains(t, err, "set DB_ADDR")
+	assert.ErrorContains(t, err, "auth.jwt_secret: must be at least")
+
+	cfg.DB.Addr = "postgres://app@db/social"
+	cfg.Auth.BasicUsername = "operator"
+	cfg.Auth.BasicPassword = "a-long-operator-password"
+	cfg.Auth.JWTSecret = "0123456789abcdef0123456789abcdef"
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestLoadDB(t *testing.T) {
+	t.Run("ignores the rest of the config", func(t *testing.T) {
+		path := writeFile(t, "config.yaml", `
+env: production
+db:
+  max_open_conns: 5
+  max_idle_conns: 5
+`)
+		t.Setenv("DB_ADDR_FILE", writeFile(t, "db", "postgres://app@db/social\n"))
+		t.Setenv("REDIS_ADDR", "not a host")
+
+		cfg, err := LoadDB(path)
+		require.NoError(t, err)
+		assert.Equal(t, "postgres://app@db/social", cfg.Addr)
+		assert.Equal(t, 5, cfg.MaxOpenConns)
+	})
+
+	t.Run("validates the db section", func(t *testing.T) {
+		t.Setenv("DB_ADDR", "")
+		t.Setenv("DB_MAX_OPEN_CONNS", "0")
+
+		_, err := LoadDB("")
+		assert.ErrorContains(t, err, "db.addr")
+		assert.ErrorContains(t, err, "db.max_open_conns")
+	})
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	cfg := Default()
+	cfg.OIDC.Providers = []OIDCProvider{{Name: "google", Issuer: "https://accounts.google.com", ClientID: "id", ClientSecret: "oidc-secret"}}
+
+	var buf bytes.Buffer
+	require.NoError(t, cfg.Print(&buf))
+
+	out := buf.String()
+	assert.NotContains(t, out, "tiago\n")
+	assert.NotContains(t, out, "adminpassword")
+	assert.NotContains(t, out, "oidc-secret")
+	assert.Contains(t, out, "jwt_secret: '[REDACTED]'")
+	assert.Contains(t, out, "max_idle_time: 15m0s")
+	assert.Equal(t, "oidc-secret", cfg.OIDC.Providers[0].ClientSecret, "the config itself is not modified")
+
+	// the printed config loads back
+	path := writeFile(t, "config.yaml", out)
+	_, _, err := Load(path)
+	require.NoError(t, err)
+}
@@ -0,0 +1,262 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Load returns the defaults overridden by the file at path, when not empty,
+// and then by the environment. The returned warnings name deprecated
+// variables that are still in use.
+func Load(path string) (*Config, []string, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	warnings, err := loadEnv(&cfg)
+	if err != nil {
+		return nil, warnings, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, warnings, err
+	}
+
+	return &cfg, warnings, nil
+}
+
+// LoadDB is Load for tools that only talk to the database, such as the
+// migrations and the seeder. It reads the db section of the file and the DB_*
+// variables and validates only those, so the API's secrets need not be set.
+func LoadDB(path string) (*DBConfig, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	var warnings []string
+	var errs []error
+	setFields(reflect.ValueOf(&cfg.DB).Elem(), "", &warnings, &errs)
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.DB.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg.DB, nil
+}
+
+// loadFile decodes a YAML or TOML file by its extension. Unknown keys are
+// rejected so a typo does not silently keep the default.
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+	case ".toml":
+		// TOML is decoded through YAML to share the yaml tags and the strict decoder
+		var doc map[string]any
+		if _, err := toml.Decode(string(data), &doc); err != nil {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+		if data, err = yaml.Marshal(doc); err != nil {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("unsupported config file extension %q, use .yaml, .yml or .toml", ext)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func loadEnv(cfg *Config) ([]string, error) {
+	var warnings []string
+	var errs []error
+
+	setFields(reflect.ValueOf(cfg).Elem(), "", &warnings, &errs)
+
+	// providers are listed in OIDC_PROVIDERS and read from OIDC_<NAME>_*,
+	// providers from the file are kept when listed
+	names, _, ok, err := lookup([]string{"OIDC_PROVIDERS"})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if ok {
+		var providers []OIDCProvider
+		for _, name := range splitList(names) {
+			p := OIDCProvider{Name: name}
+			for _, existing := range cfg.OIDC.Providers {
+				if existing.Name == name {
+					p = existing
+				}
+			}
+			providers = append(providers, p)
+		}
+		cfg.OIDC.Providers = providers
+	}
+	for i := range cfg.OIDC.Providers {
+		prefix := "OIDC_" + strings.ToUpper(cfg.OIDC.Providers[i].Name) + "_"
+		setFields(reflect.ValueOf(&cfg.OIDC.Providers[i]).Elem(), prefix, &warnings, &errs)
+	}
+
+	return warnings, errors.Join(errs...)
+}
+
+// setFields overrides the fields of v that have an env tag. A struct field
+// with an env tag prefixes the variables of its own fields.
+func setFields(v reflect.Value, prefix string, warnings *[]string, errs *[]error) {
+	t := v.Type()
+	for i := range t.NumField() {
+		field, value := t.Field(i), v.Field(i)
+		tag := field.Tag.Get("env")
+
+		if value.Kind() == reflect.Struct && value.Type() != durationType {
+			if tag != "" {
+				setFields(value, prefix+tag+"_", warnings, errs)
+			} else {
+				setFields(value, prefix, warnings, errs)
+			}
+			continue
+		}
+		if tag == "" {
+			continue
+		}
+
+		names := strings.Split(tag, ",")
+		for j := range names {
+			names[j] = prefix + names[j]
+		}
+
+		raw, name, ok, err := lookup(names)
+		if err != nil {
+			*errs = append(*errs, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if name != names[0] {
+			*warnings = append(*warnings, fmt.Sprintf("%s is deprecated, use %s", name, names[0]))
+		}
+
+		if err := setValue(value, raw); err != nil {
+			*errs = append(*errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+}
+
+// lookup returns the first of names that is set, either directly or as a path
+// in <name>_FILE. Values read from files have trailing newlines removed.
+func lookup(names []string) (value, name string, ok bool, err error) {
+	for _, name := range names {
+		if value, ok := os.LookupEnv(name); ok {
+			return value, name, true, nil
+		}
+
+		if path, ok := os.LookupEnv(name + "_FILE"); ok {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return "", name, false, fmt.Errorf("%s_FILE: %w", name, err)
+			}
+			return strings.TrimRight(string(data), "\r\n"), name, true, nil
+		}
+	}
+
+	return "", "", false, nil
+}
+
+func setValue(v reflect.Value, raw string) error {
+	// an empty variable only clears strings, other kinds keep their value
+	if strings.TrimSpace(raw) == "" && v.Kind() != reflect.String {
+		return nil
+	}
+
+	if v.Type() == durationType {
+		d, err := parseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(raw), 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		v.Set(reflect.ValueOf(splitList(raw)))
+	default:
+		return fmt.Errorf("unsupported kind %s", v.Kind())
+	}
+
+	return nil
+}
+
+// parseDuration accepts Go durations and, for compatibility, a bare number of days
+func parseDuration(raw string) (time.Duration, error) {
+	raw = strings.TrimSpace(raw)
+	if d, err := time.ParseDuration(raw); err == nil {
+		return d, nil
+	}
+	if n, err := strconv.Atoi(raw); err == nil {
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return 0, fmt.Errorf("%q is not a duration", raw)
+}
+
+// splitList splits on commas and whitespace
+func splitList(raw string) []string {
+	return strings.FieldsFunc(raw, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"io"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redactedValue = "[REDACTED]"
+
+// Print writes c as YAML, in the format read by Load, with secrets redacted
+func (c *Config) Print(w io.Writer) error {
+	redacted := *c
+	redact(reflect.ValueOf(&redacted).Elem())
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(redacted); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// redact replaces non-empty secret strings. Slices are copied first since
+// they still share their backing array with the original.
+func redact(v reflect.Value) {
+	t := v.Type()
+	for i := range t.NumField() {
+		field, value := t.Field(i), v.Field(i)
+
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			redact(value)
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct:
+			copied := reflect.MakeSlice(field.Type, value.Len(), value.Len())
+			reflect.Copy(copied, value)
+			for j := range copied.Len() {
+				redact(copied.Index(j))
+			}
+			value.Set(copied)
+		case field.Tag.Get("secret") == "true" && value.String() != "":
+			value.SetString(redactedValue)
+		}
+	}
+}
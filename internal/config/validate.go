@@ -0,0 +1,101 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// minProductionSecretLen is the shortest JWT secret accepted in production
+const minProductionSecretLen = 32
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	// report fields by their file key, e.g. "db.max_idle_conns"
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return v
+}
+
+// Validate checks every field and, in production, refuses secrets that still
+// have their development default
+func (c *Config) Validate() error {
+	errs, err := validateStruct(c, "")
+	if err != nil {
+		return err
+	}
+
+	if c.Env == EnvProduction {
+		def := Default()
+		checkSecrets(reflect.ValueOf(*c), reflect.ValueOf(def), "", &errs)
+
+		if len(c.Auth.JWTSecret) < minProductionSecretLen {
+			errs = append(errs, fmt.Errorf("auth.jwt_secret: must be at least %d characters in production", minProductionSecretLen))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Validate checks the database settings only, for tools that need nothing else
+func (c *DBConfig) Validate() error {
+	errs, err := validateStruct(c, "db.")
+	if err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// validateStruct returns one error per failed rule, naming the field by its
+// file key. prefix is the key of v in the file.
+func validateStruct(v any, prefix string) ([]error, error) {
+	err := validate.Struct(v)
+	if err == nil {
+		return nil, nil
+	}
+
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return nil, err
+	}
+
+	var errs []error
+	for _, fe := range validationErrs {
+		_, path, _ := strings.Cut(fe.Namespace(), ".")
+		rule := fe.Tag()
+		if fe.Param() != "" {
+			rule += "=" + fe.Param()
+		}
+		errs = append(errs, fmt.Errorf("%s%s: %v does not satisfy %q", prefix, path, fe.Value(), rule))
+	}
+	return errs, nil
+}
+
+// checkSecrets reports secret fields of v equal to their non-empty default
+func checkSecrets(v, def reflect.Value, path string, errs *[]error) {
+	t := v.Type()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		key := path + field.Tag.Get("yaml")
+
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			checkSecrets(v.Field(i), def.Field(i), key+".", errs)
+		case field.Tag.Get("secret") == "true" && field.Type.Kind() == reflect.String:
+			if val := v.Field(i).String(); val != "" && val == def.Field(i).String() {
+				env, _, _ := strings.Cut(field.Tag.Get("env"), ",")
+				*errs = append(*errs, fmt.Errorf("%s: the development default must not be used in production, set %s", key, env))
+			}
+		}
+	}
+}
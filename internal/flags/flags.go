@@ -0,0 +1,127 @@
+// Package flags evaluates feature flags against an in-memory snapshot of the
+// definitions stored in Postgres.
+package flags
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+	"sync"
+
+	"tiago-udemy/internal/store"
+)
+
+// Loader returns every flag definition
+type Loader func(ctx context.Context) ([]store.FeatureFlag, error)
+
+type flag struct {
+	enabled    bool
+	percentage int
+	users      map[int64]struct{}
+}
+
+// Set keeps a snapshot of the flag definitions so evaluation does not hit the
+// database. Unknown flags are off.
+type Set struct {
+	sync.RWMutex
+	load  Loader
+	flags map[string]flag
+}
+
+func New(load Loader) *Set {
+	return &Set{
+		load:  load,
+		flags: make(map[string]flag),
+	}
+}
+
+// Refresh reloads the snapshot. On error the previous snapshot is kept.
+func (s *Set) Refresh(ctx context.Context) error {
+	definitions, err := s.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	flags := make(map[string]flag, len(definitions))
+	for _, d := range definitions {
+		users := make(map[int64]struct{}, len(d.AllowedUserIDs))
+		for _, id := range d.AllowedUserIDs {
+			users[id] = struct{}{}
+		}
+		flags[d.Key] = flag{enabled: d.Enabled, percentage: d.RolloutPercentage, users: users}
+	}
+
+	s.Lock()
+	s.flags = flags
+	s.Unlock()
+
+	return nil
+}
+
+// Enabled reports whether the flag is on for the user. A disabled flag is off
+// for everyone, otherwise it is on for allowlisted users and for the rollout
+// percentage of the others. Anonymous requests, user ID 0, only get flags
+// rolled out to 100%.
+func (s *Set) Enabled(key string, userID int64) bool {
+	s.RLock()
+	f, ok := s.flags[key]
+	s.RUnlock()
+
+	if !ok || !f.enabled {
+		return false
+	}
+	if _, ok := f.users[userID]; ok {
+		return true
+	}
+	if userID == 0 {
+		return f.percentage >= 100
+	}
+	return bucket(key, userID) < f.percentage
+}
+
+// bucket places the user in one of 100 buckets. The key is part of the hash
+// so each flag rolls out to a different sample of users, and raising the
+// percentage only ever adds users.
+func bucket(key string, userID int64) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	h.Write([]byte{':'})
+	h.Write([]byte(strconv.FormatInt(userID, 10)))
+	return int(h.Sum32() % 100)
+}
+
+type contextKey string
+
+const evaluatorCtx contextKey = "flags"
+
+type evaluator struct {
+	set    *Set
+	userID int64
+}
+
+// NewContext returns a context in which Enabled evaluates flags from s for an
+// anonymous user
+func NewContext(ctx context.Context, s *Set) context.Context {
+	return context.WithValue(ctx, evaluatorCtx, evaluator{set: s})
+}
+
+// WithUser evaluates flags in the returned context for the user. It returns
+// ctx as is when it carries no Set.
+func WithUser(ctx context.Context, userID int64) context.Context {
+	e, ok := ctx.Value(evaluatorCtx).(evaluator)
+	if !ok {
+		return ctx
+	}
+	e.userID = userID
+	return context.WithValue(ctx, evaluatorCtx, e)
+}
+
+// Enabled evaluates the flag for the user of ctx, it is off when ctx carries
+// no Set
+func Enabled(ctx context.Context, key string) bool {
+	e, ok := ctx.Value(evaluatorCtx).(evaluator)
+	if !ok || e.set == nil {
+		return false
+	}
+	return e.set.Enabled(key, e.userID)
+}
@@ -0,0 +1,110 @@
+package flags
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"tiago-udemy/internal/store"
+)
+
+func newTestSet(t *testing.T, definitions ...store.FeatureFlag) *Set {
+	t.Helper()
+	s := New(func(ctx context.Context) ([]store.FeatureFlag, error) {
+		return definitions, nil
+	})
+	if err := s.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestEnabled(t *testing.T) {
+	s := newTestSet(t,
+		store.FeatureFlag{Key: "off", Enabled: false, RolloutPercentage: 100, AllowedUserIDs: []int64{1}},
+		store.FeatureFlag{Key: "everyone", Enabled: true, RolloutPercentage: 100},
+		store.FeatureFlag{Key: "allowlist", Enabled: true, AllowedUserIDs: []int64{7}},
+	)
+
+	tests := []struct {
+		key    string
+		userID int64
+		want   bool
+	}{
+		{"off", 1, false},
+		{"unknown", 1, false},
+		{"everyone", 1, true},
+		{"everyone", 0, true},
+		{"allowlist", 7, true},
+		{"allowlist", 8, false},
+		{"allowlist", 0, false},
+	}
+
+	for _, tt := range tests {
+		if got := s.Enabled(tt.key, tt.userID); got != tt.want {
+			t.Errorf("Enabled(%q, %d) = %v, want %v", tt.key, tt.userID, got, tt.want)
+		}
+	}
+}
+
+func TestPercentageRollout(t *testing.T) {
+	const users = 10000
+	count := func(s *Set) (enabled map[int64]bool) {
+		enabled = make(map[int64]bool)
+		for id := int64(1); id <= users; id++ {
+			if s.Enabled("new-feed", id) {
+				enabled[id] = true
+			}
+		}
+		return enabled
+	}
+
+	ten := count(newTestSet(t, store.FeatureFlag{Key: "new-feed", Enabled: true, RolloutPercentage: 10}))
+	if n := len(ten); n < users*8/100 || n > users*12/100 {
+		t.Errorf("want about 10%% of users, got %d of %d", n, users)
+	}
+
+	fifty := count(newTestSet(t, store.FeatureFlag{Key: "new-feed", Enabled: true, RolloutPercentage: 50}))
+	for id := range ten {
+		if !fifty[id] {
+			t.Fatalf("user %d lost the flag when the rollout grew", id)
+		}
+	}
+}
+
+func TestRefreshKeepsSnapshotOnError(t *testing.T) {
+	fail := false
+	s := New(func(ctx context.Context) ([]store.FeatureFlag, error) {
+		if fail {
+			return nil, errors.New("database down")
+		}
+		return []store.FeatureFlag{{Key: "everyone", Enabled: true, RolloutPercentage: 100}}, nil
+	})
+	if err := s.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	fail = true
+	if err := s.Refresh(context.Background()); err == nil {
+		t.Fatal("want the load error returned")
+	}
+	if !s.Enabled("everyone", 1) {
+		t.Error("want the previous snapshot kept")
+	}
+}
+
+func TestContext(t *testing.T) {
+	s := newTestSet(t, store.FeatureFlag{Key: "allowlist", Enabled: true, AllowedUserIDs: []int64{7}})
+
+	if Enabled(context.Background(), "allowlist") {
+		t.Error("want flags off without a Set in the context")
+	}
+
+	ctx := NewContext(context.Background(), s)
+	if Enabled(ctx, "allowlist") {
+		t.Error("want the allowlist flag off for anonymous requests")
+	}
+	if !Enabled(WithUser(ctx, 7), "allowlist") {
+		t.Error("want the allowlist flag on for user 7")
+	}
+}
@@ -0,0 +1,58 @@
+package mailer
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("tiago-udemy/internal/mailer")
+
+var (
+	sends = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mail_send_total",
+		Help: "Emails sent by template and outcome.",
+	}, []string{"template", "outcome"})
+
+	sendDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mail_send_duration_seconds",
+		Help:    "Time taken to send an email, by template.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"template"})
+)
+
+// instrumentedClient records the outcome and a span for every send of the
+// client it wraps
+type instrumentedClient struct {
+	MailClient
+}
+
+// Instrument counts sent and failed emails of client per template
+func Instrument(client MailClient) MailClient {
+	return instrumentedClient{client}
+}
+
+func (c instrumentedClient) Send(ctx context.Context, templateFile, email string, data any) (int, error) {
+	ctx, span := tracer.Start(ctx, "mailer.Send", trace.WithAttributes(attribute.String("mail.template", templateFile)))
+	defer span.End()
+
+	start := time.Now()
+	status, err := c.MailClient.Send(ctx, templateFile, email, data)
+	sendDuration.WithLabelValues(templateFile).Observe(time.Since(start).Seconds())
+
+	outcome := "sent"
+	if err != nil {
+		outcome = "failed"
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	sends.WithLabelValues(templateFile, outcome).Inc()
+
+	return status, err
+}
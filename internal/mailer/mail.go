@@ -6,9 +6,15 @@ import (
 )
 
 const (
-	FromName            = "GopherSocial"
-	maxRetires          = 3
-	UserWelcomeTemplate = "user_invitation.tmpl"
+	FromName              = "GopherSocial"
+	maxRetires            = 3
+	UserWelcomeTemplate   = "user_invitation.tmpl"
+	PasswordResetTemplate = "password_reset.tmpl"
+	AccountLockedTemplate = "account_locked.tmpl"
+	EmailChangeTemplate   = "email_change.tmpl"
+	EmailInUseTemplate    = "email_in_use.tmpl"
+	DataExportTemplate    = "data_export.tmpl"
+	AccountWarnedTemplate = "account_warned.tmpl"
 )
 
 //go:embed "templates"
@@ -9,6 +9,11 @@ import (
 	gomail "gopkg.in/mail.v2"
 )
 
+const (
+	mailtrapHost = "live.smtp.mailtrap.io"
+	mailtrapPort = 587
+)
+
 type mailtrapClient struct {
 	fromEmail string
 	apiKey    string
@@ -53,7 +58,7 @@ func (m *mailtrapClient) Send(ctx context.Context, templateFile, email string, d
 
 	message.AddAlternative("text/html", body.String())
 
-	dialer := gomail.NewDialer("live.smtp.mailtrap.io", 587, "api", m.apiKey)
+	dialer := gomail.NewDialer(mailtrapHost, mailtrapPort, "api", m.apiKey)
 
 	if err := dialer.DialAndSend(message); err != nil {
 		return -1, err
@@ -61,3 +66,12 @@ func (m *mailtrapClient) Send(ctx context.Context, templateFile, email string, d
 
 	return 200, nil
 }
+
+// Ping checks that the SMTP server is reachable and accepts the api key
+func (m *mailtrapClient) Ping(ctx context.Context) error {
+	conn, err := gomail.NewDialer(mailtrapHost, mailtrapPort, "api", m.apiKey).Dial()
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
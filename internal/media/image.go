@@ -0,0 +1,127 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	MimeJPEG = "image/jpeg"
+	MimePNG  = "image/png"
+
+	// maxPixels guards against decompression bombs: small files that decode
+	// into huge bitmaps
+	maxPixels   = 40_000_000
+	jpegQuality = 85
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported image type, only jpeg and png are accepted")
+	ErrTooLarge        = errors.New("image is too large")
+)
+
+type Image struct {
+	MimeType  string
+	Width     int
+	Height    int
+	Data      []byte
+	Thumbnail []byte
+}
+
+// Process validates an uploaded image and re-encodes it. Re-encoding drops
+// every metadata block of the original file, EXIF included. The thumbnail
+// fits within thumbSize x thumbSize and keeps the aspect ratio.
+func Process(r io.Reader, maxBytes int64, thumbSize int) (*Image, error) {
+	raw, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(raw)) > maxBytes {
+		return nil, ErrTooLarge
+	}
+
+	mimeType := http.DetectContentType(raw)
+	if mimeType != MimeJPEG && mimeType != MimePNG {
+		return nil, ErrUnsupportedType
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedType, err)
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedType, err)
+	}
+
+	data, err := encode(src, mimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	thumbnail, err := encode(resize(src, thumbSize), mimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	return &Image{
+		MimeType:  mimeType,
+		Width:     bounds.Dx(),
+		Height:    bounds.Dy(),
+		Data:      data,
+		Thumbnail: thumbnail,
+	}, nil
+}
+
+func encode(img image.Image, mimeType string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	var err error
+	switch mimeType {
+	case MimeJPEG:
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: jpegQuality})
+	case MimePNG:
+		err = png.Encode(buf, img)
+	default:
+		err = ErrUnsupportedType
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// resize scales img down to fit within size x size. Smaller images are kept as is.
+func resize(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+
+	if w >= h {
+		h = max(1, h*size/w)
+		w = size
+	} else {
+		w = max(1, w*size/h)
+		h = size
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	return dst
+}
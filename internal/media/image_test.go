@@ -0,0 +1,83 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testImage(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 100, 255})
+		}
+	}
+	return img
+}
+
+// withExif inserts an APP1 Exif segment right after the JPEG SOI marker
+func withExif(t *testing.T, jpg []byte) []byte {
+	t.Helper()
+	payload := []byte("Exif\x00\x00GPS-SECRET")
+	segment := []byte{0xFF, 0xE1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}
+	segment = append(segment, payload...)
+
+	out := append([]byte{}, jpg[:2]...)
+	out = append(out, segment...)
+	return append(out, jpg[2:]...)
+}
+
+func TestProcess(t *testing.T) {
+	t.Run("jpeg is re-encoded without exif and thumbnailed", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		require.NoError(t, jpeg.Encode(buf, testImage(800, 400), nil))
+		raw := withExif(t, buf.Bytes())
+		require.Contains(t, string(raw), "GPS-SECRET")
+
+		img, err := Process(bytes.NewReader(raw), 1<<20, 320)
+		require.NoError(t, err)
+
+		assert.Equal(t, MimeJPEG, img.MimeType)
+		assert.Equal(t, 800, img.Width)
+		assert.Equal(t, 400, img.Height)
+		assert.NotContains(t, string(img.Data), "GPS-SECRET")
+
+		thumb, err := jpeg.DecodeConfig(bytes.NewReader(img.Thumbnail))
+		require.NoError(t, err)
+		assert.Equal(t, 320, thumb.Width)
+		assert.Equal(t, 160, thumb.Height)
+	})
+
+	t.Run("small png keeps its size", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		require.NoError(t, png.Encode(buf, testImage(100, 50)))
+
+		img, err := Process(buf, 1<<20, 320)
+		require.NoError(t, err)
+
+		thumb, err := png.DecodeConfig(bytes.NewReader(img.Thumbnail))
+		require.NoError(t, err)
+		assert.Equal(t, MimePNG, img.MimeType)
+		assert.Equal(t, 100, thumb.Width)
+	})
+
+	t.Run("file over the size limit", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		require.NoError(t, png.Encode(buf, testImage(100, 100)))
+
+		_, err := Process(buf, 10, 320)
+		assert.ErrorIs(t, err, ErrTooLarge)
+	})
+
+	t.Run("not an image", func(t *testing.T) {
+		_, err := Process(bytes.NewReader([]byte("<html>hello</html>")), 1<<20, 320)
+		assert.ErrorIs(t, err, ErrUnsupportedType)
+	})
+}
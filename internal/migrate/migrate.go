@@ -0,0 +1,368 @@
+// Package migrate applies the numbered SQL migrations of an fs.FS to
+// Postgres. Files are named <version>_<name>.up.sql and .down.sql and the
+// applied version is kept in schema_migrations, in the same layout as the
+// golang-migrate CLI, so databases migrated with either tool stay compatible.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+// lockKey identifies the advisory lock held while migrating
+const lockKey int64 = 0x7469_6167_6f6d_6967 // "tiagomig"
+
+var (
+	ErrDirty        = errors.New("the last migration failed, fix the schema by hand and force a version")
+	ErrNoChange     = errors.New("no change")
+	ErrUnknown      = errors.New("unknown migration version")
+	ErrSchemaBehind = errors.New("the database schema is behind the migrations of this build")
+)
+
+var fileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version uint
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Load reads the migrations of fsys ordered by version. Every version needs
+// both an up and a down file.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[uint]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("%s: invalid version", entry.Name())
+		}
+
+		m, ok := byVersion[uint(version)]
+		if !ok {
+			m = &Migration{Version: uint(version), Name: match[2]}
+			byVersion[uint(version)] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("version %d is used by %q and %q", version, m.Name, match[2])
+		}
+
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// Latest returns the highest version, 0 when there are no migrations
+func Latest(migrations []Migration) uint {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// step runs one migration file and leaves the schema at version
+type step struct {
+	migration Migration
+	up        bool
+	version   uint
+}
+
+// plan returns the steps from version from to version to. Version 0 is the
+// empty schema.
+func plan(migrations []Migration, from, to uint) ([]step, error) {
+	index := func(version uint) (int, error) {
+		if version == 0 {
+			return -1, nil
+		}
+		for i, m := range migrations {
+			if m.Version == version {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("%w %d", ErrUnknown, version)
+	}
+
+	i, err := index(from)
+	if err != nil {
+		return nil, err
+	}
+	j, err := index(to)
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []step
+	for ; i < j; i++ {
+		steps = append(steps, step{migration: migrations[i+1], up: true, version: migrations[i+1].Version})
+	}
+	for ; i > j; i-- {
+		var previous uint
+		if i > 0 {
+			previous = migrations[i-1].Version
+		}
+		steps = append(steps, step{migration: migrations[i], up: false, version: previous})
+	}
+
+	return steps, nil
+}
+
+// Migrator applies migrations while holding a Postgres advisory lock, so
+// deploys running it at the same time wait for each other
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	// Log is called before each migration runs
+	Log func(format string, args ...any)
+}
+
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations, Log: func(string, ...any) {}}, nil
+}
+
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Up applies every pending migration
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.migrate(ctx, func(uint) (uint, error) {
+		return Latest(m.migrations), nil
+	})
+}
+
+// Down reverts the last n migrations
+func (m *Migrator) Down(ctx context.Context, n int) error {
+	if n < 1 {
+		return fmt.Errorf("down needs a positive number of migrations, got %d", n)
+	}
+	return m.migrate(ctx, func(current uint) (uint, error) {
+		steps, err := plan(m.migrations, current, 0)
+		if err != nil {
+			return 0, err
+		}
+		if n > len(steps) {
+			n = len(steps)
+		}
+		if n == 0 {
+			return current, nil
+		}
+		return steps[n-1].version, nil
+	})
+}
+
+// Goto migrates up or down to version, 0 reverts every migration
+func (m *Migrator) Goto(ctx context.Context, version uint) error {
+	return m.migrate(ctx, func(uint) (uint, error) {
+		return version, nil
+	})
+}
+
+func (m *Migrator) migrate(ctx context.Context, target func(current uint) (uint, error)) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		current, dirty, err := version(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("version %d: %w", current, ErrDirty)
+		}
+
+		to, err := target(current)
+		if err != nil {
+			return err
+		}
+		steps, err := plan(m.migrations, current, to)
+		if err != nil {
+			return err
+		}
+		if len(steps) == 0 {
+			return ErrNoChange
+		}
+
+		for _, s := range steps {
+			direction, query := "up", s.migration.Up
+			if !s.up {
+				direction, query = "down", s.migration.Down
+			}
+			m.Log("applying %d_%s %s", s.migration.Version, s.migration.Name, direction)
+
+			// the target version is marked dirty first so a failure half way
+			// through is not mistaken for a clean schema
+			if err := setVersion(ctx, conn, s.version, true); err != nil {
+				return err
+			}
+			if _, err := conn.ExecContext(ctx, query); err != nil {
+				return fmt.Errorf("migration %d_%s %s: %w", s.migration.Version, s.migration.Name, direction, err)
+			}
+			if err := setVersion(ctx, conn, s.version, false); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Force sets the version without running migrations, to recover from a
+// dirty state once the schema has been fixed by hand
+func (m *Migrator) Force(ctx context.Context, v uint) error {
+	// an empty plan, it only checks that v exists
+	if _, err := plan(m.migrations, v, v); err != nil {
+		return err
+	}
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		return setVersion(ctx, conn, v, false)
+	})
+}
+
+// Version returns the applied version, 0 when no migration ran, and whether
+// the last migration failed
+func (m *Migrator) Version(ctx context.Context) (uint, bool, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	defer conn.Close()
+
+	return version(ctx, conn)
+}
+
+// Check returns ErrSchemaBehind when the database has not been migrated to
+// the latest version of fsys, or ErrDirty when the last migration failed. A
+// newer schema is fine, it is what a rolling deploy runs the old build against.
+func Check(ctx context.Context, db *sql.DB, fsys fs.FS) error {
+	m, err := New(db, fsys)
+	if err != nil {
+		return err
+	}
+
+	current, dirty, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("version %d: %w", current, ErrDirty)
+	}
+	if latest := Latest(m.migrations); current < latest {
+		return fmt.Errorf("%w: at version %d, expected %d", ErrSchemaBehind, current, latest)
+	}
+
+	return nil
+}
+
+// withLock runs fn on a single connection, advisory locks belong to the session
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		return fmt.Errorf("acquiring migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+
+	if err := ensureVersionTable(ctx, conn); err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func ensureVersionTable(ctx context.Context, conn *sql.Conn) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint NOT NULL PRIMARY KEY,
+		dirty boolean NOT NULL
+	)
+	`
+	_, err := conn.ExecContext(ctx, query)
+	return err
+}
+
+func version(ctx context.Context, conn *sql.Conn) (uint, bool, error) {
+	query := `SELECT version, dirty FROM schema_migrations LIMIT 1`
+
+	var v int64
+	var dirty bool
+	err := conn.QueryRowContext(ctx, query).Scan(&v, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, false, nil
+		case isUndefinedTable(err):
+			return 0, false, nil
+		default:
+			return 0, false, err
+		}
+	}
+
+	// golang-migrate records a failed revert of the first migration as -1
+	return uint(max(v, 0)), dirty, nil
+}
+
+func isUndefinedTable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "42P01"
+}
+
+// setVersion replaces the single row of schema_migrations, version 0 leaves
+// it empty
+func setVersion(ctx context.Context, conn *sql.Conn, v uint, dirty bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `TRUNCATE schema_migrations`); err != nil {
+		return err
+	}
+	if v != 0 || dirty {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, $2)`, int64(v), dirty); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,103 @@
+package migrate
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"tiago-udemy/cmd/migrate/migrations"
+)
+
+func testMigrations(t *testing.T) []Migration {
+	t.Helper()
+	fsys := fstest.MapFS{
+		"000001_create_users.up.sql":   {Data: []byte("CREATE TABLE users ();")},
+		"000001_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		"000002_create_posts.up.sql":   {Data: []byte("CREATE TABLE posts ();")},
+		"000002_create_posts.down.sql": {Data: []byte("DROP TABLE posts;")},
+		"000010_add_tags.up.sql":       {Data: []byte("ALTER TABLE posts ADD tags text[];")},
+		"000010_add_tags.down.sql":     {Data: []byte("ALTER TABLE posts DROP tags;")},
+		"README.md":                    {Data: []byte("not a migration")},
+	}
+
+	m, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestLoad(t *testing.T) {
+	m := testMigrations(t)
+
+	if len(m) != 3 || m[0].Version != 1 || m[2].Version != 10 || m[2].Name != "add_tags" {
+		t.Fatalf("want versions 1, 2 and 10 in order, got %+v", m)
+	}
+	if m[1].Up != "CREATE TABLE posts ();" || m[1].Down != "DROP TABLE posts;" {
+		t.Errorf("want both files read, got %+v", m[1])
+	}
+
+	_, err := Load(fstest.MapFS{"000001_create_users.up.sql": {Data: []byte("CREATE TABLE users ();")}})
+	if err == nil {
+		t.Error("want a migration without a down file rejected")
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	m, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, migration := range m {
+		if migration.Version != uint(i+1) {
+			t.Fatalf("want contiguous versions, %d_%s is at position %d", migration.Version, migration.Name, i+1)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	m := testMigrations(t)
+
+	type want struct {
+		version uint
+		up      bool
+		target  uint
+	}
+
+	tests := []struct {
+		name     string
+		from, to uint
+		want     []want
+		err      error
+	}{
+		{"up from empty", 0, 10, []want{{1, true, 1}, {2, true, 2}, {10, true, 10}}, nil},
+		{"up part way", 1, 2, []want{{2, true, 2}}, nil},
+		{"down to empty", 2, 0, []want{{2, false, 1}, {1, false, 0}}, nil},
+		{"down across a gap", 10, 2, []want{{10, false, 2}}, nil},
+		{"no change", 2, 2, nil, nil},
+		{"unknown target", 0, 3, nil, ErrUnknown},
+		{"unknown current", 11, 2, nil, ErrUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps, err := plan(m, tt.from, tt.to)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("want error %v, got %v", tt.err, err)
+			}
+
+			var got []want
+			for _, s := range steps {
+				got = append(got, want{s.migration.Version, s.up, s.version})
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("step %d: want %v, got %v", i, tt.want[i], got[i])
+				}
+			}
+		})
+	}
+}
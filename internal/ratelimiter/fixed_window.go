@@ -24,40 +24,58 @@ func NewFixedWindowLimiter(limit int, window time.Duration) *FixedWindowRateLimi
 		window:  window,
 	}
 
-	go rl.cleanupLoop() // ✅ only ONE cleanup goroutine
+	go rl.cleanupLoop(window) // ✅ only ONE cleanup goroutine
 	return rl
 }
 
-func (rl *FixedWindowRateLimiter) Allow(ip string) (bool, time.Duration) {
+func (rl *FixedWindowRateLimiter) Allow(key string) Result {
 	rl.Lock()
 	defer rl.Unlock()
 
 	now := time.Now()
-	data, exists := rl.clients[ip]
+	data, exists := rl.clients[key]
 
 	// First request or window expired → start new window
 	if !exists || now.Sub(data.startTime) >= rl.window {
-		rl.clients[ip] = &clientData{
+		data = &clientData{
 			count:     1,
 			startTime: now,
 		}
-		return true, 0
+		rl.clients[key] = data
+		return rl.result(true, data, now)
 	}
 
 	// Within window and still under limit → allow
 	if data.count < rl.limit {
 		data.count++
-		return true, 0
+		return rl.result(true, data, now)
 	}
 
 	// Over the limit → reject
-	wait := rl.window - now.Sub(data.startTime)
-	return false, wait
+	return rl.result(false, data, now)
 }
 
-// cleanupLoop periodically removes expired windows
-func (rl *FixedWindowRateLimiter) cleanupLoop() {
-	ticker := time.NewTicker(rl.window)
+func (rl *FixedWindowRateLimiter) result(allowed bool, data *clientData, now time.Time) Result {
+	return Result{
+		Allowed:   allowed,
+		Limit:     rl.limit,
+		Remaining: rl.limit - data.count,
+		Reset:     rl.window - now.Sub(data.startTime),
+	}
+}
+
+func (rl *FixedWindowRateLimiter) SetLimit(limit int, window time.Duration) {
+	rl.Lock()
+	defer rl.Unlock()
+
+	rl.limit = limit
+	rl.window = window
+}
+
+// cleanupLoop periodically removes expired windows. It starts with the
+// window the limiter was created with, rl.window may already be changing.
+func (rl *FixedWindowRateLimiter) cleanupLoop(window time.Duration) {
+	ticker := time.NewTicker(window)
 	for range ticker.C {
 		now := time.Now()
 
@@ -67,6 +85,8 @@ func (rl *FixedWindowRateLimiter) cleanupLoop() {
 				delete(rl.clients, ip)
 			}
 		}
+		// follow window changes from SetLimit
+		ticker.Reset(rl.window)
 		rl.Unlock()
 	}
 }
@@ -0,0 +1,52 @@
+package ratelimiter
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	decisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ratelimit_requests_total",
+		Help: "Rate limiter decisions by policy and result.",
+	}, []string{"policy", "result"})
+
+	fallbacks = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ratelimit_redis_fallbacks_total",
+		Help: "Requests served by the in-memory limiter because Redis was unavailable.",
+	})
+)
+
+// instrumentedLimiter counts the decisions of the limiter it wraps
+type instrumentedLimiter struct {
+	Limiter
+	allowed prometheus.Counter
+	denied  prometheus.Counter
+}
+
+// Instrument counts the allowed and denied requests of l under policy
+func Instrument(policy string, l Limiter) Limiter {
+	return &instrumentedLimiter{
+		Limiter: l,
+		allowed: decisions.WithLabelValues(policy, "allowed"),
+		denied:  decisions.WithLabelValues(policy, "denied"),
+	}
+}
+
+func (l *instrumentedLimiter) Allow(key string) Result {
+	res := l.Limiter.Allow(key)
+	if res.Allowed {
+		l.allowed.Inc()
+	} else {
+		l.denied.Inc()
+	}
+	return res
+}
+
+func (l *instrumentedLimiter) SetLimit(limit int, window time.Duration) {
+	if t, ok := l.Limiter.(Tunable); ok {
+		t.SetLimit(limit, window)
+	}
+}
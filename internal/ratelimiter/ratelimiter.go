@@ -2,8 +2,31 @@ package ratelimiter
 
 import "time"
 
+// Strategies selectable from config
+const (
+	StrategyFixedWindow   = "fixed"
+	StrategySlidingWindow = "sliding"
+	StrategyTokenBucket   = "token"
+)
+
 type Limiter interface {
-	Allow(ip string) (bool, time.Duration)
+	Allow(key string) Result
+}
+
+// Tunable limiters take new thresholds at runtime. Counters already recorded
+// are kept and judged against the new limit.
+type Tunable interface {
+	SetLimit(limit int, window time.Duration)
+}
+
+// Result is the outcome of a single Allow call
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the key regains quota. For a rejected request it
+	// is how long the client should wait before retrying.
+	Reset time.Duration
 }
 
 type Config struct {
@@ -0,0 +1,204 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+const (
+	// redisTimeout bounds how long a request waits on Redis before falling back
+	redisTimeout = 100 * time.Millisecond
+
+	// redisRetryAfter is how long the limiter stays on the fallback after a
+	// Redis error, so an outage does not add redisTimeout to every request
+	redisRetryAfter = 5 * time.Second
+)
+
+// slidingWindowScript keeps one sorted-set entry per request inside the
+// window. It returns {allowed, remaining, reset in microseconds}.
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local member = ARGV[3]
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+
+redis.call('ZREMRANGEBYSCORE', key, 0, now - window)
+
+local allowed = 0
+local count = redis.call('ZCARD', key)
+if count < limit then
+  redis.call('ZADD', key, now, member)
+  redis.call('PEXPIRE', key, math.ceil(window / 1000))
+  allowed = 1
+  count = count + 1
+end
+
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+return {allowed, limit - count, tonumber(oldest[2]) + window - now}
+`)
+
+// tokenBucketScript refills the bucket by the time elapsed since the last
+// request and takes one token. It returns {allowed, remaining, reset in
+// microseconds}, where reset is the time until the bucket is full again, or
+// until the next token for a rejected request.
+var tokenBucketScript = redis.NewScript(`
+local key = KEYS[1]
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+
+local bucket = redis.call('HMGET', key, 'tokens', 'ts')
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil then
+  tokens = capacity
+  ts = now
+end
+
+tokens = math.min(capacity, tokens + (now - ts) * rate)
+
+local allowed = 0
+local reset = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+  reset = math.ceil((capacity - tokens) / rate)
+else
+  reset = math.ceil((1 - tokens) / rate)
+end
+
+redis.call('HSET', key, 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', key, math.ceil(capacity / rate / 1000) + 1000)
+return {allowed, math.floor(tokens), reset}
+`)
+
+// redisLimiter runs a limiter script and falls back to an in-process limiter
+// while Redis is unreachable.
+type redisLimiter struct {
+	rdb        *redis.Client
+	script     *redis.Script
+	prefix     string
+	fallback   Limiter
+	logger     *zap.SugaredLogger
+	retryAfter atomic.Int64 // unix nanos until which Redis is skipped
+}
+
+func (l *redisLimiter) allow(key string, limit int, args ...any) Result {
+	if time.Now().UnixNano() < l.retryAfter.Load() {
+		fallbacks.Inc()
+		return l.fallback.Allow(key)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	res, err := l.script.Run(ctx, l.rdb, []string{l.prefix + key}, args...).Int64Slice()
+	if err != nil || len(res) != 3 {
+		if err == nil {
+			err = fmt.Errorf("unexpected script result %v", res)
+		}
+		l.retryAfter.Store(time.Now().Add(redisRetryAfter).UnixNano())
+		l.logger.Errorw("redis rate limiter failed, using in-memory fallback", "error", err)
+		fallbacks.Inc()
+		return l.fallback.Allow(key)
+	}
+
+	return Result{
+		Allowed:   res[0] == 1,
+		Limit:     limit,
+		Remaining: int(res[1]),
+		Reset:     time.Duration(res[2]) * time.Microsecond,
+	}
+}
+
+// limits are swapped as a whole so a request never sees a limit from one
+// setting and a window from another
+type limits struct {
+	limit  int
+	window time.Duration
+}
+
+func (l *redisLimiter) setFallbackLimit(limit int, window time.Duration) {
+	if t, ok := l.fallback.(Tunable); ok {
+		t.SetLimit(limit, window)
+	}
+}
+
+// RedisSlidingWindowLimiter allows limit requests in any window-long period.
+// Unlike the fixed window it does not allow bursts of 2x limit at window edges.
+type RedisSlidingWindowLimiter struct {
+	redisLimiter
+	limits atomic.Pointer[limits]
+}
+
+func NewRedisSlidingWindowLimiter(rdb *redis.Client, limit int, window time.Duration, fallback Limiter, logger *zap.SugaredLogger) *RedisSlidingWindowLimiter {
+	rl := &RedisSlidingWindowLimiter{
+		redisLimiter: redisLimiter{
+			rdb:      rdb,
+			script:   slidingWindowScript,
+			prefix:   "ratelimit:sliding:",
+			fallback: fallback,
+			logger:   logger,
+		},
+	}
+	rl.limits.Store(&limits{limit: limit, window: window})
+	return rl
+}
+
+func (rl *RedisSlidingWindowLimiter) Allow(key string) Result {
+	l := rl.limits.Load()
+	// each request needs its own sorted-set member
+	member := strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + strconv.Itoa(rand.Int())
+	return rl.allow(key, l.limit, l.window.Microseconds(), l.limit, member)
+}
+
+// SetLimit also tunes the fallback
+func (rl *RedisSlidingWindowLimiter) SetLimit(limit int, window time.Duration) {
+	rl.limits.Store(&limits{limit: limit, window: window})
+	rl.setFallbackLimit(limit, window)
+}
+
+// RedisTokenBucketLimiter allows bursts of up to limit requests and refills
+// at limit requests per window.
+type RedisTokenBucketLimiter struct {
+	redisLimiter
+	limits atomic.Pointer[limits]
+}
+
+func NewRedisTokenBucketLimiter(rdb *redis.Client, limit int, window time.Duration, fallback Limiter, logger *zap.SugaredLogger) *RedisTokenBucketLimiter {
+	rl := &RedisTokenBucketLimiter{
+		redisLimiter: redisLimiter{
+			rdb:      rdb,
+			script:   tokenBucketScript,
+			prefix:   "ratelimit:bucket:",
+			fallback: fallback,
+			logger:   logger,
+		},
+	}
+	rl.limits.Store(&limits{limit: limit, window: window})
+	return rl
+}
+
+func (rl *RedisTokenBucketLimiter) Allow(key string) Result {
+	l := rl.limits.Load()
+	rate := float64(l.limit) / float64(l.window.Microseconds()) // tokens per microsecond
+	return rl.allow(key, l.limit, l.limit, strconv.FormatFloat(rate, 'g', -1, 64))
+}
+
+// SetLimit also tunes the fallback
+func (rl *RedisTokenBucketLimiter) SetLimit(limit int, window time.Duration) {
+	rl.limits.Store(&limits{limit: limit, window: window})
+	rl.setFallbackLimit(limit, window)
+}
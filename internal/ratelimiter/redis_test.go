@@ -0,0 +1,104 @@
+package ratelimiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// stubLimiter records calls so the fallback path can be asserted
+type stubLimiter struct {
+	calls int
+}
+
+func (s *stubLimiter) Allow(key string) Result {
+	s.calls++
+	return Result{Allowed: true}
+}
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return mr, rdb
+}
+
+func TestRedisSlidingWindowLimiter(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	fallback := &stubLimiter{}
+	rl := NewRedisSlidingWindowLimiter(rdb, 3, time.Minute, fallback, zap.NewNop().Sugar())
+
+	for i := 0; i < 3; i++ {
+		res := rl.Allow("10.0.0.1")
+		assert.True(t, res.Allowed, "request %d should be allowed", i+1)
+		assert.Equal(t, 3, res.Limit)
+		assert.Equal(t, 2-i, res.Remaining)
+	}
+
+	res := rl.Allow("10.0.0.1")
+	assert.False(t, res.Allowed)
+	assert.Zero(t, res.Remaining)
+	assert.Greater(t, res.Reset, time.Duration(0))
+	assert.LessOrEqual(t, res.Reset, time.Minute)
+
+	res = rl.Allow("10.0.0.2")
+	assert.True(t, res.Allowed, "limits are per key")
+	assert.Zero(t, fallback.calls)
+}
+
+func TestRedisTokenBucketLimiter(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	fallback := &stubLimiter{}
+	rl := NewRedisTokenBucketLimiter(rdb, 2, time.Minute, fallback, zap.NewNop().Sugar())
+
+	for i := 0; i < 2; i++ {
+		res := rl.Allow("10.0.0.1")
+		assert.True(t, res.Allowed, "burst request %d should be allowed", i+1)
+		assert.Equal(t, 1-i, res.Remaining)
+	}
+
+	res := rl.Allow("10.0.0.1")
+	assert.False(t, res.Allowed)
+	// one token refills every 30s
+	assert.Greater(t, res.Reset, 25*time.Second)
+	assert.LessOrEqual(t, res.Reset, 30*time.Second)
+	assert.Zero(t, fallback.calls)
+}
+
+func TestRedisLimiterFallback(t *testing.T) {
+	mr, rdb := newTestRedis(t)
+	fallback := &stubLimiter{}
+	rl := NewRedisSlidingWindowLimiter(rdb, 1, time.Minute, fallback, zap.NewNop().Sugar())
+
+	mr.Close()
+
+	res := rl.Allow("10.0.0.1")
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 1, fallback.calls)
+
+	// Redis is skipped until the retry delay passes
+	rl.Allow("10.0.0.1")
+	assert.Equal(t, 2, fallback.calls)
+}
+
+func TestSetLimit(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	fallback := NewFixedWindowLimiter(1, time.Minute)
+	rl := Instrument("test", NewRedisSlidingWindowLimiter(rdb, 1, time.Minute, fallback, zap.NewNop().Sugar()))
+
+	assert.True(t, rl.Allow("10.0.0.1").Allowed)
+	assert.False(t, rl.Allow("10.0.0.1").Allowed)
+
+	rl.(Tunable).SetLimit(3, time.Minute)
+
+	res := rl.Allow("10.0.0.1")
+	assert.True(t, res.Allowed, "requests already counted stay counted")
+	assert.Equal(t, 3, res.Limit)
+	assert.Equal(t, 1, res.Remaining)
+	assert.Equal(t, 3, fallback.Allow("10.0.0.1").Limit, "the fallback is tuned too")
+}
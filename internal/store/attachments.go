@@ -0,0 +1,193 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type Attachment struct {
+	ID           int64  `json:"id"`
+	PostID       *int64 `json:"post_id"`
+	UserID       int64  `json:"user_id"`
+	BlobKey      string `json:"-"`
+	ThumbnailKey string `json:"-"`
+	MimeType     string `json:"mime_type"`
+	Size         int64  `json:"size"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	CreatedAt    string `json:"created_at"`
+}
+
+type AttachmentStore struct {
+	db *sql.DB
+}
+
+func (s *AttachmentStore) Create(ctx context.Context, attachment *Attachment) error {
+	query := `
+			INSERT INTO attachments (user_id, blob_key, thumbnail_key, mime_type, size, width, height)
+			VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	return s.db.QueryRowContext(
+		ctx,
+		query,
+		attachment.UserID,
+		attachment.BlobKey,
+		attachment.ThumbnailKey,
+		attachment.MimeType,
+		attachment.Size,
+		attachment.Width,
+		attachment.Height,
+	).Scan(
+		&attachment.ID,
+		&attachment.CreatedAt,
+	)
+}
+
+func (s *AttachmentStore) Get(ctx context.Context, id int64) (*Attachment, error) {
+	query := `
+			SELECT id, post_id, user_id, blob_key, thumbnail_key, mime_type, size, width, height, created_at
+			FROM attachments
+			WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var attachment Attachment
+	err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&attachment.ID,
+		&attachment.PostID,
+		&attachment.UserID,
+		&attachment.BlobKey,
+		&attachment.ThumbnailKey,
+		&attachment.MimeType,
+		&attachment.Size,
+		&attachment.Width,
+		&attachment.Height,
+		&attachment.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &attachment, nil
+}
+
+// AttachToPost links an unattached upload owned by userID to a post
+func (s *AttachmentStore) AttachToPost(ctx context.Context, id int64, postID int64, userID int64) error {
+	query := `
+	UPDATE attachments
+		SET post_id = $1
+		WHERE id = $2 AND user_id = $3 AND post_id IS NULL
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, postID, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetByPostIDs returns the attachments of each post, keyed by post ID
+func (s *AttachmentStore) GetByPostIDs(ctx context.Context, postIDs []int64) (map[int64][]Attachment, error) {
+	query := `
+			SELECT id, post_id, user_id, mime_type, size, width, height, created_at
+			FROM attachments
+			WHERE post_id = ANY($1)
+			ORDER BY id
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, pq.Array(postIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := make(map[int64][]Attachment)
+	for rows.Next() {
+		var attachment Attachment
+		err := rows.Scan(
+			&attachment.ID,
+			&attachment.PostID,
+			&attachment.UserID,
+			&attachment.MimeType,
+			&attachment.Size,
+			&attachment.Width,
+			&attachment.Height,
+			&attachment.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		attachments[*attachment.PostID] = append(attachments[*attachment.PostID], attachment)
+	}
+
+	return attachments, rows.Err()
+}
+
+// GetOrphans returns uploads that are not attached to any post and are older than olderThan
+func (s *AttachmentStore) GetOrphans(ctx context.Context, olderThan time.Duration, limit int) ([]Attachment, error) {
+	query := `
+			SELECT id, blob_key, thumbnail_key
+			FROM attachments
+			WHERE post_id IS NULL AND created_at < $1
+			ORDER BY created_at
+			LIMIT $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, time.Now().Add(-olderThan), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []Attachment
+	for rows.Next() {
+		var attachment Attachment
+		if err := rows.Scan(&attachment.ID, &attachment.BlobKey, &attachment.ThumbnailKey); err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	return attachments, rows.Err()
+}
+
+func (s *AttachmentStore) Delete(ctx context.Context, id int64) error {
+	query := `
+			DELETE FROM attachments WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, id)
+	return err
+}
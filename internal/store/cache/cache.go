@@ -2,26 +2,252 @@ package cache
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"hash/maphash"
+	"math/rand"
+	"sync/atomic"
 	"tiago-udemy/internal/store"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 )
 
-const CacheDefaultTTL = 10 * time.Minute
+const (
+	CacheDefaultTTL = 10 * time.Minute
 
-type User interface {
-	Set(ctx context.Context, user *store.User) error
-	Get(ctx context.Context, userId int64) (*store.User, bool, error)
-	Delete(ctx context.Context, userID int64) error
+	// maxJitter spreads out the expiry of entries cached at the same time
+	maxJitter = 30 * time.Second
+
+	// generationStripes bounds the memory used to track invalidations, keys
+	// sharing a stripe only cost each other a skipped Set
+	generationStripes = 256
+)
+
+// requests counts lookups per cache by result: hit, miss or error
+var requests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "cache_requests_total",
+	Help: "Cache lookups by cache and result.",
+}, []string{"cache", "result"})
+
+// Backend stores encoded cache entries
+type Backend interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// Cache is a typed read-through cache on top of a Backend. Entries are
+// stored as JSON under "<name>:<key>".
+type Cache[K comparable, V any] struct {
+	backend Backend
+	name    string
+	ttl     atomic.Int64 // time.Duration, changed by SetTTL
+	expiry  func(*V) time.Time
+	logger  *zap.SugaredLogger
+	group   singleflight.Group
+
+	// generations is bumped by Invalidate, so a load that raced with a write
+	// does not put the value it read before the write back
+	generations [generationStripes]atomic.Uint64
+	seed        maphash.Seed
+}
+
+func New[K comparable, V any](backend Backend, name string, ttl time.Duration, logger *zap.SugaredLogger) *Cache[K, V] {
+	if ttl <= 0 {
+		ttl = CacheDefaultTTL
+	}
+	c := &Cache[K, V]{backend: backend, name: name, logger: logger, seed: maphash.MakeSeed()}
+	c.ttl.Store(int64(ttl))
+	return c
+}
+
+// SetTTL changes the TTL of entries set from now on
+func (c *Cache[K, V]) SetTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = CacheDefaultTTL
+	}
+	c.ttl.Store(int64(ttl))
+}
+
+// WithExpiry keeps entries from outliving the time returned by expiry
+func (c *Cache[K, V]) WithExpiry(expiry func(*V) time.Time) *Cache[K, V] {
+	c.expiry = expiry
+	return c
+}
+
+func (c *Cache[K, V]) key(k K) string {
+	return fmt.Sprintf("%s:%v", c.name, k)
+}
+
+func (c *Cache[K, V]) generation(key string) *atomic.Uint64 {
+	return &c.generations[maphash.String(c.seed, key)%generationStripes]
+}
+
+func (c *Cache[K, V]) count(result string) {
+	requests.WithLabelValues(c.name, result).Inc()
+}
+
+func (c *Cache[K, V]) Get(ctx context.Context, k K) (*V, bool, error) {
+	val, found, err := c.backend.Get(ctx, c.key(k))
+	if err != nil {
+		c.count("error")
+		return nil, false, err
+	}
+	if !found {
+		c.count("miss")
+		return nil, false, nil
+	}
+
+	v := new(V)
+	if err := json.Unmarshal(val, v); err != nil {
+		c.count("error")
+		_ = c.backend.Delete(ctx, c.key(k))
+		return nil, false, err
+	}
+
+	c.count("hit")
+	return v, true, nil
+}
+
+func (c *Cache[K, V]) Set(ctx context.Context, k K, v *V) error {
+	val, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return c.set(ctx, k, v, val)
+}
+
+func (c *Cache[K, V]) set(ctx context.Context, k K, v *V, val []byte) error {
+	ttl := time.Duration(c.ttl.Load()) + time.Duration(rand.Int63n(int64(maxJitter)))
+	if c.expiry != nil {
+		ttl = min(ttl, time.Until(c.expiry(v)))
+		if ttl <= 0 {
+			return nil
+		}
+	}
+
+	return c.backend.Set(ctx, c.key(k), val, ttl)
+}
+
+func (c *Cache[K, V]) Delete(ctx context.Context, ks ...K) error {
+	if len(ks) == 0 {
+		return nil
+	}
+
+	keys := make([]string, len(ks))
+	for i, k := range ks {
+		keys[i] = c.key(k)
+	}
+	return c.backend.Delete(ctx, keys...)
+}
+
+// GetOrLoad returns the cached value, or calls load and caches its result.
+// Concurrent misses for the same key share a single load. Cache errors are
+// logged and fall through to load, the cache is never the source of truth.
+func (c *Cache[K, V]) GetOrLoad(ctx context.Context, k K, load func(context.Context) (*V, error)) (*V, error) {
+	v, found, err := c.Get(ctx, k)
+	if err != nil {
+		c.logger.Errorw("cache get failed", "cache", c.name, "key", k, "error", err)
+	} else if found {
+		return v, nil
+	}
+
+	key := c.key(k)
+	res, err, _ := c.group.Do(key, func() (any, error) {
+		// the load is shared, the caller that started it going away must not
+		// fail the others
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), store.QueryTimeOutDuration)
+		defer cancel()
+
+		generation := c.generation(key)
+		before := generation.Load()
+
+		v, err := load(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		val, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := c.set(ctx, k, v, val); err != nil {
+			c.logger.Errorw("cache set failed", "cache", c.name, "key", k, "error", err)
+		}
+
+		// an Invalidate since the load started may have deleted the key before
+		// the Set above, drop what may be the value from before the write.
+		// Invalidate bumps first and deletes second, so one that bumps after
+		// this check deletes after the Set.
+		if generation.Load() != before {
+			if err := c.backend.Delete(ctx, key); err != nil {
+				c.logger.Errorw("cache delete failed", "cache", c.name, "key", k, "error", err)
+			}
+		}
+		return val, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// every caller decodes its own value, callers that shared the load must
+	// not share slices or pointers
+	v = new(V)
+	if err := json.Unmarshal(res.([]byte), v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Invalidate deletes entries after a write and logs failures, the write
+// itself already succeeded
+func (c *Cache[K, V]) Invalidate(ctx context.Context, ks ...K) {
+	for _, k := range ks {
+		c.generation(c.key(k)).Add(1)
+	}
+
+	if err := c.Delete(ctx, ks...); err != nil {
+		c.count("error")
+		c.logger.Errorw("cache delete failed", "cache", c.name, "keys", ks, "error", err)
+	}
 }
 
 type CacheStorage struct {
-	Users User
+	Users    *Cache[int64, store.User]
+	Posts    *Cache[int64, store.Post]
+	Sessions *Cache[string, store.Session]
 }
 
-func RedisStore(rdb *redis.Client) CacheStorage {
+func NewCacheStorage(backend Backend, logger *zap.SugaredLogger) CacheStorage {
 	return CacheStorage{
-		Users: NewRedisUserCache(rdb, CacheDefaultTTL),
+		Users:    New[int64, store.User](backend, "user", CacheDefaultTTL, logger),
+		Posts:    New[int64, store.Post](backend, "post", CacheDefaultTTL, logger),
+		Sessions: New[string, store.Session](backend, "session", CacheDefaultTTL, logger).WithExpiry(func(s *store.Session) time.Time { return s.ExpiresAt }),
 	}
 }
+
+// SetTTL changes the TTL of every cache
+func (c CacheStorage) SetTTL(ttl time.Duration) {
+	c.Users.SetTTL(ttl)
+	c.Posts.SetTTL(ttl)
+	c.Sessions.SetTTL(ttl)
+}
+
+func RedisStore(rdb *redis.Client, logger *zap.SugaredLogger) CacheStorage {
+	return NewCacheStorage(NewRedisBackend(rdb), logger)
+}
+
+// TieredStore is RedisStore with users, which are read on every authenticated
+// request, served from the given tiered backend
+func TieredStore(rdb *redis.Client, users *TieredBackend, logger *zap.SugaredLogger) CacheStorage {
+	c := RedisStore(rdb, logger)
+	c.Users = New[int64, store.User](users, "user", CacheDefaultTTL, logger)
+	return c
+}
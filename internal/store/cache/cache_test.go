@@ -0,0 +1,296 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"tiago-udemy/internal/store"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestCacheStorage(t *testing.T) (*miniredis.Miniredis, CacheStorage) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return mr, RedisStore(rdb, zap.NewNop().Sugar())
+}
+
+func counter(cache, result string) float64 {
+	return testutil.ToFloat64(requests.WithLabelValues(cache, result))
+}
+
+func TestGetOrLoad(t *testing.T) {
+	_, c := newTestCacheStorage(t)
+	ctx := context.Background()
+
+	var loads atomic.Int32
+	release := make(chan struct{})
+	load := func(ctx context.Context) (*store.Post, error) {
+		loads.Add(1)
+		<-release
+		return &store.Post{ID: 1, Title: "hello"}, nil
+	}
+
+	hits, misses := counter("post", "hit"), counter("post", "miss")
+
+	// concurrent misses share one load
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			post, err := c.Posts.GetOrLoad(ctx, 1, load)
+			assert.NoError(t, err)
+			assert.Equal(t, "hello", post.Title)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), loads.Load())
+	assert.Equal(t, float64(10), counter("post", "miss")-misses)
+
+	post, err := c.Posts.GetOrLoad(ctx, 1, load)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", post.Title)
+	assert.Equal(t, int32(1), loads.Load(), "second read should be served from the cache")
+	assert.Equal(t, float64(1), counter("post", "hit")-hits)
+}
+
+func TestGetOrLoadInvalidatedDuringLoad(t *testing.T) {
+	mr, c := newTestCacheStorage(t)
+	ctx := context.Background()
+
+	// the write lands and invalidates while the old row is being loaded
+	post, err := c.Posts.GetOrLoad(ctx, 1, func(ctx context.Context) (*store.Post, error) {
+		c.Posts.Invalidate(ctx, 1)
+		return &store.Post{ID: 1, Title: "before the write"}, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "before the write", post.Title)
+	assert.False(t, mr.Exists("post:1"), "a value loaded before an invalidation is not cached")
+
+	_, err = c.Posts.GetOrLoad(ctx, 1, func(ctx context.Context) (*store.Post, error) {
+		return &store.Post{ID: 1, Title: "after the write"}, nil
+	})
+	require.NoError(t, err)
+	assert.True(t, mr.Exists("post:1"), "later loads are cached again")
+}
+
+func TestGetOrLoadSharedLoad(t *testing.T) {
+	_, c := newTestCacheStorage(t)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	load := func(ctx context.Context) (*store.Post, error) {
+		close(started)
+		<-release
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return &store.Post{ID: 1, Tags: []string{"go"}}, nil
+	}
+
+	// the caller that starts the load gives up before it finishes
+	first, cancel := context.WithCancel(context.Background())
+	firstDone := make(chan *store.Post)
+	go func() {
+		post, err := c.Posts.GetOrLoad(first, 1, load)
+		assert.NoError(t, err)
+		firstDone <- post
+	}()
+	<-started
+
+	secondDone := make(chan *store.Post)
+	go func() {
+		post, err := c.Posts.GetOrLoad(context.Background(), 1, load)
+		assert.NoError(t, err)
+		secondDone <- post
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	close(release)
+	firstPost, second := <-firstDone, <-secondDone
+	require.NotNil(t, firstPost, "cancelling the first caller must not fail the shared load")
+	require.NotNil(t, second)
+
+	firstPost.Tags[0] = "changed"
+	assert.Equal(t, "go", second.Tags[0], "callers that shared a load must not share slices")
+}
+
+func TestCacheExpiry(t *testing.T) {
+	mr, c := newTestCacheStorage(t)
+	ctx := context.Background()
+
+	session := &store.Session{ID: "abc", ExpiresAt: time.Now().Add(time.Minute)}
+	require.NoError(t, c.Sessions.Set(ctx, session.ID, session))
+	assert.LessOrEqual(t, mr.TTL("session:abc"), time.Minute)
+
+	expired := &store.Session{ID: "old", ExpiresAt: time.Now().Add(-time.Minute)}
+	require.NoError(t, c.Sessions.Set(ctx, expired.ID, expired))
+	assert.False(t, mr.Exists("session:old"), "expired sessions are not cached")
+}
+
+// fakePosts counts reads so the tests can tell cache hits from loads
+type fakePosts struct {
+	store.PostRepository
+	reads int
+}
+
+func (f *fakePosts) Get(ctx context.Context, id int64) (*store.Post, error) {
+	f.reads++
+	return &store.Post{ID: id}, nil
+}
+
+func (f *fakePosts) UpdatePost(ctx context.Context, post *store.Post) error {
+	return nil
+}
+
+func TestCachedStorageInvalidatesOnWrite(t *testing.T) {
+	_, c := newTestCacheStorage(t)
+	ctx := context.Background()
+
+	posts := &fakePosts{}
+	s := NewCachedStorage(store.Storage{Posts: posts}, c)
+
+	_, err := s.Posts.Get(ctx, 1)
+	require.NoError(t, err)
+	_, err = s.Posts.Get(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, posts.reads)
+
+	require.NoError(t, s.Posts.UpdatePost(ctx, &store.Post{ID: 1}))
+
+	_, err = s.Posts.Get(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, posts.reads, "update should invalidate the cached post")
+}
+
+type fakeUsers struct {
+	store.UserRepository
+	sessionIDs []string
+}
+
+func (f *fakeUsers) GetUserbyID(ctx context.Context, id int64) (*store.User, error) {
+	return &store.User{ID: id}, nil
+}
+
+func (f *fakeUsers) ForcePasswordReset(ctx context.Context, userID int64, hashtoken string, resetExp time.Duration) ([]string, error) {
+	return f.sessionIDs, nil
+}
+
+func (f *fakeUsers) ResetPassword(ctx context.Context, hashtoken string, user *store.User) ([]string, error) {
+	user.ID = 1
+	return f.sessionIDs, nil
+}
+
+type fakeSessions struct {
+	store.SessionRepository
+	reads int
+}
+
+func (f *fakeSessions) Get(ctx context.Context, id string) (*store.Session, error) {
+	f.reads++
+	return &store.Session{ID: id, UserID: 1, ExpiresAt: time.Now().Add(time.Hour)}, nil
+}
+
+func TestCachedStorageDropsRevokedSessions(t *testing.T) {
+	ctx := context.Background()
+
+	for name, reset := range map[string]func(s store.Storage) error{
+		"forced reset": func(s store.Storage) error {
+			_, err := s.Users.ForcePasswordReset(ctx, 1, "token", time.Hour)
+			return err
+		},
+		"reset": func(s store.Storage) error {
+			_, err := s.Users.ResetPassword(ctx, "token", &store.User{})
+			return err
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, c := newTestCacheStorage(t)
+			sessions := &fakeSessions{}
+			s := NewCachedStorage(store.Storage{Users: &fakeUsers{sessionIDs: []string{"s1"}}, Sessions: sessions}, c)
+
+			_, err := s.Sessions.Get(ctx, "s1")
+			require.NoError(t, err)
+			_, err = s.Sessions.Get(ctx, "s1")
+			require.NoError(t, err)
+			assert.Equal(t, 1, sessions.reads)
+
+			require.NoError(t, reset(s))
+
+			_, err = s.Sessions.Get(ctx, "s1")
+			require.NoError(t, err)
+			assert.Equal(t, 2, sessions.reads, "the revoked session should be read again")
+		})
+	}
+}
+
+func TestTieredBackendInvalidatesReplicas(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	// two replicas sharing one redis
+	replica := func() *TieredBackend {
+		rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { rdb.Close() })
+		b := NewTieredBackend(rdb, 10, time.Minute, zap.NewNop().Sugar())
+		go b.Subscribe(ctx)
+		return b
+	}
+	a, b := replica(), replica()
+	require.Eventually(t, func() bool {
+		return mr.PubSubNumSub(invalidationChannel)[invalidationChannel] == 2
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, a.Set(ctx, "user:1", []byte("v1"), time.Minute))
+	val, found, err := b.Get(ctx, "user:1")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, "v1", string(val))
+
+	// the local tier answers without redis
+	mr.Del("user:1")
+	_, found = b.local.get("user:1")
+	assert.True(t, found)
+
+	require.NoError(t, a.Delete(ctx, "user:1"))
+	assert.Eventually(t, func() bool {
+		_, found := b.local.get("user:1")
+		return !found
+	}, time.Second, 10*time.Millisecond, "delete on one replica should evict the other's local copy")
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	l := newLRU(2)
+	l.set("a", []byte("a"), time.Minute)
+	l.set("b", []byte("b"), time.Minute)
+	l.get("a")
+	l.set("c", []byte("c"), time.Minute)
+
+	_, found := l.get("b")
+	assert.False(t, found)
+	_, found = l.get("a")
+	assert.True(t, found)
+
+	l.set("d", []byte("d"), -time.Second)
+	_, found = l.get("d")
+	assert.False(t, found, "expired entries are not returned")
+
+	empty := newLRU(0)
+	empty.set("a", []byte("a"), time.Minute)
+	_, found = empty.get("a")
+	assert.True(t, found, "a size below one holds a single entry")
+}
@@ -2,27 +2,28 @@ package cache
 
 import (
 	"context"
-	"tiago-udemy/internal/store"
+	"time"
+
+	"go.uber.org/zap"
 )
 
-// noOpUserCache implements User interface but does nothing
-type noOpUserCache struct{}
+// noOpBackend implements Backend but does nothing, every read is a miss
+type noOpBackend struct{}
 
-func (n *noOpUserCache) Set(ctx context.Context, user *store.User) error {
-	return nil // Always succeed but do nothing
+func (n noOpBackend) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	return nil, false, nil // Always return cache miss
 }
 
-func (n *noOpUserCache) Get(ctx context.Context, userId int64) (*store.User, bool, error) {
-	return nil, false, nil // Always return cache miss
+func (n noOpBackend) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return nil // Always succeed but do nothing
 }
 
-func (n *noOpUserCache) Delete(ctx context.Context, userID int64) error {
+func (n noOpBackend) Delete(ctx context.Context, keys ...string) error {
 	return nil // Always succeed but do nothing
 }
 
-// NewNoOpStore returns a CacheStorage that does nothing
-func NewNoOpStore() CacheStorage {
-	return CacheStorage{
-		Users: &noOpUserCache{},
-	}
+// NewNoOpStore returns a CacheStorage that does nothing. Concurrent loads of
+// the same key are still coalesced.
+func NewNoOpStore(logger *zap.SugaredLogger) CacheStorage {
+	return NewCacheStorage(noOpBackend{}, logger)
 }
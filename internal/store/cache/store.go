@@ -0,0 +1,255 @@
+package cache
+
+import (
+	"context"
+	"tiago-udemy/internal/store"
+	"time"
+)
+
+// NewCachedStorage wraps the repositories whose results are cached. Reads go
+// through the cache and every write that changes a cached user, post or
+// session invalidates it, so callers never deal with the cache directly.
+func NewCachedStorage(s store.Storage, c CacheStorage) store.Storage {
+	base := s
+
+	s.Users = &cachedUserStore{UserRepository: base.Users, base: base, cache: c}
+	s.Posts = &cachedPostStore{PostRepository: base.Posts, cache: c}
+	s.Sessions = &cachedSessionStore{SessionRepository: base.Sessions, cache: c}
+	s.Moderation = &cachedModerationStore{ModerationRepository: base.Moderation, cache: c}
+	s.DataJobs = &cachedDataJobStore{DataJobRepository: base.DataJobs, base: base, cache: c}
+
+	return s
+}
+
+type cachedUserStore struct {
+	store.UserRepository
+	base  store.Storage
+	cache CacheStorage
+}
+
+func (s *cachedUserStore) GetUserbyID(ctx context.Context, id int64) (*store.User, error) {
+	return s.cache.Users.GetOrLoad(ctx, id, func(ctx context.Context) (*store.User, error) {
+		return s.UserRepository.GetUserbyID(ctx, id)
+	})
+}
+
+func (s *cachedUserStore) Activate(ctx context.Context, hashtoken string) (int64, error) {
+	userID, err := s.UserRepository.Activate(ctx, hashtoken)
+	if err != nil {
+		return 0, err
+	}
+
+	s.cache.Users.Invalidate(ctx, userID)
+	return userID, nil
+}
+
+// Delete also drops the posts and sessions that go with the user
+func (s *cachedUserStore) Delete(ctx context.Context, id int64) error {
+	postIDs, err := s.base.Posts.GetIDsByUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	sessions, err := s.base.Sessions.GetByUser(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.UserRepository.Delete(ctx, id); err != nil {
+		return err
+	}
+
+	sessionIDs := make([]string, len(sessions))
+	for i, session := range sessions {
+		sessionIDs[i] = session.ID
+	}
+
+	s.cache.Users.Invalidate(ctx, id)
+	s.cache.Posts.Invalidate(ctx, postIDs...)
+	s.cache.Sessions.Invalidate(ctx, sessionIDs...)
+	return nil
+}
+
+func (s *cachedUserStore) UpdateRole(ctx context.Context, userID int64, roleName string) (*store.Role, error) {
+	role, err := s.UserRepository.UpdateRole(ctx, userID, roleName)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.Users.Invalidate(ctx, userID)
+	return role, nil
+}
+
+func (s *cachedUserStore) SetDisabled(ctx context.Context, userID int64, disabled bool) error {
+	return s.invalidateAfter(ctx, userID, s.UserRepository.SetDisabled(ctx, userID, disabled))
+}
+
+// ForcePasswordReset also drops the sessions it revoked
+func (s *cachedUserStore) ForcePasswordReset(ctx context.Context, userID int64, hashtoken string, resetExp time.Duration) ([]string, error) {
+	sessionIDs, err := s.UserRepository.ForcePasswordReset(ctx, userID, hashtoken, resetExp)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.Users.Invalidate(ctx, userID)
+	s.cache.Sessions.Invalidate(ctx, sessionIDs...)
+	return sessionIDs, nil
+}
+
+// ResetPassword also drops the sessions it revoked
+func (s *cachedUserStore) ResetPassword(ctx context.Context, hashtoken string, user *store.User) ([]string, error) {
+	sessionIDs, err := s.UserRepository.ResetPassword(ctx, hashtoken, user)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.Users.Invalidate(ctx, user.ID)
+	s.cache.Sessions.Invalidate(ctx, sessionIDs...)
+	return sessionIDs, nil
+}
+
+func (s *cachedUserStore) UpdateProfile(ctx context.Context, user *store.User) error {
+	return s.invalidateAfter(ctx, user.ID, s.UserRepository.UpdateProfile(ctx, user))
+}
+
+func (s *cachedUserStore) UpdatePassword(ctx context.Context, user *store.User) error {
+	return s.invalidateAfter(ctx, user.ID, s.UserRepository.UpdatePassword(ctx, user))
+}
+
+func (s *cachedUserStore) ConfirmEmailChange(ctx context.Context, hashtoken string) (int64, error) {
+	userID, err := s.UserRepository.ConfirmEmailChange(ctx, hashtoken)
+	if err != nil {
+		return 0, err
+	}
+
+	s.cache.Users.Invalidate(ctx, userID)
+	return userID, nil
+}
+
+func (s *cachedUserStore) invalidateAfter(ctx context.Context, userID int64, err error) error {
+	if err != nil {
+		return err
+	}
+
+	s.cache.Users.Invalidate(ctx, userID)
+	return nil
+}
+
+type cachedPostStore struct {
+	store.PostRepository
+	cache CacheStorage
+}
+
+func (s *cachedPostStore) Get(ctx context.Context, id int64) (*store.Post, error) {
+	return s.cache.Posts.GetOrLoad(ctx, id, func(ctx context.Context) (*store.Post, error) {
+		return s.PostRepository.Get(ctx, id)
+	})
+}
+
+func (s *cachedPostStore) DeletePost(ctx context.Context, id int64, version int64) (*store.Post, error) {
+	post, err := s.PostRepository.DeletePost(ctx, id, version)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.Posts.Invalidate(ctx, id)
+	return post, nil
+}
+
+func (s *cachedPostStore) UpdatePost(ctx context.Context, post *store.Post) error {
+	if err := s.PostRepository.UpdatePost(ctx, post); err != nil {
+		return err
+	}
+
+	s.cache.Posts.Invalidate(ctx, post.ID)
+	return nil
+}
+
+type cachedSessionStore struct {
+	store.SessionRepository
+	cache CacheStorage
+}
+
+// Get is read on every authenticated request, the cache keeps revocation
+// checks off Postgres
+func (s *cachedSessionStore) Get(ctx context.Context, id string) (*store.Session, error) {
+	return s.cache.Sessions.GetOrLoad(ctx, id, func(ctx context.Context) (*store.Session, error) {
+		return s.SessionRepository.Get(ctx, id)
+	})
+}
+
+func (s *cachedSessionStore) Delete(ctx context.Context, id string, userID int64) error {
+	if err := s.SessionRepository.Delete(ctx, id, userID); err != nil {
+		return err
+	}
+
+	s.cache.Sessions.Invalidate(ctx, id)
+	return nil
+}
+
+func (s *cachedSessionStore) DeleteOthers(ctx context.Context, userID int64, keepID string) ([]string, error) {
+	ids, err := s.SessionRepository.DeleteOthers(ctx, userID, keepID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.Sessions.Invalidate(ctx, ids...)
+	return ids, nil
+}
+
+func (s *cachedSessionStore) TouchLastSeen(ctx context.Context, id string) error {
+	if err := s.SessionRepository.TouchLastSeen(ctx, id); err != nil {
+		return err
+	}
+
+	s.cache.Sessions.Invalidate(ctx, id)
+	return nil
+}
+
+type cachedModerationStore struct {
+	store.ModerationRepository
+	cache CacheStorage
+}
+
+// Resolve drops hidden posts and suspended users
+func (s *cachedModerationStore) Resolve(ctx context.Context, action *store.ModerationAction) error {
+	if err := s.ModerationRepository.Resolve(ctx, action); err != nil {
+		return err
+	}
+
+	switch action.Action {
+	case store.ModerationActionHide:
+		if action.TargetType == store.ReportTargetPost {
+			s.cache.Posts.Invalidate(ctx, action.TargetID)
+		}
+	case store.ModerationActionSuspend:
+		s.cache.Users.Invalidate(ctx, *action.TargetUserID)
+	}
+	return nil
+}
+
+type cachedDataJobStore struct {
+	store.DataJobRepository
+	base  store.Storage
+	cache CacheStorage
+}
+
+func (s *cachedDataJobStore) EraseUser(ctx context.Context, userID int64, deleteContent bool) (*store.Erasure, error) {
+	var postIDs []int64
+	if deleteContent {
+		ids, err := s.base.Posts.GetIDsByUser(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		postIDs = ids
+	}
+
+	erasure, err := s.DataJobRepository.EraseUser(ctx, userID, deleteContent)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.Users.Invalidate(ctx, userID)
+	s.cache.Posts.Invalidate(ctx, postIDs...)
+	s.cache.Sessions.Invalidate(ctx, erasure.SessionIDs...)
+	return erasure, nil
+}
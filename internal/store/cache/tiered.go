@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+const (
+	StrategyRedis  = "redis"
+	StrategyTiered = "tiered"
+
+	// invalidationChannel carries the keys deleted by any replica
+	invalidationChannel = "cache:invalidate"
+
+	// resubscribeDelay is how long Subscribe waits before reconnecting
+	resubscribeDelay = time.Second
+)
+
+// localRequests counts lookups in the in-process tier by result
+var localRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "cache_local_requests_total",
+	Help: "Lookups in the in-process cache tier by result.",
+}, []string{"result"})
+
+// TieredBackend keeps hot entries in an in-process LRU in front of Redis.
+// Deletes are published on invalidationChannel so every replica evicts its
+// local copy, the short local TTL bounds staleness for messages that are lost
+// while a replica is disconnected.
+type TieredBackend struct {
+	rdb    *redis.Client
+	remote Backend
+	local  *lru
+	ttl    atomic.Int64 // time.Duration, changed by SetLocalTTL
+	logger *zap.SugaredLogger
+}
+
+func NewTieredBackend(rdb *redis.Client, size int, ttl time.Duration, logger *zap.SugaredLogger) *TieredBackend {
+	b := &TieredBackend{
+		rdb:    rdb,
+		remote: NewRedisBackend(rdb),
+		local:  newLRU(size),
+		logger: logger,
+	}
+	b.ttl.Store(int64(ttl))
+	return b
+}
+
+// SetLocalTTL changes how long entries set from now on stay in the local tier
+func (b *TieredBackend) SetLocalTTL(ttl time.Duration) {
+	b.ttl.Store(int64(ttl))
+}
+
+func (b *TieredBackend) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if val, ok := b.local.get(key); ok {
+		localRequests.WithLabelValues("hit").Inc()
+		return val, true, nil
+	}
+	localRequests.WithLabelValues("miss").Inc()
+
+	val, found, err := b.remote.Get(ctx, key)
+	if err != nil || !found {
+		return nil, found, err
+	}
+
+	b.local.set(key, val, time.Duration(b.ttl.Load()))
+	return val, true, nil
+}
+
+func (b *TieredBackend) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := b.remote.Set(ctx, key, value, ttl); err != nil {
+		return err
+	}
+
+	b.local.set(key, value, min(ttl, time.Duration(b.ttl.Load())))
+	return nil
+}
+
+// Delete evicts the keys here, in Redis and, through the invalidation
+// channel, on the other replicas
+func (b *TieredBackend) Delete(ctx context.Context, keys ...string) error {
+	b.local.delete(keys...)
+
+	err := b.remote.Delete(ctx, keys...)
+
+	msg, _ := json.Marshal(keys)
+	if pubErr := b.rdb.Publish(ctx, invalidationChannel, msg).Err(); pubErr != nil {
+		err = errors.Join(err, pubErr)
+	}
+
+	return err
+}
+
+// Subscribe evicts local entries deleted by other replicas until ctx is
+// done. The local tier is flushed whenever the subscription is (re)established,
+// since invalidations published in the meantime were missed.
+func (b *TieredBackend) Subscribe(ctx context.Context) {
+	ps := b.rdb.Subscribe(ctx, invalidationChannel)
+	defer ps.Close()
+
+	for {
+		msg, err := ps.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			b.logger.Errorw("cache invalidation subscription failed", "error", err)
+			b.local.purge()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(resubscribeDelay):
+			}
+			continue
+		}
+
+		switch m := msg.(type) {
+		case *redis.Subscription:
+			b.local.purge()
+		case *redis.Message:
+			var keys []string
+			if err := json.Unmarshal([]byte(m.Payload), &keys); err != nil {
+				b.logger.Errorw("invalid cache invalidation message", "payload", m.Payload, "error", err)
+				continue
+			}
+			b.local.delete(keys...)
+		}
+	}
+}
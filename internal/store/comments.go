@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log"
 )
 
@@ -54,7 +55,7 @@ func (s *CommentStore) GetCommentByID(ctx context.Context, post_id int64) ([]Com
 				u.id
 			FROM comments c
 			JOIN users u ON u.id = c.user_id
-			WHERE c.post_id = $1
+			WHERE c.post_id = $1 AND c.is_hidden = false
 			ORDER BY c.created_at DESC;
 	`
 
@@ -91,3 +92,55 @@ func (s *CommentStore) DeleteCommentByPostID(ctx context.Context, post_id int64)
 	}
 	return nil
 }
+
+func (s *CommentStore) Get(ctx context.Context, id int64) (*Comment, error) {
+	query := `
+			SELECT id, post_id, user_id, comments, created_at
+			FROM comments
+			WHERE id = $1 AND is_hidden = false
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var comment Comment
+	err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&comment.ID,
+		&comment.PostID,
+		&comment.UserID,
+		&comment.Comments,
+		&comment.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &comment, nil
+}
+
+func (s *CommentStore) Delete(ctx context.Context, id int64) error {
+	query := `
+			DELETE FROM comments WHERE id = $1;
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,543 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	DataJobExport  = "export"
+	DataJobErasure = "erasure"
+
+	DataJobPending   = "pending"
+	DataJobRunning   = "running"
+	DataJobCompleted = "completed"
+	DataJobFailed    = "failed"
+)
+
+var ErrJobAlreadyOpen = errors.New("a job of this kind is already pending")
+
+// DataJob is a personal data export or erasure, run in the background
+type DataJob struct {
+	ID                int64      `json:"id"`
+	UserID            int64      `json:"user_id"`
+	Kind              string     `json:"kind"`
+	Status            string     `json:"status"`
+	Error             string     `json:"error,omitempty"`
+	ArchiveKey        string     `json:"-"`
+	DownloadExpiresAt *time.Time `json:"download_expires_at,omitempty"`
+	DownloadedAt      *time.Time `json:"downloaded_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	StartedAt         *time.Time `json:"started_at,omitempty"`
+	CompletedAt       *time.Time `json:"completed_at,omitempty"`
+}
+
+// PersonalData is everything an export archive holds about a user
+type PersonalData struct {
+	Profile     User                 `json:"profile"`
+	Posts       []ExportedPost       `json:"posts"`
+	Comments    []ExportedComment    `json:"comments"`
+	Following   []ExportedFollow     `json:"following"`
+	Followers   []ExportedFollow     `json:"followers"`
+	Invitations []ExportedInvitation `json:"invitations"`
+}
+
+type ExportedPost struct {
+	ID        int64    `json:"id"`
+	Title     string   `json:"title"`
+	Content   string   `json:"content"`
+	Tags      []string `json:"tags"`
+	CreatedAt string   `json:"created_at"`
+	UpdatedAt string   `json:"updated_at"`
+}
+
+type ExportedComment struct {
+	ID        int64  `json:"id"`
+	PostID    int64  `json:"post_id"`
+	Comments  string `json:"comments"`
+	CreatedAt string `json:"created_at"`
+}
+
+type ExportedFollow struct {
+	UserID    int64  `json:"user_id"`
+	Username  string `json:"username"`
+	CreatedAt string `json:"created_at"`
+}
+
+type ExportedInvitation struct {
+	Expiry string `json:"expiry"`
+}
+
+// Erasure is what EraseUser removed that has to be cleaned up outside the
+// database once it committed
+type Erasure struct {
+	SessionIDs []string
+	// BlobKeys are the user's export archives and attachment files
+	BlobKeys []string
+}
+
+type DataJobStore struct {
+	db *sql.DB
+}
+
+const dataJobColumns = `
+	id, COALESCE(user_id, 0), kind, status, error, archive_key, download_expires_at,
+	downloaded_at, created_at, started_at, completed_at
+`
+
+func scanDataJob(row interface{ Scan(...any) error }, job *DataJob) error {
+	return row.Scan(
+		&job.ID,
+		&job.UserID,
+		&job.Kind,
+		&job.Status,
+		&job.Error,
+		&job.ArchiveKey,
+		&job.DownloadExpiresAt,
+		&job.DownloadedAt,
+		&job.CreatedAt,
+		&job.StartedAt,
+		&job.CompletedAt,
+	)
+}
+
+func (s *DataJobStore) Create(ctx context.Context, job *DataJob) error {
+	query := `
+			INSERT INTO data_jobs (user_id, kind)
+			VALUES ($1, $2) RETURNING ` + dataJobColumns
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	err := scanDataJob(s.db.QueryRowContext(ctx, query, job.UserID, job.Kind), job)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "idx_data_jobs_open"`:
+			return ErrJobAlreadyOpen
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get returns a job of the given user
+func (s *DataJobStore) Get(ctx context.Context, id int64, userID int64) (*DataJob, error) {
+	query := `SELECT ` + dataJobColumns + ` FROM data_jobs WHERE id = $1 AND user_id = $2`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var job DataJob
+	if err := scanDataJob(s.db.QueryRowContext(ctx, query, id, userID), &job); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &job, nil
+}
+
+func (s *DataJobStore) GetByUser(ctx context.Context, userID int64) ([]DataJob, error) {
+	query := `SELECT ` + dataJobColumns + ` FROM data_jobs WHERE user_id = $1 ORDER BY created_at DESC`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	return s.query(ctx, query, userID)
+}
+
+func (s *DataJobStore) query(ctx context.Context, query string, args ...any) ([]DataJob, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []DataJob{}
+	for rows.Next() {
+		var job DataJob
+		if err := scanDataJob(rows, &job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// ClaimNext marks the oldest pending job as running and returns it. Jobs that
+// have been running for longer than stale, because a replica died while
+// working on them, are picked up again.
+func (s *DataJobStore) ClaimNext(ctx context.Context, stale time.Duration) (*DataJob, error) {
+	query := `
+			UPDATE data_jobs
+			SET status = 'running', started_at = NOW()
+			WHERE id = (
+				SELECT id FROM data_jobs
+				WHERE status = 'pending' OR (status = 'running' AND started_at < $1)
+				ORDER BY created_at
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING ` + dataJobColumns
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var job DataJob
+	if err := scanDataJob(s.db.QueryRowContext(ctx, query, time.Now().Add(-stale)), &job); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &job, nil
+}
+
+func (s *DataJobStore) Complete(ctx context.Context, id int64) error {
+	query := `UPDATE data_jobs SET status = 'completed', completed_at = NOW() WHERE id = $1`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// CompleteExport stores where the archive is and the hashed token that
+// downloads it until expiresAt
+func (s *DataJobStore) CompleteExport(ctx context.Context, id int64, archiveKey string, hashtoken string, expiresAt time.Time) error {
+	query := `
+			UPDATE data_jobs
+			SET status = 'completed', completed_at = NOW(), archive_key = $1,
+				download_token = $2, download_expires_at = $3
+			WHERE id = $4
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, archiveKey, hashtoken, expiresAt, id)
+	return err
+}
+
+func (s *DataJobStore) Fail(ctx context.Context, id int64, reason string) error {
+	query := `UPDATE data_jobs SET status = 'failed', error = $1, completed_at = NOW() WHERE id = $2`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, reason, id)
+	return err
+}
+
+// ConsumeDownload marks the export behind the token as downloaded and returns
+// it, so the link works only once
+func (s *DataJobStore) ConsumeDownload(ctx context.Context, hashtoken string) (*DataJob, error) {
+	query := `
+			UPDATE data_jobs
+			SET downloaded_at = NOW()
+			WHERE download_token = $1 AND status = 'completed' AND archive_key <> ''
+				AND downloaded_at IS NULL AND download_expires_at > NOW()
+			RETURNING ` + dataJobColumns
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var job DataJob
+	if err := scanDataJob(s.db.QueryRowContext(ctx, query, hashtoken), &job); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrInvalidToken
+		default:
+			return nil, err
+		}
+	}
+
+	return &job, nil
+}
+
+// GetStaleArchives returns exports whose archive was downloaded or whose link
+// expired, and can be deleted from the blob store
+func (s *DataJobStore) GetStaleArchives(ctx context.Context, limit int) ([]DataJob, error) {
+	query := `
+			SELECT ` + dataJobColumns + `
+			FROM data_jobs
+			WHERE archive_key <> '' AND (downloaded_at IS NOT NULL OR download_expires_at < NOW())
+			ORDER BY completed_at
+			LIMIT $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	return s.query(ctx, query, limit)
+}
+
+func (s *DataJobStore) ClearArchive(ctx context.Context, id int64) error {
+	query := `UPDATE data_jobs SET archive_key = '', download_token = NULL WHERE id = $1`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// CollectPersonalData reads everything stored about a user for an export
+func (s *DataJobStore) CollectPersonalData(ctx context.Context, userID int64) (*PersonalData, error) {
+	data := &PersonalData{
+		Posts:       []ExportedPost{},
+		Comments:    []ExportedComment{},
+		Following:   []ExportedFollow{},
+		Followers:   []ExportedFollow{},
+		Invitations: []ExportedInvitation{},
+	}
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		profile := `
+			SELECT id, username, email, created_at, is_active, is_suspended,
+				disabled_at IS NOT NULL, display_name, bio, avatar_url
+			FROM users WHERE id = $1
+		`
+		err := tx.QueryRowContext(ctx, profile, userID).Scan(
+			&data.Profile.ID,
+			&data.Profile.Username,
+			&data.Profile.Email,
+			&data.Profile.CreatedAt,
+			&data.Profile.IsActivated,
+			&data.Profile.IsSuspended,
+			&data.Profile.IsDisabled,
+			&data.Profile.DisplayName,
+			&data.Profile.Bio,
+			&data.Profile.AvatarURL,
+		)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		posts := `SELECT id, title, content, COALESCE(tags, '{}'), created_at, updated_at FROM posts WHERE user_id = $1 ORDER BY id`
+		err = queryRows(ctx, tx, posts, []any{userID}, func(rows *sql.Rows) error {
+			var p ExportedPost
+			if err := rows.Scan(&p.ID, &p.Title, &p.Content, pq.Array(&p.Tags), &p.CreatedAt, &p.UpdatedAt); err != nil {
+				return err
+			}
+			data.Posts = append(data.Posts, p)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		comments := `SELECT id, post_id, comments, created_at FROM comments WHERE user_id = $1 ORDER BY id`
+		err = queryRows(ctx, tx, comments, []any{userID}, func(rows *sql.Rows) error {
+			var c ExportedComment
+			if err := rows.Scan(&c.ID, &c.PostID, &c.Comments, &c.CreatedAt); err != nil {
+				return err
+			}
+			data.Comments = append(data.Comments, c)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		following := `
+			SELECT u.id, u.username, f.created_at
+			FROM followers f JOIN users u ON u.id = f.user_id
+			WHERE f.follower_id = $1 ORDER BY f.created_at
+		`
+		err = queryRows(ctx, tx, following, []any{userID}, func(rows *sql.Rows) error {
+			var f ExportedFollow
+			if err := rows.Scan(&f.UserID, &f.Username, &f.CreatedAt); err != nil {
+				return err
+			}
+			data.Following = append(data.Following, f)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		followers := `
+			SELECT u.id, u.username, f.created_at
+			FROM followers f JOIN users u ON u.id = f.follower_id
+			WHERE f.user_id = $1 ORDER BY f.created_at
+		`
+		err = queryRows(ctx, tx, followers, []any{userID}, func(rows *sql.Rows) error {
+			var f ExportedFollow
+			if err := rows.Scan(&f.UserID, &f.Username, &f.CreatedAt); err != nil {
+				return err
+			}
+			data.Followers = append(data.Followers, f)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		invitations := `SELECT expiry FROM user_invitation WHERE user_id = $1 ORDER BY expiry`
+		return queryRows(ctx, tx, invitations, []any{userID}, func(rows *sql.Rows) error {
+			var i ExportedInvitation
+			if err := rows.Scan(&i.Expiry); err != nil {
+				return err
+			}
+			data.Invitations = append(data.Invitations, i)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func queryRows(ctx context.Context, tx *sql.Tx, query string, args []any, scan func(*sql.Rows) error) error {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// EraseUser removes the personal data of a user. The user row is kept as an
+// anonymous placeholder so content and moderation history stay consistent.
+// With deleteContent their posts and comments are deleted, otherwise they stay
+// attributed to the placeholder. Their uploads are deleted either way and the
+// reasons of their reports are cleared. It returns the sessions it ended and
+// the blobs the caller has to delete.
+func (s *DataJobStore) EraseUser(ctx context.Context, userID int64, deleteContent bool) (*Erasure, error) {
+	erasure := &Erasure{}
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		var email string
+		if err := tx.QueryRowContext(ctx, `SELECT email FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&email); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		if deleteContent {
+			// comments on the user's posts go with the posts
+			if _, err := tx.ExecContext(ctx, `DELETE FROM comments WHERE user_id = $1`, userID); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `DELETE FROM posts WHERE user_id = $1`, userID); err != nil {
+				return err
+			}
+		}
+
+		anonymize := `
+			UPDATE users
+			SET username = 'deleted-' || id, email = 'deleted-' || id || '@invalid',
+				password = ''::bytea, display_name = '', bio = '', avatar_url = '',
+				is_active = false
+			WHERE id = $1
+		`
+		if _, err := tx.ExecContext(ctx, anonymize, userID); err != nil {
+			return err
+		}
+
+		sessions := `DELETE FROM sessions WHERE user_id = $1 RETURNING id`
+		err := queryRows(ctx, tx, sessions, []any{userID}, func(rows *sql.Rows) error {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			erasure.SessionIDs = append(erasure.SessionIDs, id)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// previous exports are a full copy of the data being erased
+		archives := `
+			WITH exports AS (
+				SELECT id, archive_key FROM data_jobs
+				WHERE user_id = $1 AND archive_key <> ''
+				FOR UPDATE
+			)
+			UPDATE data_jobs d SET archive_key = '', download_token = NULL
+			FROM exports WHERE d.id = exports.id
+			RETURNING exports.archive_key
+		`
+		err = queryRows(ctx, tx, archives, []any{userID}, func(rows *sql.Rows) error {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				return err
+			}
+			erasure.BlobKeys = append(erasure.BlobKeys, key)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		attachments := `DELETE FROM attachments WHERE user_id = $1 RETURNING blob_key, thumbnail_key`
+		err = queryRows(ctx, tx, attachments, []any{userID}, func(rows *sql.Rows) error {
+			var blobKey, thumbnailKey string
+			if err := rows.Scan(&blobKey, &thumbnailKey); err != nil {
+				return err
+			}
+			erasure.BlobKeys = append(erasure.BlobKeys, blobKey, thumbnailKey)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, query := range []string{
+			`DELETE FROM followers WHERE user_id = $1 OR follower_id = $1`,
+			`DELETE FROM user_invitation WHERE user_id = $1`,
+			`DELETE FROM password_resets WHERE user_id = $1`,
+			`DELETE FROM email_changes WHERE user_id = $1`,
+			`DELETE FROM recovery_codes WHERE user_id = $1`,
+			`DELETE FROM user_two_factor WHERE user_id = $1`,
+			`DELETE FROM external_identities WHERE user_id = $1`,
+			`DELETE FROM api_keys WHERE user_id = $1`,
+			`UPDATE reports SET reason = '' WHERE reporter_id = $1`,
+		} {
+			if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+				return err
+			}
+		}
+
+		// failed logins are keyed by the address
+		if _, err := tx.ExecContext(ctx, `DELETE FROM login_attempts WHERE key = 'email:' || lower($1)`, email); err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return erasure, nil
+}
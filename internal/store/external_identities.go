@@ -0,0 +1,150 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ExternalIdentity links a local user to an account at an OIDC provider
+type ExternalIdentity struct {
+	ID        int64  `json:"id"`
+	UserID    int64  `json:"user_id"`
+	Provider  string `json:"provider"`
+	Subject   string `json:"-"`
+	Email     string `json:"email"`
+	CreatedAt string `json:"created_at"`
+}
+
+// OIDCLoginState is kept between redirecting to the provider and its callback
+type OIDCLoginState struct {
+	State        string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+}
+
+type ExternalIdentityStore struct {
+	db *sql.DB
+}
+
+// GetUserID returns the local user linked to the provider account
+func (s *ExternalIdentityStore) GetUserID(ctx context.Context, provider, subject string) (int64, error) {
+	query := `
+			SELECT user_id FROM external_identities
+			WHERE provider = $1 AND subject = $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var userID int64
+	err := s.db.QueryRowContext(ctx, query, provider, subject).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
+// Link adds a provider account to an existing user
+func (s *ExternalIdentityStore) Link(ctx context.Context, identity *ExternalIdentity) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	return s.create(ctx, s.db, identity)
+}
+
+// CreateUser creates an activated user without a password together with its
+// provider account. The provider has already verified the email.
+func (s *ExternalIdentityStore) CreateUser(ctx context.Context, user *User, identity *ExternalIdentity) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		users := &UsersStore{s.db}
+
+		user.Password.hash = []byte{}
+		if err := users.Create(ctx, tx, user); err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		if _, err := tx.ExecContext(ctx, `UPDATE users SET is_active = true WHERE id = $1`, user.ID); err != nil {
+			return err
+		}
+		user.IsActivated = true
+
+		identity.UserID = user.ID
+		return s.create(ctx, tx, identity)
+	})
+}
+
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (s *ExternalIdentityStore) create(ctx context.Context, db rowQuerier, identity *ExternalIdentity) error {
+	query := `
+			INSERT INTO external_identities (user_id, provider, subject, email)
+			VALUES ($1, $2, $3, $4) RETURNING id, created_at
+	`
+
+	return db.QueryRowContext(
+		ctx,
+		query,
+		identity.UserID,
+		identity.Provider,
+		identity.Subject,
+		identity.Email,
+	).Scan(
+		&identity.ID,
+		&identity.CreatedAt,
+	)
+}
+
+func (s *ExternalIdentityStore) CreateLoginState(ctx context.Context, state *OIDCLoginState, exp time.Duration) error {
+	query := `
+			INSERT INTO oidc_login_states (state, provider, nonce, code_verifier, expiry)
+			VALUES ($1, $2, $3, $4, $5)
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, state.State, state.Provider, state.Nonce, state.CodeVerifier, time.Now().Add(exp))
+	return err
+}
+
+// ConsumeLoginState returns and deletes an unexpired login state, so each
+// callback can only be completed once
+func (s *ExternalIdentityStore) ConsumeLoginState(ctx context.Context, hashState, provider string) (*OIDCLoginState, error) {
+	query := `
+			DELETE FROM oidc_login_states
+			WHERE state = $1 AND provider = $2 AND expiry > $3
+			RETURNING state, provider, nonce, code_verifier
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var state OIDCLoginState
+	err := s.db.QueryRowContext(ctx, query, hashState, provider, time.Now()).Scan(
+		&state.State,
+		&state.Provider,
+		&state.Nonce,
+		&state.CodeVerifier,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrInvalidToken
+		default:
+			return nil, err
+		}
+	}
+
+	return &state, nil
+}
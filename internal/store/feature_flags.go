@@ -0,0 +1,168 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+var ErrDuplicateFlag = errors.New("a feature flag with that key already exists")
+
+type FeatureFlag struct {
+	Key               string  `json:"key"`
+	Description       string  `json:"description"`
+	Enabled           bool    `json:"enabled"`
+	RolloutPercentage int     `json:"rollout_percentage"`
+	AllowedUserIDs    []int64 `json:"allowed_user_ids"`
+	Version           int     `json:"version"`
+	CreatedAt         string  `json:"created_at"`
+	UpdatedAt         string  `json:"updated_at"`
+}
+
+type FeatureFlagStore struct {
+	db *sql.DB
+}
+
+func (s *FeatureFlagStore) GetAll(ctx context.Context) ([]FeatureFlag, error) {
+	query := `
+	SELECT key, description, enabled, rollout_percentage, allowed_user_ids, version, created_at, updated_at
+	FROM feature_flags
+	ORDER BY key
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	flags := []FeatureFlag{}
+	for rows.Next() {
+		var flag FeatureFlag
+		err := rows.Scan(
+			&flag.Key,
+			&flag.Description,
+			&flag.Enabled,
+			&flag.RolloutPercentage,
+			pq.Array(&flag.AllowedUserIDs),
+			&flag.Version,
+			&flag.CreatedAt,
+			&flag.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		flags = append(flags, flag)
+	}
+
+	return flags, rows.Err()
+}
+
+func (s *FeatureFlagStore) Get(ctx context.Context, key string) (*FeatureFlag, error) {
+	query := `
+	SELECT key, description, enabled, rollout_percentage, allowed_user_ids, version, created_at, updated_at
+	FROM feature_flags
+	WHERE key = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var flag FeatureFlag
+	err := s.db.QueryRowContext(ctx, query, key).Scan(
+		&flag.Key,
+		&flag.Description,
+		&flag.Enabled,
+		&flag.RolloutPercentage,
+		pq.Array(&flag.AllowedUserIDs),
+		&flag.Version,
+		&flag.CreatedAt,
+		&flag.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &flag, nil
+}
+
+func (s *FeatureFlagStore) Create(ctx context.Context, flag *FeatureFlag) error {
+	query := `
+	INSERT INTO feature_flags (key, description, enabled, rollout_percentage, allowed_user_ids)
+	VALUES ($1, $2, $3, $4, $5) RETURNING version, created_at, updated_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		flag.Key,
+		flag.Description,
+		flag.Enabled,
+		flag.RolloutPercentage,
+		pq.Array(flag.AllowedUserIDs),
+	).Scan(
+		&flag.Version,
+		&flag.CreatedAt,
+		&flag.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "feature_flags_pkey"`:
+			return ErrDuplicateFlag
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Update saves the flag if it is still at flag.Version and bumps the version.
+func (s *FeatureFlagStore) Update(ctx context.Context, flag *FeatureFlag) error {
+	query := `
+	UPDATE feature_flags
+	SET description = $1, enabled = $2, rollout_percentage = $3, allowed_user_ids = $4, version = version + 1, updated_at = NOW()
+	WHERE key = $5 AND version = $6
+	RETURNING version, updated_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		flag.Description,
+		flag.Enabled,
+		flag.RolloutPercentage,
+		pq.Array(flag.AllowedUserIDs),
+		flag.Key,
+		flag.Version,
+	).Scan(
+		&flag.Version,
+		&flag.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
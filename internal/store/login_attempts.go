@@ -0,0 +1,104 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LoginAttempt counts consecutive failed logins for one key, e.g. an email
+// address or a client IP
+type LoginAttempt struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   sql.NullTime
+}
+
+type LoginAttemptStore struct {
+	db *sql.DB
+}
+
+func (s *LoginAttemptStore) Get(ctx context.Context, key string) (*LoginAttempt, error) {
+	query := `
+			SELECT key, failures, last_failure_at, locked_until
+			FROM login_attempts
+			WHERE key = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var attempt LoginAttempt
+	err := s.db.QueryRowContext(ctx, query, key).Scan(
+		&attempt.Key,
+		&attempt.Failures,
+		&attempt.LastFailureAt,
+		&attempt.LockedUntil,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &attempt, nil
+}
+
+// RecordFailure adds a failed login for key. The count starts over when the
+// last failure is older than window or a previous lockout has expired. Once
+// the count reaches threshold the key is locked for lockout.
+func (s *LoginAttemptStore) RecordFailure(ctx context.Context, key string, window time.Duration, threshold int, lockout time.Duration) (*LoginAttempt, error) {
+	query := `
+		WITH previous AS (
+			SELECT CASE
+				WHEN last_failure_at < NOW() - make_interval(secs => $2)
+					OR locked_until < NOW() THEN 0
+				ELSE failures
+			END AS failures
+			FROM login_attempts
+			WHERE key = $1
+		), next AS (
+			SELECT COALESCE((SELECT failures FROM previous), 0) + 1 AS failures
+		)
+		INSERT INTO login_attempts (key, failures, last_failure_at, locked_until)
+		SELECT $1, next.failures, NOW(),
+			CASE WHEN next.failures >= $3 THEN NOW() + make_interval(secs => $4) END
+		FROM next
+		ON CONFLICT (key) DO UPDATE
+			SET failures = EXCLUDED.failures,
+				last_failure_at = EXCLUDED.last_failure_at,
+				locked_until = EXCLUDED.locked_until
+		RETURNING key, failures, last_failure_at, locked_until
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var attempt LoginAttempt
+	err := s.db.QueryRowContext(ctx, query, key, window.Seconds(), threshold, lockout.Seconds()).Scan(
+		&attempt.Key,
+		&attempt.Failures,
+		&attempt.LastFailureAt,
+		&attempt.LockedUntil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &attempt, nil
+}
+
+// Reset forgets the failed logins of key after a successful login
+func (s *LoginAttemptStore) Reset(ctx context.Context, key string) error {
+	query := `
+			DELETE FROM login_attempts WHERE key = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, key)
+	return err
+}
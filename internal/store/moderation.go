@@ -0,0 +1,342 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+const (
+	ReportTargetPost    = "post"
+	ReportTargetComment = "comment"
+	ReportTargetUser    = "user"
+
+	ReportStatusOpen      = "open"
+	ReportStatusDismissed = "dismissed"
+	ReportStatusResolved  = "resolved"
+
+	ModerationActionDismiss = "dismiss"
+	ModerationActionHide    = "hide"
+	ModerationActionWarn    = "warn"
+	ModerationActionSuspend = "suspend"
+)
+
+var (
+	ErrInvalidReportTarget     = errors.New("invalid report target")
+	ErrInvalidModerationAction = errors.New("action not allowed for this report target")
+)
+
+// reportTargetTables maps a report target type to the table holding it
+var reportTargetTables = map[string]string{
+	ReportTargetPost:    "posts",
+	ReportTargetComment: "comments",
+	ReportTargetUser:    "users",
+}
+
+type Report struct {
+	ID         int64   `json:"id"`
+	ReporterID int64   `json:"reporter_id"`
+	TargetType string  `json:"target_type"`
+	TargetID   int64   `json:"target_id"`
+	Reason     string  `json:"reason"`
+	Status     string  `json:"status"`
+	CreatedAt  string  `json:"created_at"`
+	ResolvedAt *string `json:"resolved_at"`
+}
+
+type ModerationAction struct {
+	ID           int64  `json:"id"`
+	ReportID     int64  `json:"report_id"`
+	ModeratorID  int64  `json:"moderator_id"`
+	Action       string `json:"action"`
+	TargetType   string `json:"target_type"`
+	TargetID     int64  `json:"target_id"`
+	TargetUserID *int64 `json:"target_user_id"`
+	Note         string `json:"note"`
+	CreatedAt    string `json:"created_at"`
+}
+
+type ModerationStore struct {
+	db *sql.DB
+}
+
+func (s *ModerationStore) CreateReport(ctx context.Context, report *Report) error {
+	table, ok := reportTargetTables[report.TargetType]
+	if !ok {
+		return ErrInvalidReportTarget
+	}
+
+	// only insert the report when the reported content exists
+	query := `
+			INSERT INTO reports (reporter_id, target_type, target_id, reason)
+			SELECT $1, $2, $3, $4
+			WHERE EXISTS (SELECT 1 FROM ` + table + ` WHERE id = $3)
+			RETURNING id, status, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		report.ReporterID,
+		report.TargetType,
+		report.TargetID,
+		report.Reason,
+	).Scan(
+		&report.ID,
+		&report.Status,
+		&report.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *ModerationStore) GetReports(ctx context.Context, rq PaginatedReportQuery) ([]Report, error) {
+	query := `
+			SELECT id, reporter_id, target_type, target_id, reason, status, created_at, resolved_at
+			FROM reports
+			WHERE status = $1
+			ORDER BY created_at ` + rq.Sort + `
+			LIMIT $2
+			OFFSET $3
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, rq.Status, rq.Limit, rq.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []Report{}
+	for rows.Next() {
+		var report Report
+		err := rows.Scan(
+			&report.ID,
+			&report.ReporterID,
+			&report.TargetType,
+			&report.TargetID,
+			&report.Reason,
+			&report.Status,
+			&report.CreatedAt,
+			&report.ResolvedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+
+	return reports, rows.Err()
+}
+
+// Resolve applies a moderator action to an open report and records it.
+// The action's TargetUserID is filled in when the action affects a user account.
+func (s *ModerationStore) Resolve(ctx context.Context, action *ModerationAction) error {
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+
+		report, err := s.getOpenReport(ctx, tx, action.ReportID)
+		if err != nil {
+			return err
+		}
+
+		action.TargetType = report.TargetType
+		action.TargetID = report.TargetID
+
+		status := ReportStatusResolved
+		switch action.Action {
+		case ModerationActionDismiss:
+			status = ReportStatusDismissed
+		case ModerationActionHide:
+			if err := s.hideContent(ctx, tx, report); err != nil {
+				return err
+			}
+		case ModerationActionWarn, ModerationActionSuspend:
+			userID, err := s.getTargetUserID(ctx, tx, report)
+			if err != nil {
+				return err
+			}
+			action.TargetUserID = &userID
+
+			if action.Action == ModerationActionSuspend {
+				if err := s.suspendUser(ctx, tx, userID); err != nil {
+					return err
+				}
+			}
+		default:
+			return ErrInvalidModerationAction
+		}
+
+		if err := s.createAction(ctx, tx, action); err != nil {
+			return err
+		}
+
+		return s.updateReportStatus(ctx, tx, report.ID, status)
+	})
+}
+
+// GetTargetUserID returns the account responsible for the content of an open
+// report, so an action can be vetted before it is resolved
+func (s *ModerationStore) GetTargetUserID(ctx context.Context, reportID int64) (int64, error) {
+	query := `
+			SELECT target_type, target_id
+			FROM reports
+			WHERE id = $1 AND status = $2
+	`
+	qctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var report Report
+	err := s.db.QueryRowContext(qctx, query, reportID, ReportStatusOpen).Scan(
+		&report.TargetType,
+		&report.TargetID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return s.getTargetUserID(ctx, s.db, &report)
+}
+
+func (s *ModerationStore) getOpenReport(ctx context.Context, tx *sql.Tx, reportID int64) (*Report, error) {
+	query := `
+			SELECT id, target_type, target_id
+			FROM reports
+			WHERE id = $1 AND status = $2
+			FOR UPDATE
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var report Report
+	err := tx.QueryRowContext(ctx, query, reportID, ReportStatusOpen).Scan(
+		&report.ID,
+		&report.TargetType,
+		&report.TargetID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &report, nil
+}
+
+func (s *ModerationStore) hideContent(ctx context.Context, tx *sql.Tx, report *Report) error {
+	if report.TargetType == ReportTargetUser {
+		return ErrInvalidModerationAction
+	}
+
+	query := `
+			UPDATE ` + reportTargetTables[report.TargetType] + `
+			SET is_hidden = true
+			WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	if _, err := tx.ExecContext(ctx, query, report.TargetID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// getTargetUserID returns the account responsible for the reported content
+func (s *ModerationStore) getTargetUserID(ctx context.Context, db rowQuerier, report *Report) (int64, error) {
+	if report.TargetType == ReportTargetUser {
+		return report.TargetID, nil
+	}
+
+	query := `
+			SELECT user_id FROM ` + reportTargetTables[report.TargetType] + `
+			WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var userID int64
+	if err := db.QueryRowContext(ctx, query, report.TargetID).Scan(&userID); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
+func (s *ModerationStore) suspendUser(ctx context.Context, tx *sql.Tx, userID int64) error {
+	query := `
+	UPDATE users
+		SET is_suspended = true
+		WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *ModerationStore) createAction(ctx context.Context, tx *sql.Tx, action *ModerationAction) error {
+	query := `
+			INSERT INTO moderation_actions (report_id, moderator_id, action, target_type, target_id, target_user_id, note)
+			VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	return tx.QueryRowContext(
+		ctx,
+		query,
+		action.ReportID,
+		action.ModeratorID,
+		action.Action,
+		action.TargetType,
+		action.TargetID,
+		action.TargetUserID,
+		action.Note,
+	).Scan(
+		&action.ID,
+		&action.CreatedAt,
+	)
+}
+
+func (s *ModerationStore) updateReportStatus(ctx context.Context, tx *sql.Tx, reportID int64, status string) error {
+	query := `
+	UPDATE reports
+		SET status = $1, resolved_at = NOW()
+		WHERE id = $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	if _, err := tx.ExecContext(ctx, query, status, reportID); err != nil {
+		return err
+	}
+	return nil
+}
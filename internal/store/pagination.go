@@ -80,3 +80,93 @@ func parseTime(s string) string {
 
 	return t.Format(time.DateTime)
 }
+
+type PaginatedReportQuery struct {
+	Limit  int    `json:"limit" validate:"gte=1,lte=100"`
+	Offset int    `json:"offset" validate:"gte=0"`
+	Sort   string `json:"sort" validate:"oneof=asc desc"`
+	Status string `json:"status" validate:"oneof=open dismissed resolved"`
+}
+
+func (rq PaginatedReportQuery) Parse(w http.ResponseWriter, r *http.Request) (PaginatedReportQuery, error) {
+
+	qs := r.URL.Query()
+
+	limitStr := qs.Get("limit")
+	if limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil {
+			return rq, err
+		}
+		rq.Limit = limit
+	}
+
+	offsetStr := qs.Get("offset")
+	if offsetStr != "" {
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil {
+			return rq, err
+		}
+		rq.Offset = offset
+	}
+
+	sort := qs.Get("sort")
+	if sort != "" {
+		rq.Sort = sort
+	}
+
+	status := qs.Get("status")
+	if status != "" {
+		rq.Status = status
+	}
+
+	return rq, nil
+}
+
+type PaginatedUserQuery struct {
+	Limit  int    `json:"limit" validate:"gte=1,lte=100"`
+	Offset int    `json:"offset" validate:"gte=0"`
+	Sort   string `json:"sort" validate:"oneof=asc desc"`
+	Search string `json:"search" validate:"max=100"`
+	Role   string `json:"role" validate:"max=255"`
+}
+
+func (uq PaginatedUserQuery) Parse(w http.ResponseWriter, r *http.Request) (PaginatedUserQuery, error) {
+
+	qs := r.URL.Query()
+
+	limitStr := qs.Get("limit")
+	if limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil {
+			return uq, err
+		}
+		uq.Limit = limit
+	}
+
+	offsetStr := qs.Get("offset")
+	if offsetStr != "" {
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil {
+			return uq, err
+		}
+		uq.Offset = offset
+	}
+
+	sort := qs.Get("sort")
+	if sort != "" {
+		uq.Sort = sort
+	}
+
+	search := qs.Get("search")
+	if search != "" {
+		uq.Search = search
+	}
+
+	role := qs.Get("role")
+	if role != "" {
+		uq.Role = role
+	}
+
+	return uq, nil
+}
@@ -9,16 +9,17 @@ import (
 )
 
 type Post struct {
-	ID        int64     `json:"id"`
-	Version   int64     `json:"version"`
-	Content   string    `json:"content"`
-	Title     string    `json:"title"`
-	UserID    int64     `json:"user_id"`
-	Tags      []string  `json:"tags"`
-	CreatedAt string    `json:"created_at"`
-	UpdatedAt string    `json:"updated_at"`
-	Comments  []Comment `json:"comments"`
-	User      User      `json:"user"`
+	ID          int64        `json:"id"`
+	Version     int64        `json:"version"`
+	Content     string       `json:"content"`
+	Title       string       `json:"title"`
+	UserID      int64        `json:"user_id"`
+	Tags        []string     `json:"tags"`
+	CreatedAt   string       `json:"created_at"`
+	UpdatedAt   string       `json:"updated_at"`
+	Comments    []Comment    `json:"comments"`
+	User        User         `json:"user"`
+	Attachments []Attachment `json:"attachments"`
 }
 
 type Feed struct {
@@ -61,7 +62,7 @@ func (s *PostsStore) Get(ctx context.Context, id int64) (*Post, error) {
 	query := `
 			SELECT id, content, title, user_id, tags, created_at, updated_at, version
 			FROM posts
-			WHERE id = $1
+			WHERE id = $1 AND is_hidden = false
 	`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
 	defer cancel()
@@ -90,47 +91,60 @@ func (s *PostsStore) Get(ctx context.Context, id int64) (*Post, error) {
 	return &post, nil
 }
 
-func (s *PostsStore) DeletePost(ctx context.Context, id int64) (*Post, error) {
+// DeletePost removes a post and its comments, provided the post is still at
+// the given version.
+func (s *PostsStore) DeletePost(ctx context.Context, id int64, version int64) (*Post, error) {
 
-	query := `
-			DELETE FROM posts WHERE id = $1 RETURNING title;
-			`
-	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
-	defer cancel()
 	var post Post
-	err := s.db.QueryRowContext(ctx, query, id).Scan(&post.Title)
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			DELETE FROM posts WHERE id = $1 AND version = $2 RETURNING title;
+			`
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
 
-	if err != nil {
+		// comments reference the post, so they go first within the same transaction
+		if _, err := tx.ExecContext(qctx, `DELETE FROM comments WHERE post_id = $1`, id); err != nil {
+			return err
+		}
 
-		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			return nil, ErrRecordNotFound
-		default:
-			return nil, err
+		if err := tx.QueryRowContext(qctx, query, id, version).Scan(&post.Title); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
 		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return &post, nil
 
 }
 
+// UpdatePost saves the post if it is still at post.Version and bumps the version.
 func (s *PostsStore) UpdatePost(ctx context.Context, post *Post) error {
 	query := `
 		UPDATE posts
-		SET title = $1, content=$2, version= version + 1
+		SET title = $1, content=$2, version= version + 1, updated_at = NOW()
 		WHERE id = $3 AND version=$4
-		RETURNING version
+		RETURNING version, updated_at
 		;
 		`
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
 	defer cancel()
 
-	err := s.db.QueryRowContext(ctx, query, post.Title, post.Content, post.ID, post.Version).Scan(&post.Version)
+	err := s.db.QueryRowContext(ctx, query, post.Title, post.Content, post.ID, post.Version).Scan(&post.Version, &post.UpdatedAt)
 
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrRecordNotFound
+			return ErrEditConflict
 		default:
 			return err
 		}
@@ -157,6 +171,7 @@ FROM
   LEFT JOIN followers f ON f.follower_id = p.user_id -- author
   AND f.user_id = $1 -- viewer only
   LEFT JOIN comments c ON c.post_id = p.id
+  AND c.is_hidden = false
 WHERE
   (p.user_id = $1 -- my posts
   OR f.user_id IS NOT NULL
@@ -165,6 +180,8 @@ WHERE
   (p.title ILIKE '%' || $4 || '%' OR p.content ILIKE '%' || $4 || '%')
   AND
   (p.tags @> $5 OR $5 = '{}')
+  AND
+  p.is_hidden = false
 GROUP BY
   p.id,
   u.username
@@ -213,3 +230,28 @@ OFFSET $3;
 
 	return &feeds, nil
 }
+
+// GetIDsByUser returns the IDs of the posts a user authored, including hidden ones
+func (s *PostsStore) GetIDsByUser(ctx context.Context, userID int64) ([]int64, error) {
+	query := `SELECT id FROM posts WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
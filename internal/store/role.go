@@ -3,33 +3,249 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
 )
 
 type Role struct {
-	ID    int64  `json:"id"`
-	Name  string `json:"name"`
-	Level int    `json:"level"`
+	ID          int64    `json:"id"`
+	Name        string   `json:"name"`
+	Level       int      `json:"level"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+type Permission struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
 }
 
+var (
+	ErrDuplicateRole     = errors.New("a role with that name already exists")
+	ErrUnknownPermission = errors.New("unknown permission")
+)
+
 type RoleStore struct {
 	db *sql.DB
 }
 
-func (s *RoleStore) HasPermission(ctx context.Context, requiredRole string, userRoleLevel int) (bool, error) {
+// GetRolePermissions returns the permission names granted to each role, keyed by role ID
+func (s *RoleStore) GetRolePermissions(ctx context.Context) (map[int64][]string, error) {
+	query := `
+	SELECT rp.role_id, p.name
+	FROM role_permissions rp
+	JOIN permissions p ON p.id = rp.permission_id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := make(map[int64][]string)
+	for rows.Next() {
+		var roleID int64
+		var name string
+		if err := rows.Scan(&roleID, &name); err != nil {
+			return nil, err
+		}
+		permissions[roleID] = append(permissions[roleID], name)
+	}
+
+	return permissions, rows.Err()
+}
+
+func (s *RoleStore) GetPermissions(ctx context.Context) ([]Permission, error) {
+	query := `
+	SELECT id, name, COALESCE(description, '')
+	FROM permissions
+	ORDER BY name
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []Permission{}
+	for rows.Next() {
+		var permission Permission
+		if err := rows.Scan(&permission.ID, &permission.Name, &permission.Description); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	return permissions, rows.Err()
+}
+
+func (s *RoleStore) GetAll(ctx context.Context) ([]Role, error) {
+	query := `
+	SELECT
+		r.id,
+		r.name,
+		r.level,
+		COALESCE(r.description, ''),
+		ARRAY_REMOVE(ARRAY_AGG(p.name ORDER BY p.name), NULL)
+	FROM roles r
+	LEFT JOIN role_permissions rp ON rp.role_id = r.id
+	LEFT JOIN permissions p ON p.id = rp.permission_id
+	GROUP BY r.id
+	ORDER BY r.level
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []Role{}
+	for rows.Next() {
+		var role Role
+		err := rows.Scan(
+			&role.ID,
+			&role.Name,
+			&role.Level,
+			&role.Description,
+			pq.Array(&role.Permissions),
+		)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	return roles, rows.Err()
+}
+
+func (s *RoleStore) Create(ctx context.Context, role *Role) error {
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+		INSERT INTO roles (name, level, description)
+		VALUES ($1, $2, $3) RETURNING id
+		`
+
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		err := tx.QueryRowContext(qctx, query, role.Name, role.Level, role.Description).Scan(&role.ID)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "roles_name_key"`:
+				return ErrDuplicateRole
+			default:
+				return err
+			}
+		}
+
+		return s.setPermissions(ctx, tx, role.ID, role.Permissions)
+	})
+}
+
+// SetPermissions replaces the permissions granted to a role
+func (s *RoleStore) SetPermissions(ctx context.Context, roleID int64, permissions []string) error {
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		if err := s.lockRole(ctx, tx, roleID); err != nil {
+			return err
+		}
+
+		query := `
+		DELETE FROM role_permissions
+		WHERE role_id = $1
+		`
+
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		if _, err := tx.ExecContext(qctx, query, roleID); err != nil {
+			return err
+		}
+
+		return s.setPermissions(ctx, tx, roleID, permissions)
+	})
+}
+
+func (s *RoleStore) lockRole(ctx context.Context, tx *sql.Tx, roleID int64) error {
 	query := `
-	SELECT level
-	FROM roles
-	WHERE name = $1	
+	SELECT id FROM roles
+	WHERE id = $1
+	FOR UPDATE
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
 	defer cancel()
 
-	var role Role
-	if err := s.db.QueryRowContext(ctx, query, requiredRole).Scan(&role.Level); err != nil {
-		return false, err
+	if err := tx.QueryRowContext(ctx, query, roleID).Scan(&roleID); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
 	}
 
-	return userRoleLevel >= role.Level, nil
+	return nil
+}
+
+func (s *RoleStore) setPermissions(ctx context.Context, tx *sql.Tx, roleID int64, permissions []string) error {
+	if len(permissions) == 0 {
+		return nil
+	}
+
+	query := `
+	INSERT INTO role_permissions (role_id, permission_id)
+	SELECT $1, id
+	FROM permissions
+	WHERE name = ANY($2)
+	ON CONFLICT DO NOTHING
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
 
+	res, err := tx.ExecContext(ctx, query, roleID, pq.Array(permissions))
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// every requested name must match a permission
+	if rows < int64(len(uniqueStrings(permissions))) {
+		return ErrUnknownPermission
+	}
+
+	return nil
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]struct{}, len(values))
+	unique := make([]string, 0, len(values))
+	for _, v := range values {
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		unique = append(unique, v)
+	}
+	return unique
 }
@@ -0,0 +1,197 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Session is one login on one device. Access tokens point to it through
+// their sid claim and stop working once it is revoked.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int64     `json:"user_id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+type SessionStore struct {
+	db *sql.DB
+}
+
+func (s *SessionStore) Create(ctx context.Context, session *Session) error {
+	query := `
+			INSERT INTO sessions (id, user_id, user_agent, ip, expires_at)
+			VALUES ($1, $2, $3, $4, $5) RETURNING created_at, last_seen_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	return s.db.QueryRowContext(
+		ctx,
+		query,
+		session.ID,
+		session.UserID,
+		session.UserAgent,
+		session.IP,
+		session.ExpiresAt,
+	).Scan(
+		&session.CreatedAt,
+		&session.LastSeenAt,
+	)
+}
+
+// Get returns an unexpired session
+func (s *SessionStore) Get(ctx context.Context, id string) (*Session, error) {
+	query := `
+			SELECT id, user_id, user_agent, ip, created_at, last_seen_at, expires_at
+			FROM sessions
+			WHERE id = $1 AND expires_at > NOW()
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var session Session
+	err := s.db.QueryRowContext(ctx, query, id).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.UserAgent,
+		&session.IP,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+		&session.ExpiresAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &session, nil
+}
+
+// GetByUser returns the unexpired sessions of a user, most recently used first
+func (s *SessionStore) GetByUser(ctx context.Context, userID int64) ([]Session, error) {
+	query := `
+			SELECT id, user_id, user_agent, ip, created_at, last_seen_at, expires_at
+			FROM sessions
+			WHERE user_id = $1 AND expires_at > NOW()
+			ORDER BY last_seen_at DESC
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.UserAgent,
+			&session.IP,
+			&session.CreatedAt,
+			&session.LastSeenAt,
+			&session.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// Delete revokes a session of userID
+func (s *SessionStore) Delete(ctx context.Context, id string, userID int64) error {
+	query := `
+			DELETE FROM sessions WHERE id = $1 AND user_id = $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteOthers revokes every session of userID except keepID and returns the
+// revoked IDs
+func (s *SessionStore) DeleteOthers(ctx context.Context, userID int64, keepID string) ([]string, error) {
+	query := `
+			DELETE FROM sessions WHERE user_id = $1 AND id <> $2
+			RETURNING id
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, keepID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func (s *SessionStore) TouchLastSeen(ctx context.Context, id string) error {
+	query := `
+		UPDATE sessions SET last_seen_at = NOW() WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// DeleteExpired removes expired sessions and returns how many were removed.
+// Revoked sessions are deleted when they are revoked.
+func (s *SessionStore) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `
+		DELETE FROM sessions WHERE expires_at < NOW()
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
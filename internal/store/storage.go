@@ -9,24 +9,35 @@ import (
 
 var (
 	ErrRecordNotFound    = errors.New("resource not found")
+	ErrEditConflict      = errors.New("resource was modified or deleted by another request")
 	QueryTimeOutDuration = time.Second * 5
 )
 
 type PostRepository interface {
 	Create(ctx context.Context, post *Post) error
 	Get(ctx context.Context, id int64) (*Post, error)
-	DeletePost(ctx context.Context, id int64) (*Post, error)
+	DeletePost(ctx context.Context, id int64, version int64) (*Post, error)
 	UpdatePost(ctx context.Context, post *Post) error
 	GetFeed(ctx context.Context, user_id int64, fq PaginatedFeedQuery) (*[]Feed, error)
+	GetIDsByUser(ctx context.Context, userID int64) ([]int64, error)
 }
 
 type UserRepository interface {
 	Create(ctx context.Context, tx *sql.Tx, user *User) error
 	GetUserbyID(ctx context.Context, id int64) (*User, error)
 	CreateandInvite(ctx context.Context, user *User, token string, invitationExp time.Duration) error
-	Activate(ctx context.Context, hashtoken string) error
+	Activate(ctx context.Context, hashtoken string) (int64, error)
 	Delete(ctx context.Context, id int64) error
 	GetUserByEmail(ctx context.Context, emil string) (*User, error)
+	GetUsers(ctx context.Context, uq PaginatedUserQuery) ([]User, error)
+	UpdateRole(ctx context.Context, userID int64, roleName string) (*Role, error)
+	SetDisabled(ctx context.Context, userID int64, disabled bool) error
+	ForcePasswordReset(ctx context.Context, userID int64, hashtoken string, resetExp time.Duration) ([]string, error)
+	ResetPassword(ctx context.Context, hashtoken string, user *User) ([]string, error)
+	UpdateProfile(ctx context.Context, user *User) error
+	UpdatePassword(ctx context.Context, user *User) error
+	RequestEmailChange(ctx context.Context, userID int64, newEmail string, hashtoken string, exp time.Duration) error
+	ConfirmEmailChange(ctx context.Context, hashtoken string) (int64, error)
 }
 
 type FollowersRepository interface {
@@ -38,26 +49,129 @@ type CommentRepository interface {
 	Create(ctx context.Context, comment *Comment) error
 	GetCommentByID(ctx context.Context, post_id int64) ([]Comment, error)
 	DeleteCommentByPostID(ctx context.Context, post_id int64) error
+	Get(ctx context.Context, id int64) (*Comment, error)
+	Delete(ctx context.Context, id int64) error
+}
+
+type TwoFactorRepository interface {
+	Get(ctx context.Context, userID int64) (*TwoFactor, error)
+	CreatePending(ctx context.Context, userID int64, secret string) error
+	Enable(ctx context.Context, userID int64, step int64, recoveryCodeHashes []string) error
+	UseStep(ctx context.Context, userID int64, step int64) error
+	UseRecoveryCode(ctx context.Context, userID int64, hash string) error
+	Disable(ctx context.Context, userID int64) error
+}
+
+type ExternalIdentityRepository interface {
+	GetUserID(ctx context.Context, provider, subject string) (int64, error)
+	Link(ctx context.Context, identity *ExternalIdentity) error
+	CreateUser(ctx context.Context, user *User, identity *ExternalIdentity) error
+	CreateLoginState(ctx context.Context, state *OIDCLoginState, exp time.Duration) error
+	ConsumeLoginState(ctx context.Context, hashState, provider string) (*OIDCLoginState, error)
+}
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *APIKey) error
+	GetByHash(ctx context.Context, hash string) (*APIKey, error)
+	GetByUser(ctx context.Context, userID int64) ([]APIKey, error)
+	Delete(ctx context.Context, id int64, userID int64) error
+	TouchLastUsed(ctx context.Context, id int64) error
+}
+
+type SessionRepository interface {
+	Create(ctx context.Context, session *Session) error
+	Get(ctx context.Context, id string) (*Session, error)
+	GetByUser(ctx context.Context, userID int64) ([]Session, error)
+	Delete(ctx context.Context, id string, userID int64) error
+	DeleteOthers(ctx context.Context, userID int64, keepID string) ([]string, error)
+	TouchLastSeen(ctx context.Context, id string) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+type DataJobRepository interface {
+	Create(ctx context.Context, job *DataJob) error
+	Get(ctx context.Context, id int64, userID int64) (*DataJob, error)
+	GetByUser(ctx context.Context, userID int64) ([]DataJob, error)
+	ClaimNext(ctx context.Context, stale time.Duration) (*DataJob, error)
+	Complete(ctx context.Context, id int64) error
+	CompleteExport(ctx context.Context, id int64, archiveKey string, hashtoken string, expiresAt time.Time) error
+	Fail(ctx context.Context, id int64, reason string) error
+	ConsumeDownload(ctx context.Context, hashtoken string) (*DataJob, error)
+	GetStaleArchives(ctx context.Context, limit int) ([]DataJob, error)
+	ClearArchive(ctx context.Context, id int64) error
+	CollectPersonalData(ctx context.Context, userID int64) (*PersonalData, error)
+	EraseUser(ctx context.Context, userID int64, deleteContent bool) (*Erasure, error)
+}
+
+type LoginAttemptRepository interface {
+	Get(ctx context.Context, key string) (*LoginAttempt, error)
+	RecordFailure(ctx context.Context, key string, window time.Duration, threshold int, lockout time.Duration) (*LoginAttempt, error)
+	Reset(ctx context.Context, key string) error
 }
 
 type RoleRepository interface {
-	HasPermission(ctx context.Context, requiredRole string, userRoleLevel int) (bool, error)
+	GetRolePermissions(ctx context.Context) (map[int64][]string, error)
+	GetPermissions(ctx context.Context) ([]Permission, error)
+	GetAll(ctx context.Context) ([]Role, error)
+	Create(ctx context.Context, role *Role) error
+	SetPermissions(ctx context.Context, roleID int64, permissions []string) error
+}
+
+type ModerationRepository interface {
+	CreateReport(ctx context.Context, report *Report) error
+	GetReports(ctx context.Context, rq PaginatedReportQuery) ([]Report, error)
+	GetTargetUserID(ctx context.Context, reportID int64) (int64, error)
+	Resolve(ctx context.Context, action *ModerationAction) error
+}
+
+type AttachmentRepository interface {
+	Create(ctx context.Context, attachment *Attachment) error
+	Get(ctx context.Context, id int64) (*Attachment, error)
+	AttachToPost(ctx context.Context, id int64, postID int64, userID int64) error
+	GetByPostIDs(ctx context.Context, postIDs []int64) (map[int64][]Attachment, error)
+	GetOrphans(ctx context.Context, olderThan time.Duration, limit int) ([]Attachment, error)
+	Delete(ctx context.Context, id int64) error
+}
+
+type FeatureFlagRepository interface {
+	GetAll(ctx context.Context) ([]FeatureFlag, error)
+	Get(ctx context.Context, key string) (*FeatureFlag, error)
+	Create(ctx context.Context, flag *FeatureFlag) error
+	Update(ctx context.Context, flag *FeatureFlag) error
 }
 
 type Storage struct {
-	Posts    PostRepository
-	Users    UserRepository
-	Comment  CommentRepository
-	Follower FollowersRepository
-	Role     RoleRepository
+	Posts              PostRepository
+	Users              UserRepository
+	Comment            CommentRepository
+	Follower           FollowersRepository
+	Role               RoleRepository
+	Moderation         ModerationRepository
+	Attachments        AttachmentRepository
+	LoginAttempts      LoginAttemptRepository
+	TwoFactor          TwoFactorRepository
+	ExternalIdentities ExternalIdentityRepository
+	APIKeys            APIKeyRepository
+	Sessions           SessionRepository
+	DataJobs           DataJobRepository
+	FeatureFlags       FeatureFlagRepository
 }
 
 func NewStorage(db *sql.DB) Storage {
 	return Storage{
-		Posts:    &PostsStore{db},
-		Users:    &UsersStore{db},
-		Comment:  &CommentStore{db},
-		Follower: &FollowerStore{db},
-		Role:     &RoleStore{db},
+		Posts:              &PostsStore{db},
+		Users:              &UsersStore{db},
+		Comment:            &CommentStore{db},
+		Follower:           &FollowerStore{db},
+		Role:               &RoleStore{db},
+		Moderation:         &ModerationStore{db},
+		Attachments:        &AttachmentStore{db},
+		LoginAttempts:      &LoginAttemptStore{db},
+		TwoFactor:          &TwoFactorStore{db},
+		ExternalIdentities: &ExternalIdentityStore{db},
+		APIKeys:            &APIKeyStore{db},
+		Sessions:           &SessionStore{db},
+		DataJobs:           &DataJobStore{db},
+		FeatureFlags:       &FeatureFlagStore{db},
 	}
 }
@@ -9,7 +9,8 @@ import (
 func MockNewStorage() Storage {
 	return Storage{
 
-		Users: &MockUserStore{},
+		Users:    &MockUserStore{},
+		Sessions: &MockSessionStore{sessions: make(map[string]*Session)},
 	}
 }
 
@@ -22,6 +23,9 @@ func (m *MockUserStore) Create(ctx context.Context, tx *sql.Tx, u *User) error {
 }
 
 func (m *MockUserStore) GetUserbyID(ctx context.Context, userID int64) (*User, error) {
+	if m.GetUserbyIDFunc != nil {
+		return m.GetUserbyIDFunc(ctx, userID)
+	}
 	return &User{ID: userID}, nil
 }
 
@@ -33,10 +37,115 @@ func (m *MockUserStore) CreateandInvite(ctx context.Context, user *User, token s
 	return nil
 }
 
-func (m *MockUserStore) Activate(ctx context.Context, t string) error {
-	return nil
+func (m *MockUserStore) Activate(ctx context.Context, t string) (int64, error) {
+	return 0, nil
 }
 
 func (m *MockUserStore) Delete(ctx context.Context, id int64) error {
 	return nil
 }
+
+func (m *MockUserStore) GetUsers(ctx context.Context, uq PaginatedUserQuery) ([]User, error) {
+	return []User{}, nil
+}
+
+func (m *MockUserStore) UpdateRole(ctx context.Context, userID int64, roleName string) (*Role, error) {
+	return &Role{Name: roleName}, nil
+}
+
+func (m *MockUserStore) SetDisabled(ctx context.Context, userID int64, disabled bool) error {
+	return nil
+}
+
+func (m *MockUserStore) ForcePasswordReset(ctx context.Context, userID int64, token string, exp time.Duration) ([]string, error) {
+	return nil, nil
+}
+
+func (m *MockUserStore) ResetPassword(ctx context.Context, token string, user *User) ([]string, error) {
+	return nil, nil
+}
+
+func (m *MockUserStore) UpdateProfile(ctx context.Context, user *User) error {
+	return nil
+}
+
+func (m *MockUserStore) UpdatePassword(ctx context.Context, user *User) error {
+	return nil
+}
+
+func (m *MockUserStore) RequestEmailChange(ctx context.Context, userID int64, newEmail string, token string, exp time.Duration) error {
+	return nil
+}
+
+func (m *MockUserStore) ConfirmEmailChange(ctx context.Context, token string) (int64, error) {
+	return 0, nil
+}
+
+// MockSessionStore keeps sessions in memory so tests can log in and revoke
+type MockSessionStore struct {
+	sessions map[string]*Session
+}
+
+func (m *MockSessionStore) Create(ctx context.Context, session *Session) error {
+	session.CreatedAt = time.Now()
+	session.LastSeenAt = session.CreatedAt
+	m.sessions[session.ID] = session
+	return nil
+}
+
+func (m *MockSessionStore) Get(ctx context.Context, id string) (*Session, error) {
+	session, ok := m.sessions[id]
+	if !ok || time.Now().After(session.ExpiresAt) {
+		return nil, ErrRecordNotFound
+	}
+	s := *session
+	return &s, nil
+}
+
+func (m *MockSessionStore) GetByUser(ctx context.Context, userID int64) ([]Session, error) {
+	var sessions []Session
+	for _, session := range m.sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, *session)
+		}
+	}
+	return sessions, nil
+}
+
+func (m *MockSessionStore) Delete(ctx context.Context, id string, userID int64) error {
+	session, ok := m.sessions[id]
+	if !ok || session.UserID != userID {
+		return ErrRecordNotFound
+	}
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *MockSessionStore) DeleteOthers(ctx context.Context, userID int64, keepID string) ([]string, error) {
+	var ids []string
+	for id, session := range m.sessions {
+		if session.UserID == userID && id != keepID {
+			delete(m.sessions, id)
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (m *MockSessionStore) TouchLastSeen(ctx context.Context, id string) error {
+	if session, ok := m.sessions[id]; ok {
+		session.LastSeenAt = time.Now()
+	}
+	return nil
+}
+
+func (m *MockSessionStore) DeleteExpired(ctx context.Context) (int64, error) {
+	var n int64
+	for id, session := range m.sessions {
+		if time.Now().After(session.ExpiresAt) {
+			delete(m.sessions, id)
+			n++
+		}
+	}
+	return n, nil
+}
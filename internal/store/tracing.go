@@ -0,0 +1,241 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("tiago-udemy/internal/store")
+
+// NewTracedStorage records a span for every call to the posts, users and
+// comments repositories, named after the method and tagged with its SQL
+// operation
+func NewTracedStorage(s Storage) Storage {
+	s.Posts = &tracedPostStore{s.Posts}
+	s.Users = &tracedUserStore{s.Users}
+	s.Comment = &tracedCommentStore{s.Comment}
+	return s
+}
+
+// startSpan starts a child span of the request. Calls outside of a trace,
+// e.g. background jobs polling for work, are not traced.
+func startSpan(ctx context.Context, name, operation string) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemNamePostgreSQL, semconv.DBOperationName(operation)),
+	)
+}
+
+// endSpan ends the span, a missing record is an answer and not a failure
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+type tracedPostStore struct {
+	PostRepository
+}
+
+func (s *tracedPostStore) Create(ctx context.Context, post *Post) error {
+	ctx, span := startSpan(ctx, "PostsStore.Create", "INSERT")
+	err := s.PostRepository.Create(ctx, post)
+	endSpan(span, err)
+	return err
+}
+
+func (s *tracedPostStore) Get(ctx context.Context, id int64) (*Post, error) {
+	ctx, span := startSpan(ctx, "PostsStore.Get", "SELECT")
+	post, err := s.PostRepository.Get(ctx, id)
+	endSpan(span, err)
+	return post, err
+}
+
+func (s *tracedPostStore) DeletePost(ctx context.Context, id int64, version int64) (*Post, error) {
+	ctx, span := startSpan(ctx, "PostsStore.DeletePost", "DELETE")
+	post, err := s.PostRepository.DeletePost(ctx, id, version)
+	endSpan(span, err)
+	return post, err
+}
+
+func (s *tracedPostStore) UpdatePost(ctx context.Context, post *Post) error {
+	ctx, span := startSpan(ctx, "PostsStore.UpdatePost", "UPDATE")
+	err := s.PostRepository.UpdatePost(ctx, post)
+	endSpan(span, err)
+	return err
+}
+
+func (s *tracedPostStore) GetFeed(ctx context.Context, userID int64, fq PaginatedFeedQuery) (*[]Feed, error) {
+	ctx, span := startSpan(ctx, "PostsStore.GetFeed", "SELECT")
+	feed, err := s.PostRepository.GetFeed(ctx, userID, fq)
+	endSpan(span, err)
+	return feed, err
+}
+
+func (s *tracedPostStore) GetIDsByUser(ctx context.Context, userID int64) ([]int64, error) {
+	ctx, span := startSpan(ctx, "PostsStore.GetIDsByUser", "SELECT")
+	ids, err := s.PostRepository.GetIDsByUser(ctx, userID)
+	endSpan(span, err)
+	return ids, err
+}
+
+type tracedUserStore struct {
+	UserRepository
+}
+
+func (s *tracedUserStore) Create(ctx context.Context, tx *sql.Tx, user *User) error {
+	ctx, span := startSpan(ctx, "UsersStore.Create", "INSERT")
+	err := s.UserRepository.Create(ctx, tx, user)
+	endSpan(span, err)
+	return err
+}
+
+func (s *tracedUserStore) GetUserbyID(ctx context.Context, id int64) (*User, error) {
+	ctx, span := startSpan(ctx, "UsersStore.GetUserbyID", "SELECT")
+	user, err := s.UserRepository.GetUserbyID(ctx, id)
+	endSpan(span, err)
+	return user, err
+}
+
+func (s *tracedUserStore) CreateandInvite(ctx context.Context, user *User, token string, invitationExp time.Duration) error {
+	ctx, span := startSpan(ctx, "UsersStore.CreateandInvite", "INSERT")
+	err := s.UserRepository.CreateandInvite(ctx, user, token, invitationExp)
+	endSpan(span, err)
+	return err
+}
+
+func (s *tracedUserStore) Activate(ctx context.Context, hashtoken string) (int64, error) {
+	ctx, span := startSpan(ctx, "UsersStore.Activate", "UPDATE")
+	userID, err := s.UserRepository.Activate(ctx, hashtoken)
+	endSpan(span, err)
+	return userID, err
+}
+
+func (s *tracedUserStore) Delete(ctx context.Context, id int64) error {
+	ctx, span := startSpan(ctx, "UsersStore.Delete", "DELETE")
+	err := s.UserRepository.Delete(ctx, id)
+	endSpan(span, err)
+	return err
+}
+
+func (s *tracedUserStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, span := startSpan(ctx, "UsersStore.GetUserByEmail", "SELECT")
+	user, err := s.UserRepository.GetUserByEmail(ctx, email)
+	endSpan(span, err)
+	return user, err
+}
+
+func (s *tracedUserStore) GetUsers(ctx context.Context, uq PaginatedUserQuery) ([]User, error) {
+	ctx, span := startSpan(ctx, "UsersStore.GetUsers", "SELECT")
+	users, err := s.UserRepository.GetUsers(ctx, uq)
+	endSpan(span, err)
+	return users, err
+}
+
+func (s *tracedUserStore) UpdateRole(ctx context.Context, userID int64, roleName string) (*Role, error) {
+	ctx, span := startSpan(ctx, "UsersStore.UpdateRole", "UPDATE")
+	role, err := s.UserRepository.UpdateRole(ctx, userID, roleName)
+	endSpan(span, err)
+	return role, err
+}
+
+func (s *tracedUserStore) SetDisabled(ctx context.Context, userID int64, disabled bool) error {
+	ctx, span := startSpan(ctx, "UsersStore.SetDisabled", "UPDATE")
+	err := s.UserRepository.SetDisabled(ctx, userID, disabled)
+	endSpan(span, err)
+	return err
+}
+
+func (s *tracedUserStore) ForcePasswordReset(ctx context.Context, userID int64, hashtoken string, resetExp time.Duration) ([]string, error) {
+	ctx, span := startSpan(ctx, "UsersStore.ForcePasswordReset", "INSERT")
+	sessionIDs, err := s.UserRepository.ForcePasswordReset(ctx, userID, hashtoken, resetExp)
+	endSpan(span, err)
+	return sessionIDs, err
+}
+
+func (s *tracedUserStore) ResetPassword(ctx context.Context, hashtoken string, user *User) ([]string, error) {
+	ctx, span := startSpan(ctx, "UsersStore.ResetPassword", "UPDATE")
+	sessionIDs, err := s.UserRepository.ResetPassword(ctx, hashtoken, user)
+	endSpan(span, err)
+	return sessionIDs, err
+}
+
+func (s *tracedUserStore) UpdateProfile(ctx context.Context, user *User) error {
+	ctx, span := startSpan(ctx, "UsersStore.UpdateProfile", "UPDATE")
+	err := s.UserRepository.UpdateProfile(ctx, user)
+	endSpan(span, err)
+	return err
+}
+
+func (s *tracedUserStore) UpdatePassword(ctx context.Context, user *User) error {
+	ctx, span := startSpan(ctx, "UsersStore.UpdatePassword", "UPDATE")
+	err := s.UserRepository.UpdatePassword(ctx, user)
+	endSpan(span, err)
+	return err
+}
+
+func (s *tracedUserStore) RequestEmailChange(ctx context.Context, userID int64, newEmail string, hashtoken string, exp time.Duration) error {
+	ctx, span := startSpan(ctx, "UsersStore.RequestEmailChange", "INSERT")
+	err := s.UserRepository.RequestEmailChange(ctx, userID, newEmail, hashtoken, exp)
+	endSpan(span, err)
+	return err
+}
+
+func (s *tracedUserStore) ConfirmEmailChange(ctx context.Context, hashtoken string) (int64, error) {
+	ctx, span := startSpan(ctx, "UsersStore.ConfirmEmailChange", "UPDATE")
+	userID, err := s.UserRepository.ConfirmEmailChange(ctx, hashtoken)
+	endSpan(span, err)
+	return userID, err
+}
+
+type tracedCommentStore struct {
+	CommentRepository
+}
+
+func (s *tracedCommentStore) Create(ctx context.Context, comment *Comment) error {
+	ctx, span := startSpan(ctx, "CommentStore.Create", "INSERT")
+	err := s.CommentRepository.Create(ctx, comment)
+	endSpan(span, err)
+	return err
+}
+
+func (s *tracedCommentStore) GetCommentByID(ctx context.Context, postID int64) ([]Comment, error) {
+	ctx, span := startSpan(ctx, "CommentStore.GetCommentByID", "SELECT")
+	comments, err := s.CommentRepository.GetCommentByID(ctx, postID)
+	endSpan(span, err)
+	return comments, err
+}
+
+func (s *tracedCommentStore) DeleteCommentByPostID(ctx context.Context, postID int64) error {
+	ctx, span := startSpan(ctx, "CommentStore.DeleteCommentByPostID", "DELETE")
+	err := s.CommentRepository.DeleteCommentByPostID(ctx, postID)
+	endSpan(span, err)
+	return err
+}
+
+func (s *tracedCommentStore) Get(ctx context.Context, id int64) (*Comment, error) {
+	ctx, span := startSpan(ctx, "CommentStore.Get", "SELECT")
+	comment, err := s.CommentRepository.Get(ctx, id)
+	endSpan(span, err)
+	return comment, err
+}
+
+func (s *tracedCommentStore) Delete(ctx context.Context, id int64) error {
+	ctx, span := startSpan(ctx, "CommentStore.Delete", "DELETE")
+	err := s.CommentRepository.Delete(ctx, id)
+	endSpan(span, err)
+	return err
+}
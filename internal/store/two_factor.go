@@ -0,0 +1,191 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+var (
+	ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+	ErrCodeAlreadyUsed  = errors.New("code has already been used")
+)
+
+type TwoFactor struct {
+	UserID       int64  `json:"user_id"`
+	Secret       string `json:"-"`
+	Enabled      bool   `json:"enabled"`
+	LastUsedStep int64  `json:"-"`
+	CreatedAt    string `json:"created_at"`
+}
+
+type TwoFactorStore struct {
+	db *sql.DB
+}
+
+func (s *TwoFactorStore) Get(ctx context.Context, userID int64) (*TwoFactor, error) {
+	query := `
+			SELECT user_id, secret, enabled, last_used_step, created_at
+			FROM user_two_factor
+			WHERE user_id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var tf TwoFactor
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(
+		&tf.UserID,
+		&tf.Secret,
+		&tf.Enabled,
+		&tf.LastUsedStep,
+		&tf.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &tf, nil
+}
+
+// CreatePending stores a new secret waiting for confirmation, replacing an
+// earlier unconfirmed one
+func (s *TwoFactorStore) CreatePending(ctx context.Context, userID int64, secret string) error {
+	query := `
+		INSERT INTO user_two_factor (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+			SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW()
+			WHERE user_two_factor.enabled = false
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrTwoFactorEnabled
+	}
+
+	return nil
+}
+
+// Enable turns on two-factor authentication and replaces the recovery codes
+func (s *TwoFactorStore) Enable(ctx context.Context, userID int64, step int64, recoveryCodeHashes []string) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+		UPDATE user_two_factor
+			SET enabled = true, last_used_step = $2
+			WHERE user_id = $1 AND enabled = false
+		`
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		res, err := tx.ExecContext(ctx, query, userID, step)
+		if err != nil {
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrRecordNotFound
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+
+		for _, hash := range recoveryCodeHashes {
+			query := `
+				INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)
+			`
+			if _, err := tx.ExecContext(ctx, query, userID, hash); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// UseStep records the time step of an accepted code. A code from the same or
+// an earlier step is rejected so a code can only be used once.
+func (s *TwoFactorStore) UseStep(ctx context.Context, userID int64, step int64) error {
+	query := `
+		UPDATE user_two_factor
+			SET last_used_step = $2
+			WHERE user_id = $1 AND enabled = true AND last_used_step < $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrCodeAlreadyUsed
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used
+func (s *TwoFactorStore) UseRecoveryCode(ctx context.Context, userID int64, hash string) error {
+	query := `
+		UPDATE recovery_codes
+			SET used_at = NOW()
+			WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, hash)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Disable removes the secret and the recovery codes of a user
+func (s *TwoFactorStore) Disable(ctx context.Context, userID int64) error {
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx, `DELETE FROM user_two_factor WHERE user_id = $1`, userID)
+		return err
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -16,6 +17,11 @@ type User struct {
 	Password    password `json:"-"`
 	CreatedAt   string   `json:"created_at"`
 	IsActivated bool     `json:"is_activated"`
+	IsSuspended bool     `json:"is_suspended"`
+	IsDisabled  bool     `json:"is_disabled"`
+	DisplayName string   `json:"display_name"`
+	Bio         string   `json:"bio"`
+	AvatarURL   string   `json:"avatar_url"`
 	Role        Role
 }
 
@@ -27,7 +33,7 @@ type password struct {
 var (
 	ErrDuplicateEmail    = errors.New("a user with that email already exists")
 	ErrDuplicateUsername = errors.New("a user with that username already exists")
-	ErrInvalidToken      = errors.New("nnvalid token or expired")
+	ErrInvalidToken      = errors.New("invalid or expired token")
 )
 
 func (p *password) Set(text string) error {
@@ -42,7 +48,24 @@ func (p *password) Set(text string) error {
 	return nil
 }
 
+// dummyHash is compared against when there is no password to check, so a
+// missing user or a cleared password takes as long as a wrong password
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// IsSet reports whether there is a password to compare against. Users created
+// through social login have none.
+func (p *password) IsSet() bool {
+	return len(p.hash) > 0
+}
+
 func (p *password) Compare(plainText string) error {
+	if len(p.hash) == 0 {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(plainText))
+		return bcrypt.ErrMismatchedHashAndPassword
+	}
 	return bcrypt.CompareHashAndPassword(p.hash, []byte(plainText))
 }
 
@@ -94,6 +117,12 @@ func (s *UsersStore) GetUserbyID(ctx context.Context, id int64) (*User, error) {
 				u.username,
 				u.email,
 				u.created_at,
+				u.is_active,
+				u.is_suspended,
+				u.disabled_at IS NOT NULL,
+				u.display_name,
+				u.bio,
+				u.avatar_url,
 				r.id,
 				r.name,
 				r.level
@@ -113,6 +142,12 @@ func (s *UsersStore) GetUserbyID(ctx context.Context, id int64) (*User, error) {
 		&user.Username,
 		&user.Email,
 		&user.CreatedAt,
+		&user.IsActivated,
+		&user.IsSuspended,
+		&user.IsDisabled,
+		&user.DisplayName,
+		&user.Bio,
+		&user.AvatarURL,
 		&user.Role.ID,
 		&user.Role.Name,
 		&user.Role.Level,
@@ -166,15 +201,18 @@ func (s *UsersStore) createUserInvitation(ctx context.Context, tx *sql.Tx, hasht
 	return nil
 }
 
-func (s *UsersStore) Activate(ctx context.Context, hashtoken string) error {
+// Activate activates the owner of the invitation token and returns their ID
+func (s *UsersStore) Activate(ctx context.Context, hashtoken string) (int64, error) {
 
-	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+	var userID int64
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
 
 		//  get user from token
 		user, err := s.getUserFromToken(ctx, tx, hashtoken)
 		if err != nil {
 			return err
 		}
+		userID = user.ID
 
 		// update user status
 		if err := s.updateStatus(ctx, tx, user.ID); err != nil {
@@ -190,6 +228,7 @@ func (s *UsersStore) Activate(ctx context.Context, hashtoken string) error {
 
 	})
 
+	return userID, err
 }
 
 func (s *UsersStore) getUserFromToken(ctx context.Context, tx *sql.Tx, hashtoken string) (*User, error) {
@@ -291,7 +330,8 @@ func (s *UsersStore) GetUserByEmail(ctx context.Context, emil string) (*User, er
 				username,
 				email,
 				created_at,
-				password
+				password,
+				disabled_at IS NOT NULL
 			
 			FROM users WHERE email = $1 AND is_active = true
 	`
@@ -309,6 +349,7 @@ func (s *UsersStore) GetUserByEmail(ctx context.Context, emil string) (*User, er
 		&user.Email,
 		&user.CreatedAt,
 		&user.Password.hash,
+		&user.IsDisabled,
 	)
 	if err != nil {
 		switch {
@@ -322,3 +363,398 @@ func (s *UsersStore) GetUserByEmail(ctx context.Context, emil string) (*User, er
 
 	return &user, nil
 }
+
+func (s *UsersStore) GetUsers(ctx context.Context, uq PaginatedUserQuery) ([]User, error) {
+
+	query := `
+			SELECT
+				u.id,
+				u.username,
+				u.email,
+				u.created_at,
+				u.is_active,
+				u.is_suspended,
+				u.disabled_at IS NOT NULL,
+				u.display_name,
+				u.bio,
+				u.avatar_url,
+				r.id,
+				r.name,
+				r.level
+			FROM users u JOIN roles r on u.role_id = r.id
+			WHERE
+				(u.username ILIKE '%' || $1 || '%' OR u.email ILIKE '%' || $1 || '%')
+				AND
+				(r.name = $2 OR $2 = '')
+			ORDER BY u.created_at ` + uq.Sort + `
+			LIMIT $3
+			OFFSET $4
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, uq.Search, uq.Role, uq.Limit, uq.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&user.ID,
+			&user.Username,
+			&user.Email,
+			&user.CreatedAt,
+			&user.IsActivated,
+			&user.IsSuspended,
+			&user.IsDisabled,
+			&user.DisplayName,
+			&user.Bio,
+			&user.AvatarURL,
+			&user.Role.ID,
+			&user.Role.Name,
+			&user.Role.Level,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+func (s *UsersStore) UpdateRole(ctx context.Context, userID int64, roleName string) (*Role, error) {
+
+	query := `
+	UPDATE users u
+		SET role_id = r.id
+		FROM roles r
+		WHERE u.id = $1 AND r.name = $2
+		RETURNING r.id, r.name, r.level
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var role Role
+	if err := s.db.QueryRowContext(ctx, query, userID, roleName).Scan(&role.ID, &role.Name, &role.Level); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &role, nil
+}
+
+// SetDisabled disables or re-enables an account. It is separate from
+// is_active, which only records that the email was confirmed.
+func (s *UsersStore) SetDisabled(ctx context.Context, userID int64, disabled bool) error {
+
+	query := `
+	UPDATE users
+		SET disabled_at = CASE WHEN $1 THEN COALESCE(disabled_at, NOW()) END
+		WHERE id = $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, disabled, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// ForcePasswordReset clears the user's password so it can no longer be used
+// and stores a reset token that lets the user choose a new one. The user's
+// sessions and API keys are revoked, it returns the revoked session IDs.
+func (s *UsersStore) ForcePasswordReset(ctx context.Context, userID int64, hashtoken string, resetExp time.Duration) ([]string, error) {
+	var sessionIDs []string
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+
+		if err := s.clearPassword(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		ids, err := s.revokeCredentials(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		sessionIDs = ids
+
+		// only the latest reset link stays valid
+		if err := s.deletePasswordReset(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		query := `
+			INSERT INTO password_resets (token, user_id, expiry)
+			VALUES ($1, $2, $3)
+		`
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		if _, err := tx.ExecContext(ctx, query, hashtoken, userID, time.Now().Add(resetExp)); err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sessionIDs, nil
+}
+
+// ResetPassword stores the password set on user for the owner of the reset
+// token and fills in user.ID. Whoever held the old password loses access, the
+// user's sessions and API keys are revoked and the session IDs returned.
+func (s *UsersStore) ResetPassword(ctx context.Context, hashtoken string, user *User) ([]string, error) {
+	var sessionIDs []string
+
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+
+		query := `
+			SELECT user_id FROM password_resets
+			WHERE token = $1 AND expiry > $2
+		`
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		if err := tx.QueryRowContext(qctx, query, hashtoken, time.Now()).Scan(&user.ID); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrInvalidToken
+			default:
+				return err
+			}
+		}
+
+		if err := s.updatePassword(ctx, tx, user); err != nil {
+			return err
+		}
+
+		ids, err := s.revokeCredentials(ctx, tx, user.ID)
+		if err != nil {
+			return err
+		}
+		sessionIDs = ids
+
+		return s.deletePasswordReset(ctx, tx, user.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sessionIDs, nil
+}
+
+// revokeCredentials deletes every session and API key of the user and returns
+// the session IDs, so cached sessions can be dropped
+func (s *UsersStore) revokeCredentials(ctx context.Context, tx *sql.Tx, userID int64) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	var sessionIDs []string
+	err := queryRows(ctx, tx, `DELETE FROM sessions WHERE user_id = $1 RETURNING id`, []any{userID}, func(rows *sql.Rows) error {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		sessionIDs = append(sessionIDs, id)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM api_keys WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+
+	return sessionIDs, nil
+}
+
+func (s *UsersStore) clearPassword(ctx context.Context, tx *sql.Tx, userID int64) error {
+
+	query := `
+	UPDATE users
+		SET password = ''::bytea
+		WHERE id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	res, err := tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (s *UsersStore) updatePassword(ctx context.Context, tx *sql.Tx, user *User) error {
+
+	query := `
+	UPDATE users
+		SET password = $1
+		WHERE id = $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	if _, err := tx.ExecContext(ctx, query, user.Password.hash, user.ID); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *UsersStore) deletePasswordReset(ctx context.Context, tx *sql.Tx, userID int64) error {
+
+	query := `
+	DELETE FROM password_resets
+	WHERE user_id = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *UsersStore) UpdateProfile(ctx context.Context, user *User) error {
+
+	query := `
+	UPDATE users
+		SET display_name = $1, bio = $2, avatar_url = $3
+		WHERE id = $4
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, user.DisplayName, user.Bio, user.AvatarURL, user.ID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// UpdatePassword stores the password set on user. Pending reset links stop
+// working, they were sent for the old password.
+func (s *UsersStore) UpdatePassword(ctx context.Context, user *User) error {
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+
+		if err := s.updatePassword(ctx, tx, user); err != nil {
+			return err
+		}
+
+		return s.deletePasswordReset(ctx, tx, user.ID)
+	})
+}
+
+// RequestEmailChange stores a token that moves the user to newEmail once the
+// new address confirms it. Only the latest request stays valid.
+func (s *UsersStore) RequestEmailChange(ctx context.Context, userID int64, newEmail string, hashtoken string, exp time.Duration) error {
+
+	return withTx(s.db, ctx, func(tx *sql.Tx) error {
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		var taken bool
+		if err := tx.QueryRowContext(qctx, `SELECT EXISTS (SELECT 1 FROM users WHERE email = $1)`, newEmail).Scan(&taken); err != nil {
+			return err
+		}
+		if taken {
+			return ErrDuplicateEmail
+		}
+
+		if _, err := tx.ExecContext(qctx, `DELETE FROM email_changes WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+
+		query := `
+			INSERT INTO email_changes (token, user_id, new_email, expiry)
+			VALUES ($1, $2, $3, $4)
+		`
+		if _, err := tx.ExecContext(qctx, query, hashtoken, userID, newEmail, time.Now().Add(exp)); err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+// ConfirmEmailChange moves the owner of the token to the new address and
+// returns their ID.
+func (s *UsersStore) ConfirmEmailChange(ctx context.Context, hashtoken string) (int64, error) {
+
+	var userID int64
+	err := withTx(s.db, ctx, func(tx *sql.Tx) error {
+		qctx, cancel := context.WithTimeout(ctx, QueryTimeOutDuration)
+		defer cancel()
+
+		query := `
+			DELETE FROM email_changes
+			WHERE token = $1 AND expiry > $2
+			RETURNING user_id, new_email
+		`
+		var newEmail string
+		if err := tx.QueryRowContext(qctx, query, hashtoken, time.Now()).Scan(&userID, &newEmail); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrInvalidToken
+			default:
+				return err
+			}
+		}
+
+		if _, err := tx.ExecContext(qctx, `UPDATE users SET email = $1 WHERE id = $2`, newEmail, userID); err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+				return ErrDuplicateEmail
+			default:
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return userID, err
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"sync"
 )
 
@@ -14,11 +15,29 @@ type UpdatePostPayload struct {
 	Content *string `json:"content"`
 }
 
-func updatePost(postID int, p UpdatePostPayload, wg *sync.WaitGroup) {
-	defer wg.Done()
+// getETag fetches the post once so every writer starts from the same version
+func getETag(url, token string) (string, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
 
-	// Construct the URL for the update endpoint
-	url := fmt.Sprintf("http://localhost:3000/v1/posts/%d", postID)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return resp.Header.Get("ETag"), nil
+}
+
+func updatePost(url, token, etag string, p UpdatePostPayload, statuses chan<- int, wg *sync.WaitGroup) {
+	defer wg.Done()
 
 	// Create the JSON payload
 	b, _ := json.Marshal(p)
@@ -29,12 +48,12 @@ func updatePost(postID int, p UpdatePostPayload, wg *sync.WaitGroup) {
 		return
 	}
 
-	// Set headers as needed, for example:
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("If-Match", etag)
 
 	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		fmt.Println("Error sending request:", err)
 		return
@@ -50,23 +69,54 @@ func updatePost(postID int, p UpdatePostPayload, wg *sync.WaitGroup) {
 
 	fmt.Println("Update response status:", resp.Status)
 	fmt.Printf("Response body: %s\n", string(body))
+	statuses <- resp.StatusCode
 }
 
+// All writers send the same If-Match, so exactly one update must succeed and
+// every other one must get 412 Precondition Failed.
+//
+// Usage: TOKEN=<jwt of the post owner> go run scripts/test_concurrency.go
 func main() {
 	var wg sync.WaitGroup
 
-	// Assuming the post ID to update is 1
+	token := os.Getenv("TOKEN")
+	if token == "" {
+		fmt.Println("TOKEN is required")
+		os.Exit(1)
+	}
+
 	postID := 3
+	url := fmt.Sprintf("http://localhost:3000/v1/posts/%d", postID)
+
+	etag, err := getETag(url, token)
+	if err != nil {
+		fmt.Println("Error fetching post:", err)
+		os.Exit(1)
+	}
 
 	numRequests := 10 // More requests = higher chance of collision
 
+	statuses := make(chan int, numRequests*2)
 	wg.Add(numRequests * 2)
 
 	for i := 0; i < numRequests; i++ {
 		content := fmt.Sprintf("NEW content FROM USER A TEST %d", i)
 		title := fmt.Sprintf("NEW title FROM USER A TEST %d", i)
-		go updatePost(postID, UpdatePostPayload{Title: &title}, &wg)
-		go updatePost(postID, UpdatePostPayload{Content: &content}, &wg)
+		go updatePost(url, token, etag, UpdatePostPayload{Title: &title}, statuses, &wg)
+		go updatePost(url, token, etag, UpdatePostPayload{Content: &content}, statuses, &wg)
 	}
 	wg.Wait()
+	close(statuses)
+
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+
+	fmt.Printf("200 OK: %d, 412 Precondition Failed: %d\n", counts[http.StatusOK], counts[http.StatusPreconditionFailed])
+	if counts[http.StatusOK] != 1 || counts[http.StatusPreconditionFailed] != numRequests*2-1 {
+		fmt.Println("FAIL: expected exactly one successful update")
+		os.Exit(1)
+	}
+	fmt.Println("PASS")
 }